@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiPlatform is the ChatRequest platform of HTTP API requests, so
+	// routing rules, personas and limits can tell them apart.
+	apiPlatform = "api"
+	// apiMaxBodyBytes bounds one request body.
+	apiMaxBodyBytes = 1 << 20
+	// defaultAPIRateLimit is each key's requests per minute when
+	// API_RATE_LIMIT is not set.
+	defaultAPIRateLimit = 60
+)
+
+// apiIDPattern is what session IDs and end-user names may look like.
+var apiIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,128}$`)
+
+// APIKey is who an HTTP API key acts as: the API user Name, whose sessions
+// and limits it shares with nobody else.
+type APIKey struct {
+	Name string
+	// RatePerMinute limits the key's requests; 0 is unlimited.
+	RatePerMinute int
+}
+
+// ChatAPI serves AIService over HTTP with API keys:
+//
+//	POST   /v1/chat            {"message", "session_id"?, "user"?}
+//	GET    /v1/sessions/{id}   ?user=
+//	DELETE /v1/sessions/{id}   ?user=
+//
+// Each session ID is its own conversation, kept in the ChatRequest channel
+// "api:<key name>:<session ID>" so it can't be mistaken for a Slack or
+// Discord channel. The optional "user" lets an app keep its own users
+// apart; each becomes the API user "<key name>/<user>".
+type ChatAPI struct {
+	svc   *AIService
+	keys  map[string]APIKey
+	rates RateStore
+	now   func() time.Time
+}
+
+// NewChatAPI serves svc to the given keys. Per-key limits are kept in the
+// session store, like the chat limits.
+func NewChatAPI(svc *AIService, keys map[string]APIKey) *ChatAPI {
+	rates, ok := svc.store.(RateStore)
+	if !ok {
+		rates = NewInMemorySessionStore(SessionRetention{})
+	}
+	return &ChatAPI{svc: svc, keys: keys, rates: rates, now: time.Now}
+}
+
+// apiError is the body of every error response.
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = message
+	writeAPIJSON(w, status, body)
+}
+
+// Handler routes the API. Unknown paths get a JSON 404 too.
+func (a *ChatAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat", a.authenticated(a.serveChat))
+	mux.HandleFunc("GET /v1/sessions/{id}", a.authenticated(a.serveSession))
+	mux.HandleFunc("DELETE /v1/sessions/{id}", a.authenticated(a.serveForget))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+	return mux
+}
+
+// writeRateLimited answers 429 with a Retry-After of at least a second.
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := max(int((wait+time.Second-1)/time.Second), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeAPIError(w, http.StatusTooManyRequests, "rate_limited", message)
+}
+
+// authenticated resolves the API key, sent as a bearer token or in
+// X-API-Key, and applies the key's rate limit before calling next.
+func (a *ChatAPI) authenticated(next func(http.ResponseWriter, *http.Request, APIKey)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.Header.Get("X-API-Key")
+		}
+		var key APIKey
+		found := false
+		if token != "" {
+			for known, k := range a.keys {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+					key, found = k, true
+					break
+				}
+			}
+		}
+		if !found {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kit-api"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing or unknown API key")
+			return
+		}
+		if key.RatePerMinute > 0 {
+			allowed, wait := a.rates.TakeToken("rate:apikey:"+key.Name, key.RatePerMinute, rateWindow, a.now())
+			if !allowed {
+				log.Printf("🚦 Rate limited API key %s", key.Name)
+				writeRateLimited(w, wait, fmt.Sprintf("rate limit of %d requests per minute reached; retry in %s", key.RatePerMinute, max(wait.Round(time.Second), time.Second)))
+				return
+			}
+		}
+		next(w, r, key)
+	}
+}
+
+// channelID is the ChatRequest channel of one of the key's sessions.
+// Namespacing it keeps API clients from borrowing the routing rules, limits
+// and tools of a real channel by sending its ID as a session ID.
+func (key APIKey) channelID(sessionID string) string {
+	return apiPlatform + ":" + key.Name + ":" + sessionID
+}
+
+// userID is the ChatRequest user for key, or for one of its app's users.
+func (key APIKey) userID(user string) (string, error) {
+	if user == "" {
+		return key.Name, nil
+	}
+	if !apiIDPattern.MatchString(user) {
+		return "", errors.New("user must be 1-128 letters, digits, '.', '_', '@' or '-'")
+	}
+	return key.Name + "/" + user, nil
+}
+
+type apiChatRequest struct {
+	Message   string `json:"message"`
+	SessionID string `json:"session_id"`
+	User      string `json:"user"`
+}
+
+type apiChatResponse struct {
+	SessionID string `json:"session_id"`
+	User      string `json:"user,omitempty"`
+	Reply     string `json:"reply"`
+}
+
+func (a *ChatAPI) serveChat(w http.ResponseWriter, r *http.Request, key APIKey) {
+	var body apiChatRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "too_large", "request body is over 1 MB")
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if strings.TrimSpace(body.Message) == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "message is required")
+		return
+	}
+	userID, err := key.userID(body.User)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if body.SessionID == "" {
+		body.SessionID = newAPISessionID()
+	} else if !apiIDPattern.MatchString(body.SessionID) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "session_id must be 1-128 letters, digits, '.', '_', '@' or '-'")
+		return
+	}
+
+	reply, err := a.svc.RespondResult(r.Context(), ChatRequest{
+		Platform:  apiPlatform,
+		UserID:    userID,
+		ChannelID: key.channelID(body.SessionID),
+		Message:   body.Message,
+	})
+	if r.Context().Err() != nil {
+		return // the client went away
+	}
+	var limited *RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		writeRateLimited(w, limited.RetryAfter, limited.Reply)
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusServiceUnavailable, "unavailable", "no AI provider could answer; try again later")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, apiChatResponse{SessionID: body.SessionID, User: body.User, Reply: reply})
+}
+
+func newAPISessionID() string {
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type apiSession struct {
+	SessionID string        `json:"session_id"`
+	User      string        `json:"user,omitempty"`
+	Messages  []ChatMessage `json:"messages"`
+	Summary   string        `json:"summary,omitempty"`
+	Provider  string        `json:"provider,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// sessionTarget reads the session ID and user of a sessions request,
+// answering with an error when they are invalid.
+func sessionTarget(w http.ResponseWriter, r *http.Request, key APIKey) (userID, sessionID string, ok bool) {
+	sessionID = r.PathValue("id")
+	if !apiIDPattern.MatchString(sessionID) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "invalid session ID")
+		return "", "", false
+	}
+	userID, err := key.userID(r.URL.Query().Get("user"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return "", "", false
+	}
+	return userID, sessionID, true
+}
+
+func (a *ChatAPI) serveSession(w http.ResponseWriter, r *http.Request, key APIKey) {
+	userID, sessionID, ok := sessionTarget(w, r, key)
+	if !ok {
+		return
+	}
+	sessions, err := a.svc.UserSessions(apiPlatform, userID)
+	if err != nil {
+		log.Printf("⚠️  API session lookup failed: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "could not read sessions")
+		return
+	}
+	for _, session := range sessions {
+		if session.ChannelID != key.channelID(sessionID) {
+			continue
+		}
+		messages := session.Messages
+		if messages == nil {
+			messages = []ChatMessage{}
+		}
+		writeAPIJSON(w, http.StatusOK, apiSession{
+			SessionID: sessionID,
+			User:      r.URL.Query().Get("user"),
+			Messages:  messages,
+			Summary:   session.Summary,
+			Provider:  session.Provider,
+			UpdatedAt: session.UpdatedAt,
+		})
+		return
+	}
+	writeAPIError(w, http.StatusNotFound, "not_found", "no such session")
+}
+
+func (a *ChatAPI) serveForget(w http.ResponseWriter, r *http.Request, key APIKey) {
+	userID, sessionID, ok := sessionTarget(w, r, key)
+	if !ok {
+		return
+	}
+	removed, err := a.svc.ForgetSession(apiPlatform, userID, key.channelID(sessionID))
+	switch {
+	case err != nil:
+		log.Printf("⚠️  API session delete failed: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "could not delete the session")
+	case !removed:
+		writeAPIError(w, http.StatusNotFound, "not_found", "no such session")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListenAndServe serves the API on addr until ctx is canceled.
+func (a *ChatAPI) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: a.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("🌐 Chat API listening on %s (%d keys)", addr, len(a.keys))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// apiKeysFromEnv reads API_KEYS, a comma-separated list of key=name or
+// key=name:requests-per-minute entries. Keys without a rate get
+// API_RATE_LIMIT (60 by default; 0 is unlimited).
+func apiKeysFromEnv() (map[string]APIKey, error) {
+	rate := defaultAPIRateLimit
+	if value := os.Getenv("API_RATE_LIMIT"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("API_RATE_LIMIT: %q is not a number of requests per minute", value)
+		}
+		rate = n
+	}
+	keys := make(map[string]APIKey)
+	names := make(map[string]bool)
+	for _, entry := range splitList(os.Getenv("API_KEYS")) {
+		token, ref, ok := strings.Cut(entry, "=")
+		token = strings.TrimSpace(token)
+		name, limit, limited := strings.Cut(strings.TrimSpace(ref), ":")
+		if !ok || token == "" || !apiIDPattern.MatchString(name) {
+			return nil, errors.New("API_KEYS entries must look like key=name or key=name:60, with a name of letters, digits, '.', '_', '@' or '-'")
+		}
+		if names[name] {
+			return nil, fmt.Errorf("API_KEYS: %q is used by two keys", name)
+		}
+		names[name] = true
+		key := APIKey{Name: name, RatePerMinute: rate}
+		if limited {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("API_KEYS: %q is not a number of requests per minute", limit)
+			}
+			key.RatePerMinute = n
+		}
+		keys[token] = key
+	}
+	return keys, nil
+}
+
+// startChatAPIFromEnv serves the HTTP chat API on API_ADDR alongside the
+// chat adapters, when it is set and API_KEYS has at least one key.
+func startChatAPIFromEnv(ctx context.Context, svc *AIService) {
+	addr := os.Getenv("API_ADDR")
+	if addr == "" {
+		return
+	}
+	keys, err := apiKeysFromEnv()
+	switch {
+	case err != nil:
+		log.Printf("❌ Chat API not started: %v", err)
+		return
+	case len(keys) == 0:
+		log.Println("❌ Chat API not started: API_ADDR is set but API_KEYS is empty")
+		return
+	}
+	go func() {
+		if err := NewChatAPI(svc, keys).ListenAndServe(ctx, addr); err != nil {
+			log.Printf("❌ Chat API stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestChatAPI serves an echo provider to an unlimited key and a key
+// allowed two requests a minute.
+func newTestChatAPI(t *testing.T) (*httptest.Server, *AIService) {
+	t.Helper()
+	echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		return Reply{Text: "re: " + message}, nil
+	}}
+	svc := NewAIService(nil, nil, echo)
+	server := httptest.NewServer(NewChatAPI(svc, map[string]APIKey{
+		"web-key":    {Name: "webapp"},
+		"script-key": {Name: "scripts", RatePerMinute: 2},
+	}).Handler())
+	t.Cleanup(server.Close)
+	return server, svc
+}
+
+// apiDo sends a request with the key and decodes the JSON response into
+// out, returning the status.
+func apiDo(t *testing.T, server *httptest.Server, method, path, key, body string, out any) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: bad JSON %q: %v", method, path, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestChatAPISessions(t *testing.T) {
+	server, svc := newTestChatAPI(t)
+
+	var chat apiChatResponse
+	if status := apiDo(t, server, "POST", "/v1/chat", "web-key", `{"message":"hello","session_id":"s1","user":"ana"}`, &chat); status != http.StatusOK || chat.Reply != "re: hello" || chat.SessionID != "s1" {
+		t.Fatalf("chat: %d %+v", status, chat)
+	}
+	apiDo(t, server, "POST", "/v1/chat", "web-key", `{"message":"again","session_id":"s1","user":"ana"}`, &chat)
+	// The API is one more platform on the shared store
+	if sessions, _ := svc.UserSessions(apiPlatform, "webapp/ana"); len(sessions) != 1 || len(sessions[0].Messages) != 4 || sessions[0].ChannelID != "api:webapp:s1" {
+		t.Fatalf("stored sessions: %+v", sessions)
+	}
+
+	var fresh apiChatResponse
+	apiDo(t, server, "POST", "/v1/chat", "web-key", `{"message":"new topic"}`, &fresh)
+	if len(fresh.SessionID) != 24 || fresh.SessionID == "s1" {
+		t.Fatalf("no session ID generated: %+v", fresh)
+	}
+
+	var session apiSession
+	if status := apiDo(t, server, "GET", "/v1/sessions/s1?user=ana", "web-key", "", &session); status != http.StatusOK || len(session.Messages) != 4 || session.Messages[3].Content != "re: again" {
+		t.Fatalf("get session: %d %+v", status, session)
+	}
+	// Sessions belong to the key and the app's user
+	var apiErr apiError
+	if status := apiDo(t, server, "GET", "/v1/sessions/s1", "web-key", "", &apiErr); status != http.StatusNotFound || apiErr.Error.Code != "not_found" {
+		t.Fatalf("another user's session: %d %+v", status, apiErr)
+	}
+	if status := apiDo(t, server, "GET", "/v1/sessions/s1?user=ana", "script-key", "", nil); status != http.StatusNotFound {
+		t.Fatalf("another key's session: %d", status)
+	}
+
+	if status := apiDo(t, server, "DELETE", "/v1/sessions/s1?user=ana", "web-key", "", nil); status != http.StatusNoContent {
+		t.Fatalf("delete: %d", status)
+	}
+	if status := apiDo(t, server, "DELETE", "/v1/sessions/s1?user=ana", "web-key", "", nil); status != http.StatusNotFound {
+		t.Fatalf("second delete: %d", status)
+	}
+	if sessions, _ := svc.UserSessions(apiPlatform, "webapp"); len(sessions) != 1 {
+		t.Fatalf("delete removed other sessions: %+v", sessions)
+	}
+}
+
+func TestChatAPIErrorsAndLimits(t *testing.T) {
+	server, _ := newTestChatAPI(t)
+
+	for _, tc := range []struct {
+		method, path, key, body string
+		status                  int
+		code                    string
+	}{
+		{"POST", "/v1/chat", "", `{"message":"hi"}`, http.StatusUnauthorized, "unauthorized"},
+		{"POST", "/v1/chat", "wrong", `{"message":"hi"}`, http.StatusUnauthorized, "unauthorized"},
+		{"POST", "/v1/chat", "web-key", `{"message":`, http.StatusBadRequest, "invalid_json"},
+		{"POST", "/v1/chat", "web-key", `{"message":"hi","channel":"x"}`, http.StatusBadRequest, "invalid_json"},
+		{"POST", "/v1/chat", "web-key", `{"message":"  "}`, http.StatusBadRequest, "invalid_request"},
+		{"POST", "/v1/chat", "web-key", `{"message":"hi","session_id":"a/b"}`, http.StatusBadRequest, "invalid_request"},
+		{"POST", "/v1/chat", "web-key", `{"message":"hi","user":"x y"}`, http.StatusBadRequest, "invalid_request"},
+		{"GET", "/v1/sessions/missing", "web-key", "", http.StatusNotFound, "not_found"},
+		{"GET", "/v2/chat", "web-key", "", http.StatusNotFound, "not_found"},
+	} {
+		var apiErr apiError
+		if status := apiDo(t, server, tc.method, tc.path, tc.key, tc.body, &apiErr); status != tc.status || apiErr.Error.Code != tc.code || apiErr.Error.Message == "" {
+			t.Fatalf("%s %s %s: %d %+v", tc.method, tc.path, tc.body, status, apiErr)
+		}
+	}
+
+	// X-API-Key works as well as a bearer token
+	req, _ := http.NewRequest("POST", server.URL+"/v1/chat", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("X-API-Key", "script-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("X-API-Key: %d", resp.StatusCode)
+	}
+
+	// scripts gets two requests a minute; webapp is not slowed down by it
+	apiDo(t, server, "POST", "/v1/chat", "script-key", `{"message":"hi"}`, nil)
+	var apiErr apiError
+	if status := apiDo(t, server, "POST", "/v1/chat", "script-key", `{"message":"hi"}`, &apiErr); status != http.StatusTooManyRequests || apiErr.Error.Code != "rate_limited" {
+		t.Fatalf("third request: %d %+v", status, apiErr)
+	}
+	if status := apiDo(t, server, "POST", "/v1/chat", "web-key", `{"message":"hi"}`, nil); status != http.StatusOK {
+		t.Fatalf("other key limited: %d", status)
+	}
+}
+
+func TestChatAPIRefusalsAndFailures(t *testing.T) {
+	failing := providerFunc{name: "down", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		return Reply{}, errors.New("503 from upstream")
+	}}
+	store := NewInMemorySessionStore(DefaultSessionRetention())
+	svc := NewAIService(store, func(string) string { return "Kit is taking a break." }, failing)
+	svc.SetRateLimiter(NewRateLimiter(RateLimits{PerUser: 1}, store))
+	server := httptest.NewServer(NewChatAPI(svc, map[string]APIKey{"web-key": {Name: "webapp"}}).Handler())
+	defer server.Close()
+
+	// The fallback text is for chat users; API clients get an error
+	var apiErr apiError
+	if status := apiDo(t, server, "POST", "/v1/chat", "web-key", `{"message":"hi"}`, &apiErr); status != http.StatusServiceUnavailable || apiErr.Error.Code != "unavailable" {
+		t.Fatalf("failed answer: %d %+v", status, apiErr)
+	}
+
+	req, _ := http.NewRequest("POST", server.URL+"/v1/chat", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("Authorization", "Bearer web-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	apiErr = apiError{}
+	_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+	if resp.StatusCode != http.StatusTooManyRequests || apiErr.Error.Code != "rate_limited" || !strings.Contains(apiErr.Error.Message, "slow down") {
+		t.Fatalf("limited request: %d %+v", resp.StatusCode, apiErr)
+	}
+	if wait, _ := strconv.Atoi(resp.Header.Get("Retry-After")); wait < 1 || wait > 60 {
+		t.Fatalf("Retry-After = %q", resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestAPIKeysFromEnv(t *testing.T) {
+	t.Setenv("API_RATE_LIMIT", "10")
+	t.Setenv("API_KEYS", "abc=webapp, def=scripts:0")
+	keys, err := apiKeysFromEnv()
+	if err != nil || keys["abc"] != (APIKey{Name: "webapp", RatePerMinute: 10}) || keys["def"] != (APIKey{Name: "scripts"}) {
+		t.Fatalf("keys = %+v, %v", keys, err)
+	}
+	for _, bad := range []string{"abc", "abc=", "abc=a/b", "abc=x,def=x", "abc=x:fast"} {
+		t.Setenv("API_KEYS", bad)
+		if _, err := apiKeysFromEnv(); err == nil {
+			t.Fatalf("%q was accepted", bad)
+		}
+	}
+}
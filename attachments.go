@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	defaultAttachmentFiles = 4
+	defaultAttachmentBytes = 10 << 20 // 10 MiB
+	// defaultAttachmentChars caps the text taken from each document, which
+	// also bounds how much of the prompt budget one file can use.
+	defaultAttachmentChars = 20000
+	// attachmentTimeout bounds downloading all of a message's attachments.
+	attachmentTimeout = 30 * time.Second
+)
+
+// defaultAttachmentTypes are the MIME types read when ATTACHMENT_TYPES is
+// not set. Entries ending in "/*" allow a whole family.
+var defaultAttachmentTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+	"text/plain", "text/markdown", "text/csv", "application/json",
+	"application/pdf",
+}
+
+// Attachment is a file sent with a chat message. Images are passed to
+// providers that can see them; the text of documents is extracted locally
+// and included in the message for every provider.
+type Attachment struct {
+	Name     string
+	MIMEType string
+	Data     []byte // images only
+	Text     string // extracted text of documents
+	// Skipped explains why the file was not read, e.g. "too large".
+	Skipped string
+}
+
+func (a Attachment) isImage() bool {
+	return a.Skipped == "" && strings.HasPrefix(a.MIMEType, "image/") && len(a.Data) > 0
+}
+
+// attachmentSource is a file offered by a platform adapter.
+type attachmentSource struct {
+	Name     string
+	URL      string
+	MIMEType string // as declared by the platform; may be empty
+	Size     int64  // as declared; 0 when unknown
+	Header   http.Header
+}
+
+// AttachmentLimits bounds which attachments are downloaded and read.
+type AttachmentLimits struct {
+	MaxFiles     int   // per message
+	MaxBytes     int64 // per file
+	MaxTextChars int   // extracted text kept per document
+	AllowedTypes []string
+}
+
+// DefaultAttachmentLimits returns the limits used when no ATTACHMENT_*
+// variables are set.
+func DefaultAttachmentLimits() AttachmentLimits {
+	return AttachmentLimits{
+		MaxFiles:     defaultAttachmentFiles,
+		MaxBytes:     defaultAttachmentBytes,
+		MaxTextChars: defaultAttachmentChars,
+		AllowedTypes: defaultAttachmentTypes,
+	}
+}
+
+// attachmentLimitsFromEnv reads ATTACHMENT_MAX_FILES, ATTACHMENT_MAX_BYTES,
+// ATTACHMENT_MAX_CHARS and ATTACHMENT_TYPES over the defaults.
+// ATTACHMENT_MAX_FILES=0 turns attachments off.
+func attachmentLimitsFromEnv() AttachmentLimits {
+	limits := DefaultAttachmentLimits()
+	if n, err := strconv.Atoi(os.Getenv("ATTACHMENT_MAX_FILES")); err == nil && n >= 0 {
+		limits.MaxFiles = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		limits.MaxBytes = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("ATTACHMENT_MAX_CHARS")); err == nil && n > 0 {
+		limits.MaxTextChars = n
+	}
+	if types := splitList(os.Getenv("ATTACHMENT_TYPES")); len(types) > 0 {
+		limits.AllowedTypes = types
+	}
+	return limits
+}
+
+func (l AttachmentLimits) allows(mimeType string) bool {
+	for _, allowed := range l.AllowedTypes {
+		if family, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mimeType, family+"/") {
+				return true
+			}
+		} else if mimeType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentClient downloads attachments; the adapters' sources are
+// platform CDN URLs.
+var attachmentClient = &http.Client{Timeout: attachmentTimeout}
+
+// load downloads and reads the sources within the limits. Files that are
+// not read are returned with Skipped set so the provider can tell the user.
+func (l AttachmentLimits) load(ctx context.Context, sources []attachmentSource) []Attachment {
+	if len(sources) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, attachmentTimeout)
+	defer cancel()
+
+	attachments := make([]Attachment, 0, len(sources))
+	for i, src := range sources {
+		if i >= l.MaxFiles {
+			attachments = append(attachments, Attachment{Name: src.Name, Skipped: fmt.Sprintf("only %d files are read per message", l.MaxFiles)})
+			continue
+		}
+		attachment, err := l.fetch(ctx, src)
+		if err != nil {
+			log.Printf("📎 Attachment %q not read: %v", src.Name, err)
+			attachment = Attachment{Name: src.Name, Skipped: err.Error()}
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+func (l AttachmentLimits) fetch(ctx context.Context, src attachmentSource) (Attachment, error) {
+	if src.Size > l.MaxBytes {
+		return Attachment{}, fmt.Errorf("larger than %s", formatBytes(l.MaxBytes))
+	}
+	if declared := baseMIMEType(src.MIMEType); declared != "" && !l.allows(declared) {
+		return Attachment{}, fmt.Errorf("file type %s is not allowed", declared)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return Attachment{}, err
+	}
+	for name, values := range src.Header {
+		req.Header[name] = values
+	}
+	resp, err := attachmentClient.Do(req) // #nosec G107 -- URL comes from the chat platform's attachment metadata
+	if err != nil {
+		return Attachment{}, fmt.Errorf("download failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Attachment{}, fmt.Errorf("download failed (status %d)", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, l.MaxBytes+1))
+	if err != nil {
+		return Attachment{}, fmt.Errorf("download failed")
+	}
+	if int64(len(data)) > l.MaxBytes {
+		return Attachment{}, fmt.Errorf("larger than %s", formatBytes(l.MaxBytes))
+	}
+	return l.read(src.Name, src.MIMEType, data)
+}
+
+// read checks the content's type against the allowlist and extracts what
+// providers need from it. The type is sniffed from the content; the declared
+// type only refines plain text (Markdown, CSV, JSON).
+func (l AttachmentLimits) read(name, declared string, data []byte) (Attachment, error) {
+	mimeType := baseMIMEType(http.DetectContentType(data))
+	if declared = baseMIMEType(declared); mimeType == "text/plain" && declared != "" && isTextType(declared) {
+		mimeType = declared
+	}
+	if !l.allows(mimeType) {
+		return Attachment{}, fmt.Errorf("file type %s is not allowed", mimeType)
+	}
+
+	attachment := Attachment{Name: name, MIMEType: mimeType}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		attachment.Data = data
+	case mimeType == "application/pdf":
+		text, err := extractPDFText(data)
+		if err != nil {
+			return Attachment{}, fmt.Errorf("could not read PDF: %w", err)
+		}
+		attachment.Text = truncateRunes(text, l.MaxTextChars)
+	case isTextType(mimeType):
+		if !utf8.Valid(data) {
+			return Attachment{}, fmt.Errorf("not UTF-8 text")
+		}
+		attachment.Text = truncateRunes(string(data), l.MaxTextChars)
+	default:
+		return Attachment{}, fmt.Errorf("file type %s can't be read", mimeType)
+	}
+	return attachment, nil
+}
+
+func baseMIMEType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+func isTextType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/json"
+}
+
+// extractPDFText returns the plain text of a PDF. Malformed files can make
+// the parser panic, which is reported as an error.
+func extractPDFText(data []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF")
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if _, err := io.Copy(&b, plain); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return "", fmt.Errorf("no text found (scanned PDF?)")
+	}
+	return b.String(), nil
+}
+
+func truncateRunes(text string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit]) + "\n[… truncated]"
+}
+
+func formatBytes(n int64) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%d MB", n>>20)
+	}
+	return fmt.Sprintf("%d KB", n>>10)
+}
+
+// withAttachments is the user turn sent to providers and kept in the
+// history: the message followed by each document's text and a note for
+// every image or skipped file. Image contents are passed separately.
+func withAttachments(message string, attachments []Attachment) string {
+	if len(attachments) == 0 {
+		return message
+	}
+	var b strings.Builder
+	if message == "" {
+		message = "Please take a look at the attached files."
+	}
+	b.WriteString(message)
+	for _, a := range attachments {
+		switch {
+		case a.Skipped != "":
+			fmt.Fprintf(&b, "\n\n[Attachment %s was not read: %s]", a.Name, a.Skipped)
+		case a.isImage():
+			fmt.Fprintf(&b, "\n\n[Attached image: %s]", a.Name)
+		default:
+			fmt.Fprintf(&b, "\n\n[Attached file: %s]\n%s\n[End of %s]", a.Name, a.Text, a.Name)
+		}
+	}
+	return b.String()
+}
+
+// imageAttachments returns the attachments providers may see as images.
+func imageAttachments(attachments []Attachment) []Attachment {
+	var images []Attachment
+	for _, a := range attachments {
+		if a.isImage() {
+			images = append(images, a)
+		}
+	}
+	return images
+}
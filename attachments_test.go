@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pngHeader is enough of a PNG for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// minimalPDF builds a one-page PDF showing text, with a correct xref table.
+func minimalPDF(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 712 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(b.String())
+}
+
+func TestAttachmentLimitsRead(t *testing.T) {
+	limits := DefaultAttachmentLimits()
+	limits.MaxTextChars = 10
+
+	image, err := limits.read("photo.png", "", pngHeader)
+	if err != nil || !image.isImage() || image.MIMEType != "image/png" {
+		t.Fatalf("png: %+v %v", image, err)
+	}
+	// The declared type refines plain text but can't relabel binary content
+	notes, err := limits.read("notes.md", "text/markdown; charset=utf-8", []byte("# Packing list: sleeping bag"))
+	if err != nil || notes.MIMEType != "text/markdown" || notes.Text != "# Packing \n[… truncated]" {
+		t.Fatalf("markdown: %+v %v", notes, err)
+	}
+	if _, err := limits.read("fake.txt", "text/plain", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00")); err == nil {
+		t.Fatal("binary content declared as text should be rejected")
+	}
+
+	limits.MaxTextChars = 1000
+	doc, err := limits.read("schedule.pdf", "application/pdf", minimalPDF("Camp starts Monday"))
+	if err != nil || !strings.Contains(doc.Text, "Camp starts Monday") {
+		t.Fatalf("pdf: %+v %v", doc, err)
+	}
+	if _, err := limits.read("broken.pdf", "", []byte("%PDF-1.4\ngarbage")); err == nil {
+		t.Fatal("malformed PDF should be reported, not panic")
+	}
+}
+
+func TestAttachmentLimitsLoad(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" && r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/big.txt":
+			w.Write([]byte(strings.Repeat("a", 2048)))
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer server.Close()
+
+	limits := DefaultAttachmentLimits()
+	limits.MaxFiles = 3
+	limits.MaxBytes = 1024
+	attachments := limits.load(context.Background(), []attachmentSource{
+		{Name: "a.txt", URL: server.URL + "/private", Header: http.Header{"Authorization": {"Bearer token"}}},
+		{Name: "big.txt", URL: server.URL + "/big.txt"}, // size not declared
+		{Name: "run.exe", URL: server.URL + "/x", MIMEType: "application/x-msdownload"},
+		{Name: "d.txt", URL: server.URL + "/d"},
+	})
+	if len(attachments) != 4 || attachments[0].Text != "hello" {
+		t.Fatalf("unexpected attachments %+v", attachments)
+	}
+	for i, want := range []string{"", "larger than 1 KB", "not allowed", "only 3 files"} {
+		if !strings.Contains(attachments[i].Skipped, want) || (want == "") != (attachments[i].Skipped == "") {
+			t.Fatalf("attachment %d skipped %q, want %q", i, attachments[i].Skipped, want)
+		}
+	}
+}
+
+func TestRespondWithAttachments(t *testing.T) {
+	var got Prompt
+	vision := providerFunc{
+		name: "vision",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			got = session.prompt(message)
+			return Reply{Text: "Nice tent!"}, nil
+		},
+	}
+	svc := NewAIService(nil, nil, vision)
+	svc.Respond(context.Background(), ChatRequest{
+		Platform: "discord", UserID: "u1", ChannelID: "c1",
+		Attachments: []Attachment{
+			{Name: "tent.png", MIMEType: "image/png", Data: pngHeader},
+			{Name: "list.txt", MIMEType: "text/plain", Text: "tent, stove"},
+			{Name: "run.exe", Skipped: "file type application/x-msdownload is not allowed"},
+		},
+	})
+
+	if len(got.Images) != 1 || got.Images[0].Name != "tent.png" {
+		t.Fatalf("images not passed: %+v", got.Images)
+	}
+	for _, want := range []string{"attached files", "[Attached image: tent.png]", "tent, stove", "run.exe was not read"} {
+		if !strings.Contains(got.Message, want) {
+			t.Fatalf("message missing %q:\n%s", want, got.Message)
+		}
+	}
+
+	text := got.textOnly()
+	if text.Images != nil || !strings.Contains(text.Message, "can't view images") {
+		t.Fatalf("text-only prompt: %+v", text)
+	}
+	messages := oaMessages(got)
+	if parts, ok := messages[len(messages)-1].Content.([]oaContentPart); !ok || len(parts) != 2 || !strings.HasPrefix(parts[1].ImageURL.URL, "data:image/png;base64,") {
+		t.Fatalf("vision message: %+v", messages[len(messages)-1])
+	}
+}
+
+func TestAttachmentsLoadOnlyPastTheLimiter(t *testing.T) {
+	var got string
+	echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		got = message
+		return Reply{Text: "ok"}, nil
+	}}
+	store := NewInMemorySessionStore(DefaultSessionRetention())
+	svc := NewAIService(store, nil, echo)
+	svc.SetRateLimiter(NewRateLimiter(RateLimits{PerUser: 1}, store))
+
+	downloads := 0
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", LoadAttachments: func(ctx context.Context) []Attachment {
+		downloads++
+		return []Attachment{{Name: "list.txt", MIMEType: "text/plain", Text: "tent, stove"}}
+	}}
+	svc.Respond(context.Background(), req)
+	if downloads != 1 || !strings.Contains(got, "tent, stove") {
+		t.Fatalf("%d downloads, message %q", downloads, got)
+	}
+	if reply := svc.Respond(context.Background(), req); !strings.Contains(reply, "slow down") || downloads != 1 {
+		t.Fatalf("limited request downloaded its files: %d downloads, reply %q", downloads, reply)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// defaultCacheTTL is how long a cached reply is served when
+	// RESPONSE_CACHE_TTL is not set.
+	defaultCacheTTL = 24 * time.Hour
+	// defaultCacheEntries caps the cache when RESPONSE_CACHE_MAX_ENTRIES is not set.
+	defaultCacheEntries = 500
+	// cacheFollowUpWindow: a message sent within this long of the previous
+	// turn may depend on it, so it is neither served from nor stored in the cache.
+	cacheFollowUpWindow = 10 * time.Minute
+	// embedTimeout bounds the embedding call made for a similarity lookup.
+	embedTimeout = 5 * time.Second
+)
+
+// Embedder turns text into a vector for similarity matching.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// ResponseCache answers repeated standalone questions without a provider
+// call. Lookups try an exact match on the normalized prompt first and then,
+// when an Embedder is set, the most similar cached prompt above the
+// threshold. Entries expire after the TTL and the least recently used are
+// evicted beyond maxEntries.
+type ResponseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element // scope and normalized prompt → *cacheEntry
+	lru        *list.List               // front is most recently used
+	embedder   Embedder
+	similarity float64
+	disabled   map[string]bool // channel IDs that opted out
+	stats      CacheStats
+	now        func() time.Time
+}
+
+type cacheEntry struct {
+	key      string
+	scope    string
+	prompt   string // normalized
+	response string
+	owner    string // userOwner of the user whose question it answers
+	vector   []float32
+	expires  time.Time
+}
+
+// CacheStats counts cache activity since startup.
+type CacheStats struct {
+	Entries     int
+	Hits        int // exact and similar
+	SimilarHits int
+	Misses      int
+}
+
+// NewResponseCache creates a cache; zero arguments use the defaults.
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	return &ResponseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		disabled:   make(map[string]bool),
+		now:        time.Now,
+	}
+}
+
+// SetEmbedder enables similarity matching: a prompt whose embedding has at
+// least threshold cosine similarity to a cached one reuses its reply.
+func (c *ResponseCache) SetEmbedder(embedder Embedder, threshold float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.embedder = embedder
+	c.similarity = threshold
+}
+
+// DisableChannel opts a channel out of caching.
+func (c *ResponseCache) DisableChannel(channelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disabled[channelID] = true
+}
+
+// normalizePrompt lowercases the prompt, drops punctuation and collapses
+// whitespace so trivial variations share an entry.
+func normalizePrompt(prompt string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(prompt) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// usableFor reports whether the request may be served from and stored in
+// the cache: the channel hasn't opted out, the user hasn't pinned a provider
+// and the message doesn't follow closely on an earlier turn it might refer to.
+func (c *ResponseCache) usableFor(req ChatRequest, session *Session) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	disabled := c.disabled[req.ChannelID]
+	c.mu.Unlock()
+	if disabled {
+		return false
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.Provider != "" {
+		return false
+	}
+	if n := len(session.Messages); n > 0 {
+		return c.now().Sub(session.Messages[n-1].Timestamp) > cacheFollowUpWindow
+	}
+	return true
+}
+
+// cacheScope is the scope of replies written by persona for platform on the
+// named route, so requests that would get another system prompt or provider
+// chain don't share answers.
+func cacheScope(persona, route, platform string) string {
+	return persona + "\x00" + route + "\x00" + platform
+}
+
+// cacheKey keys an entry by scope and normalized prompt.
+func cacheKey(scope, prompt string) string {
+	return scope + "\x00" + prompt
+}
+
+// lookup returns a cached reply for message within scope. On a miss it also
+// returns the message's embedding, if one was computed, so store can reuse it.
+func (c *ResponseCache) lookup(ctx context.Context, scope, message string) (string, []float32, bool) {
+	prompt := normalizePrompt(message)
+	if prompt == "" {
+		return "", nil, false
+	}
+	key := cacheKey(scope, prompt)
+
+	c.mu.Lock()
+	if response, ok := c.getLocked(key); ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return response, nil, true
+	}
+	embedder, threshold := c.embedder, c.similarity
+	c.mu.Unlock()
+
+	var vector []float32
+	if embedder != nil && threshold > 0 {
+		embedCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+		var err error
+		vector, err = embedder.Embed(embedCtx, message)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️  Cache embedding failed: %v", err)
+			vector = nil
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if vector != nil {
+		if response, ok := c.nearestLocked(scope, vector, threshold); ok {
+			c.stats.Hits++
+			c.stats.SimilarHits++
+			return response, nil, true
+		}
+	}
+	c.stats.Misses++
+	return "", vector, false
+}
+
+func (c *ResponseCache) getLocked(key string) (string, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.now().After(entry.expires) {
+		c.removeLocked(elem)
+		return "", false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.response, true
+}
+
+// nearestLocked scans the entries in scope for the most similar prompt. The cache is
+// small enough that a linear scan is cheaper than maintaining an index.
+func (c *ResponseCache) nearestLocked(scope string, vector []float32, threshold float64) (string, bool) {
+	var best *list.Element
+	bestScore := threshold
+	now := c.now()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*cacheEntry)
+		if now.After(entry.expires) {
+			c.removeLocked(elem)
+		} else if entry.scope == scope {
+			if score := cosineSimilarity(vector, entry.vector); score >= bestScore {
+				best, bestScore = elem, score
+			}
+		}
+		elem = next
+	}
+	if best == nil {
+		return "", false
+	}
+	c.lru.MoveToFront(best)
+	return best.Value.(*cacheEntry).response, true
+}
+
+// store caches response for message within scope on behalf of owner,
+// evicting the least recently used entry when the cache is full.
+func (c *ResponseCache) store(scope, owner, message, response string, vector []float32) {
+	prompt := normalizePrompt(message)
+	if c == nil || prompt == "" || strings.TrimSpace(response) == "" {
+		return
+	}
+	key := cacheKey(scope, prompt)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:      key,
+		scope:    scope,
+		prompt:   prompt,
+		response: response,
+		owner:    owner,
+		vector:   vector,
+		expires:  c.now().Add(c.ttl),
+	})
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *ResponseCache) removeLocked(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// Purge removes every entry whose normalized prompt contains match, or all
+// entries when match is empty, and returns how many were removed.
+func (c *ResponseCache) Purge(match string) int {
+	match = normalizePrompt(match)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if match == "" || strings.Contains(elem.Value.(*cacheEntry).prompt, match) {
+			c.removeLocked(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// ForgetOwner removes every entry stored for owner's questions and returns
+// how many were removed.
+func (c *ResponseCache) ForgetOwner(owner string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cacheEntry).owner == owner {
+			c.removeLocked(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// Stats returns the hit/miss counters and current size.
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// formatCacheStats renders cache activity for the status commands.
+func formatCacheStats(stats CacheStats) string {
+	line := fmt.Sprintf("• Response Cache: %d hits", stats.Hits)
+	if stats.SimilarHits > 0 {
+		line += fmt.Sprintf(" (%d similar)", stats.SimilarHits)
+	}
+	line += fmt.Sprintf(", %d misses, %d entries", stats.Misses, stats.Entries)
+	if total := stats.Hits + stats.Misses; total > 0 {
+		line += fmt.Sprintf(" (%d%% hit rate)", stats.Hits*100/total)
+	}
+	return line
+}
+
+// formatServiceStatus renders the AI lines of the status commands: provider
+// health, plus cache activity when the cache is enabled.
+func formatServiceStatus(svc *AIService, providers []ProviderStatus) string {
+	status := formatProviderStatus(providers)
+	if svc != nil {
+		if stats := svc.CacheStats(); stats != nil {
+			status += "\n" + formatCacheStats(*stats)
+		}
+	}
+	return status
+}
+
+// handleCacheCommand implements !cache and /kit cache: show stats, or let
+// admins clear entries, optionally only those matching some text.
+func handleCacheCommand(args, userID string) string {
+	if globalAIService == nil || globalAIService.cache == nil {
+		return "🗃️ The response cache is not enabled (set RESPONSE_CACHE=true)."
+	}
+	cache := globalAIService.cache
+	fields := strings.Fields(args)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "clear") {
+		return "🗃️ **Response Cache**\n" + formatCacheStats(cache.Stats())
+	}
+	if !isAdmin(userID) {
+		return "🔒 Only Kit admins can clear the response cache."
+	}
+	match := strings.Join(fields[1:], " ")
+	removed := cache.Purge(match)
+	log.Printf("🗃️  User %s cleared %d cache entries (match %q)", userID, removed, match)
+	return fmt.Sprintf("🗑️ Removed %d cached responses.", removed)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheServesRepeatedQuestions(t *testing.T) {
+	calls := 0
+	echo := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			calls++
+			return Reply{Text: "Register at the camp website."}, nil
+		},
+	}
+	svc := NewAIService(nil, nil, echo)
+	cache := NewResponseCache(time.Hour, 10)
+	svc.SetResponseCache(cache)
+
+	svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", ChannelID: "C1", Message: "How do I register for camp?"})
+	got := svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u2", ChannelID: "D2", Message: "how do i register for camp"})
+	if calls != 1 || got != "Register at the camp website." {
+		t.Fatalf("expected a cache hit, provider called %d times, got %q", calls, got)
+	}
+
+	// A quick follow-up in the same conversation may depend on context
+	svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u2", ChannelID: "D2", Message: "how do I register for camp?"})
+	if calls != 2 {
+		t.Fatalf("follow-up should bypass the cache, provider called %d times", calls)
+	}
+
+	// Another platform may be routed or prompted differently
+	svc.Respond(context.Background(), ChatRequest{Platform: "discord", UserID: "u3", ChannelID: "c3", Message: "How do I register for camp?"})
+	if calls != 3 {
+		t.Fatalf("answer shared across platforms, provider called %d times", calls)
+	}
+
+	// A reply written with history in the prompt is served from the cache
+	// later but not stored
+	cache.now = func() time.Time { return time.Now().Add(time.Hour) }
+	svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", ChannelID: "C1", Message: "Where is the camp?"})
+	svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u4", ChannelID: "C4", Message: "Where is the camp?"})
+	if calls != 5 {
+		t.Fatalf("reply to a prompt with history was cached, provider called %d times", calls)
+	}
+
+	if stats := svc.CacheStats(); stats.Hits != 1 || stats.Entries != 3 {
+		t.Fatalf("unexpected stats %+v", *stats)
+	}
+}
+
+type fakeEmbedder map[string][]float32
+
+func (f fakeEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	return f[text], nil
+}
+
+func TestResponseCacheSimilarityTTLAndPurge(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cache := NewResponseCache(time.Hour, 10)
+	cache.now = func() time.Time { return now }
+	cache.SetEmbedder(fakeEmbedder{
+		"What is Kit?":       {1, 0, 0},
+		"Who is Kit exactly": {0.95, 0.1, 0},
+		"Is camp full?":      {0, 1, 0},
+	}, 0.9)
+
+	_, vector, ok := cache.lookup(context.Background(), "kit", "What is Kit?")
+	if ok {
+		t.Fatal("empty cache should miss")
+	}
+	cache.store("kit", "slack:u1", "What is Kit?", "I'm Kit, a bot.", vector)
+
+	if got, _, ok := cache.lookup(context.Background(), "kit", "Who is Kit exactly"); !ok || got != "I'm Kit, a bot." {
+		t.Fatalf("similar prompt should hit, got %q %v", got, ok)
+	}
+	if _, _, ok := cache.lookup(context.Background(), "kit", "Is camp full?"); ok {
+		t.Fatal("dissimilar prompt should miss")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, _, ok := cache.lookup(context.Background(), "kit", "what is kit"); ok {
+		t.Fatal("expired entry should miss")
+	}
+
+	cache.store("kit", "slack:u1", "What is Kit?", "a", nil)
+	cache.store("kit", "slack:u2", "Is camp full?", "b", nil)
+	if removed := cache.Purge("camp"); removed != 1 || cache.Stats().Entries != 1 {
+		t.Fatalf("purge removed %d, %d entries left", removed, cache.Stats().Entries)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -73,25 +74,47 @@ func (c *CampClient) BaseURL() string {
 
 // RegistrationCount returns the current number of registrations.
 func (c *CampClient) RegistrationCount() (int, error) {
-	regs, err := c.fetchRegistrations()
+	regs, err := c.fetchRegistrations(context.Background())
 	if err != nil {
 		return 0, err
 	}
 	return len(regs), nil
 }
 
+// CamperNames returns the full name of each registered camper, used only to
+// recognize and mask the names in messages sent to AI providers.
+func (c *CampClient) CamperNames() ([]string, error) {
+	regs, err := c.fetchRegistrations(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(regs))
+	for _, reg := range regs {
+		first, last := campField(reg, "child_first_name"), campField(reg, "child_last_name")
+		if first != "" && last != "" {
+			names = append(names, first+" "+last)
+		}
+	}
+	return names, nil
+}
+
 type campExport struct {
 	Success       *bool                    `json:"success"`
 	Count         int                      `json:"count"`
 	Registrations []map[string]interface{} `json:"registrations"`
 }
 
-func (c *CampClient) login() error {
+func (c *CampClient) login(ctx context.Context) error {
 	if c.username == "" || c.password == "" {
 		return fmt.Errorf("camp admin credentials not configured")
 	}
 	form := url.Values{"username": {c.username}, "password": {c.password}}
-	resp, err := c.httpClient.PostForm(c.baseURL+"/admin/login", form)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/admin/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -100,10 +123,15 @@ func (c *CampClient) login() error {
 	return nil
 }
 
-// fetchRegistrations retrieves current registrations, logging in first if needed.
-func (c *CampClient) fetchRegistrations() ([]map[string]interface{}, error) {
+// fetchRegistrations retrieves current registrations, logging in first if
+// needed. ctx bounds both requests.
+func (c *CampClient) fetchRegistrations(ctx context.Context) ([]map[string]interface{}, error) {
 	fetch := func() (*campExport, error) {
-		resp, err := c.httpClient.Get(c.baseURL + "/admin/export-json") // #nosec G704 -- validated operator-configured URL
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/admin/export-json", nil) // #nosec G704 -- validated operator-configured URL
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			return nil, err
 		}
@@ -122,7 +150,7 @@ func (c *CampClient) fetchRegistrations() ([]map[string]interface{}, error) {
 	parsed, err := fetch()
 	if err != nil {
 		// Retry once after logging in (covers session-based auth)
-		if loginErr := c.login(); loginErr != nil {
+		if loginErr := c.login(ctx); loginErr != nil {
 			return nil, err
 		}
 		parsed, err = fetch()
@@ -188,7 +216,7 @@ func (c *CampClient) HandleQuery(message, userID string, hasAllowedRole bool) st
 		return campHelpMessage()
 	}
 
-	regs, err := c.fetchRegistrations()
+	regs, err := c.fetchRegistrations(context.Background())
 	if err != nil {
 		log.Printf("❌ Camp data fetch failed: %v", err)
 		return "⚠️ I couldn't reach the Camp Power-Up registration system right now. Please try again later."
@@ -254,23 +282,32 @@ func formatCampRoster(regs []map[string]interface{}) string {
 	return b.String()
 }
 
-func formatCampStats(regs []map[string]interface{}) string {
-	total := len(regs)
-	returning, allergies, paid, ownSwitch := 0, 0, 0, 0
+// campStats holds aggregate registration counts - no per-camper details.
+type campStats struct {
+	total, returning, allergies, paid, ownSwitch int
+}
+
+func summarizeCampRegistrations(regs []map[string]interface{}) campStats {
+	stats := campStats{total: len(regs)}
 	for _, reg := range regs {
 		if campBool(reg, "is_returning_camper") {
-			returning++
+			stats.returning++
 		}
 		if campBool(reg, "has_allergies") {
-			allergies++
+			stats.allergies++
 		}
 		if strings.EqualFold(campField(reg, "payment_status"), "paid") {
-			paid++
+			stats.paid++
 		}
 		if campBool(reg, "bringing_own_switch") {
-			ownSwitch++
+			stats.ownSwitch++
 		}
 	}
+	return stats
+}
+
+func formatCampStats(regs []map[string]interface{}) string {
+	stats := summarizeCampRegistrations(regs)
 
 	return fmt.Sprintf("🏕️ **Camp Power-Up Registration Stats**\n"+
 		"• Total registered: %d\n"+
@@ -281,7 +318,7 @@ func formatCampStats(regs []map[string]interface{}) string {
 		"• Bringing own Switch: %d\n\n"+
 		"Ask \"who registered for camp?\" for the roster.\n"+
 		"_Data served directly from Camp Power-Up - not shared with AI providers._",
-		total, returning, total-returning, paid, allergies, ownSwitch)
+		stats.total, stats.returning, stats.total-stats.returning, stats.paid, stats.allergies, stats.ownSwitch)
 }
 
 func formatCampUnpaid(regs []map[string]interface{}) string {
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cassette modes: record sends requests to the real API and saves each
+// exchange; replay answers from the saved exchanges and never touches the
+// network.
+const (
+	cassetteRecord = "record"
+	cassetteReplay = "replay"
+)
+
+// cassetteHeaders are the response headers worth keeping. Request headers
+// are never saved, so API keys stay out of cassette files.
+var cassetteHeaders = []string{"Content-Type", "Retry-After"}
+
+// cassetteInteraction is one saved request and its response.
+type cassetteInteraction struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Request  json.RawMessage   `json:"request,omitempty"`
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Response string            `json:"response"`
+
+	key string
+}
+
+// Cassette is an http.RoundTripper that records API exchanges to a file or
+// replays them from it, so the OpenAI-compatible and GitHub Models clients
+// can run in tests and local development without network access.
+//
+// Requests are matched on method, URL and body, ignoring system messages
+// (they carry the date). Identical requests replay their responses in
+// recorded order, repeating the last one once they run out.
+type Cassette struct {
+	mu           sync.Mutex
+	path         string
+	mode         string
+	transport    http.RoundTripper
+	interactions []*cassetteInteraction
+	played       map[string]int // key → responses replayed
+}
+
+// NewCassette opens the cassette at path in the given mode. Replaying needs
+// an existing file; recording appends to it if there is one.
+func NewCassette(path, mode string) (*Cassette, error) {
+	if mode != cassetteRecord && mode != cassetteReplay {
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+	c := &Cassette{path: path, mode: mode, transport: http.DefaultTransport, played: make(map[string]int)}
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	switch {
+	case os.IsNotExist(err) && mode == cassetteRecord:
+		return c, nil
+	case err != nil:
+		return nil, err
+	}
+	var file struct {
+		Interactions []*cassetteInteraction `json:"interactions"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, interaction := range file.Interactions {
+		interaction.key = cassetteKey(interaction.Method, interaction.URL, interaction.Request)
+	}
+	c.interactions = file.Interactions
+	return c, nil
+}
+
+// cassetteKey identifies a request. JSON bodies are compared without their
+// system messages and with keys in a fixed order.
+func cassetteKey(method, url string, body []byte) string {
+	var payload map[string]any
+	if json.Unmarshal(body, &payload) == nil {
+		if messages, ok := payload["messages"].([]any); ok {
+			kept := messages[:0]
+			for _, message := range messages {
+				if m, ok := message.(map[string]any); !ok || m["role"] != "system" {
+					kept = append(kept, message)
+				}
+			}
+			payload["messages"] = kept
+		}
+		if canonical, err := json.Marshal(payload); err == nil {
+			body = canonical
+		}
+	}
+	return method + " " + url + "\n" + string(body)
+}
+
+// Client returns base with its transport replaced by the cassette.
+func (c *Cassette) Client(base *http.Client) *http.Client {
+	client := *base
+	client.Transport = c
+	return &client
+}
+
+// RoundTrip replays or records one exchange.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	key := cassetteKey(req.Method, req.URL.String(), body)
+	if c.mode == cassetteReplay {
+		return c.replay(req, key)
+	}
+	return c.record(req, key, body)
+}
+
+func (c *Cassette) replay(req *http.Request, key string) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matches []*cassetteInteraction
+	for _, interaction := range c.interactions {
+		if interaction.key == key {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("cassette %s has no recording of %s %s", c.path, req.Method, req.URL)
+	}
+	n := min(c.played[key], len(matches)-1)
+	c.played[key]++
+	return matches[n].response(req), nil
+}
+
+func (c *Cassette) record(req *http.Request, key string, body []byte) (*http.Response, error) {
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &cassetteInteraction{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Status:   resp.StatusCode,
+		Response: string(respBody),
+		key:      key,
+	}
+	if json.Valid(body) {
+		interaction.Request = body
+	} else if len(body) > 0 {
+		interaction.Request, _ = json.Marshal(string(body))
+	}
+	for _, name := range cassetteHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			if interaction.Headers == nil {
+				interaction.Headers = make(map[string]string)
+			}
+			interaction.Headers[name] = value
+		}
+	}
+
+	c.mu.Lock()
+	c.interactions = append(c.interactions, interaction)
+	err = c.save()
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("⚠️  Failed to save cassette %s: %v", c.path, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// response rebuilds the saved response for req.
+func (i *cassetteInteraction) response(req *http.Request) *http.Response {
+	header := make(http.Header)
+	for name, value := range i.Headers {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(i.Response)),
+		ContentLength: int64(len(i.Response)),
+		Request:       req,
+	}
+}
+
+// save writes the cassette atomically. The caller holds c.mu.
+func (c *Cassette) save() error {
+	data, err := json.MarshalIndent(map[string]any{"interactions": c.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(c.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// cassetteFromEnv opens AI_CASSETTE in AI_CASSETTE_MODE (replay by default)
+// and returns nil when no cassette is configured or it can't be opened.
+func cassetteFromEnv() *Cassette {
+	path := os.Getenv("AI_CASSETTE")
+	if path == "" {
+		return nil
+	}
+	mode := strings.ToLower(os.Getenv("AI_CASSETTE_MODE"))
+	if mode == "" {
+		mode = cassetteReplay
+	}
+	cassette, err := NewCassette(path, mode)
+	if err != nil {
+		log.Printf("❌ Failed to open cassette %s: %v", path, err)
+		return nil
+	}
+	log.Printf("📼 AI cassette %s (%s, %d exchanges)", path, mode, len(cassette.interactions))
+	return cassette
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCassetteRecordsAndReplays(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"Bring a tent."}}]}`))
+	}))
+	path := filepath.Join(t.TempDir(), "cassettes", "groq.json")
+
+	recorder, err := NewCassette(path, cassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewOpenAICompatClient(server.URL, "secret-key", "m", "groq")
+	client.httpClient = recorder.Client(client.httpClient)
+	prompt := Prompt{System: "Today is Monday.", Message: "What should I pack?"}
+	if reply, err := client.GenerateResponse(context.Background(), prompt); err != nil || reply.Text != "Bring a tent." {
+		t.Fatalf("record: %q, %v", reply.Text, err)
+	}
+	server.Close()
+
+	saved, err := os.ReadFile(path)
+	if err != nil || strings.Contains(string(saved), "secret-key") {
+		t.Fatalf("cassette missing or holds the API key: %v\n%s", err, saved)
+	}
+
+	// Replay through the service with the server gone and a different date
+	player, err := NewCassette(path, cassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewOpenAICompatClient(server.URL, "any", "m", "groq")
+	client.httpClient = player.Client(client.httpClient)
+	svc := NewAIService(nil, nil, newOpenAICompatProvider(client))
+	svc.personas.now = func() time.Time { return time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC) }
+
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "What should I pack?"}
+	if reply := svc.Respond(context.Background(), req); reply != "Bring a tent." || calls != 1 {
+		t.Fatalf("replay: %q after %d server calls", reply, calls)
+	}
+	req.Message = "Anything else?"
+	if _, err := client.GenerateResponse(context.Background(), Prompt{Message: req.Message}); err == nil || !strings.Contains(err.Error(), "no recording") {
+		t.Fatalf("expected a missing recording error, got %v", err)
+	}
+
+	if _, err := NewCassette(filepath.Join(t.TempDir(), "none.json"), cassetteReplay); err == nil {
+		t.Fatal("replaying needs an existing cassette")
+	}
+}
@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 )
 
+// claudeTimeout bounds one Claude call within the request's deadline, so a
+// hung call leaves time for the fallback providers. Streams and tool loops
+// get twice as long.
+const claudeTimeout = 30 * time.Second
+
 // ClaudeClient wraps the Anthropic client with our specific configuration
 type ClaudeClient struct {
-	client anthropic.Client
-	model  string
+	client  anthropic.Client
+	model   string
+	timeout time.Duration
 }
 
 // NewClaudeClient creates a new Claude client
@@ -24,54 +32,166 @@ func NewClaudeClient(apiKey, model string) *ClaudeClient {
 	client := anthropic.NewClient(option.WithAPIKey(apiKey))
 
 	return &ClaudeClient{
-		client: client,
-		model:  model,
+		client:  client,
+		model:   model,
+		timeout: claudeTimeout,
 	}
 }
 
-// GenerateResponse generates a response using Claude AI
-func (c *ClaudeClient) GenerateResponse(message string) (string, error) {
+// GenerateResponse generates a response using Claude AI.
+func (c *ClaudeClient) GenerateResponse(ctx context.Context, prompt Prompt) (Reply, error) {
 	if c == nil {
-		return "", nil // Return empty to use fallback
+		return Reply{}, nil // Return empty to use fallback
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	// System prompt for Kit's personality
-	systemPrompt := `You are Kit, a helpful and friendly AI assistant integrated into Slack. Keep responses under 300 words and be professional but approachable.`
-
 	// Create the message request
-	resp, err := c.client.Messages.New(ctx, anthropic.MessageNewParams{
-		Model:     anthropic.Model(c.model),
-		MaxTokens: 1000,
-		System: []anthropic.TextBlockParam{
-			{
-				Type: "text",
-				Text: systemPrompt,
-			},
-		},
-		Messages: []anthropic.MessageParam{
-			{
-				Role: "user",
-				Content: []anthropic.ContentBlockParamUnion{
-					anthropic.NewTextBlock(message),
-				},
-			},
-		},
-	})
-
+	resp, err := c.client.Messages.New(ctx, c.params(prompt))
 	if err != nil {
 		log.Printf("❌ Claude API error: %v", err)
-		return "", err
+		return Reply{}, err
 	}
 
 	// Extract text from response
-	if len(resp.Content) > 0 {
-		if resp.Content[0].Type == "text" {
-			return resp.Content[0].Text, nil
+	reply := Reply{Usage: claudeUsage(resp)}
+	if len(resp.Content) > 0 && resp.Content[0].Type == "text" {
+		reply.Text = resp.Content[0].Text
+	}
+	return reply, nil
+}
+
+// GenerateStream is like GenerateResponse but streams the reply, calling
+// onDelta with each fragment of text as it arrives.
+func (c *ClaudeClient) GenerateStream(ctx context.Context, prompt Prompt, onDelta func(string)) (Reply, error) {
+	if c == nil {
+		return Reply{}, nil // Return empty to use fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*c.timeout)
+	defer cancel()
+
+	stream := c.client.Messages.NewStreaming(ctx, c.params(prompt))
+	defer stream.Close()
+
+	// The accumulated message collects the usage reported by the
+	// message_start and message_delta events.
+	var text strings.Builder
+	var accumulated anthropic.Message
+	for stream.Next() {
+		event := stream.Current()
+		if err := accumulated.Accumulate(event); err != nil {
+			log.Printf("⚠️  Claude stream event not accumulated: %v", err)
+		}
+		if event.Type != "content_block_delta" || event.Delta.Type != "text_delta" {
+			continue
+		}
+		text.WriteString(event.Delta.Text)
+		if onDelta != nil {
+			onDelta(event.Delta.Text)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		log.Printf("❌ Claude API stream error: %v", err)
+		return Reply{}, err
+	}
+
+	usage := claudeUsage(&accumulated)
+	if usage.Model == "" {
+		usage.Model = c.model
+	}
+	return Reply{Text: text.String(), Usage: usage}, nil
+}
+
+// GenerateWithTools runs the model → tool → model loop: tool_use blocks are
+// run through tools.Call and answered with tool_result blocks, until Claude
+// answers in text or tools.MaxSteps rounds have been used.
+func (c *ClaudeClient) GenerateWithTools(ctx context.Context, prompt Prompt, tools *ToolSet) (Reply, error) {
+	if c == nil {
+		return Reply{}, nil // Return empty to use fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*c.timeout)
+	defer cancel()
+
+	params := c.params(prompt)
+	for _, tool := range tools.Tools {
+		properties, required := tool.schemaProperties()
+		definition := anthropic.ToolUnionParamOfTool(anthropic.ToolInputSchemaParam{
+			Properties: properties,
+			Required:   required,
+		}, tool.Name)
+		definition.OfTool.Description = anthropic.String(tool.Description)
+		params.Tools = append(params.Tools, definition)
+	}
+
+	usage := Usage{Model: c.model}
+	for step := 0; ; step++ {
+		lastStep := step >= tools.MaxSteps
+		if lastStep {
+			// Out of steps: make the model answer
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfNone: &anthropic.ToolChoiceNoneParam{}}
+		}
+		resp, err := c.client.Messages.New(ctx, params)
+		if err != nil {
+			log.Printf("❌ Claude API error: %v", err)
+			return Reply{Usage: usage}, err
+		}
+		usage.add(claudeUsage(resp))
+
+		var text strings.Builder
+		var results []anthropic.ContentBlockParamUnion
+		for _, block := range resp.Content {
+			switch block.Type {
+			case "text":
+				text.WriteString(block.Text)
+			case "tool_use":
+				result := tools.Call(ctx, block.Name, block.Input)
+				results = append(results, anthropic.NewToolResultBlock(block.ID, result, false))
+			}
+		}
+		if len(results) == 0 || lastStep {
+			return Reply{Text: text.String(), Usage: usage}, nil
 		}
+		params.Messages = append(params.Messages, resp.ToParam(), anthropic.NewUserMessage(results...))
 	}
+}
 
-	return "", nil
+// claudeUsage converts a response's usage. Prompt tokens include those
+// written to and read from the prompt cache.
+func claudeUsage(resp *anthropic.Message) Usage {
+	u := resp.Usage
+	return Usage{
+		Model:            string(resp.Model),
+		PromptTokens:     int(u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens),
+		CompletionTokens: int(u.OutputTokens),
+	}
+}
+
+func (c *ClaudeClient) params(prompt Prompt) anthropic.MessageNewParams {
+	// Replay the conversation as alternating user/assistant messages
+	messages := make([]anthropic.MessageParam, 0, len(prompt.History)+1)
+	for _, turn := range prompt.History {
+		if turn.Role == "assistant" {
+			messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(turn.Content)))
+		} else {
+			messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(turn.Content)))
+		}
+	}
+	blocks := []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock(prompt.Message)}
+	for _, image := range prompt.Images {
+		blocks = append(blocks, anthropic.NewImageBlockBase64(image.MIMEType, base64.StdEncoding.EncodeToString(image.Data)))
+	}
+	messages = append(messages, anthropic.NewUserMessage(blocks...))
+
+	params := anthropic.MessageNewParams{
+		Model:     anthropic.Model(c.model),
+		MaxTokens: 1000,
+		Messages:  messages,
+	}
+	if prompt.System != "" {
+		params.System = []anthropic.TextBlockParam{{Type: "text", Text: prompt.System}}
+	}
+	return params
 }
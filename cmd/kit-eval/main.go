@@ -0,0 +1,70 @@
+// Command kit-eval scores Kit's answers to a suite of prompts and writes a
+// report in Markdown and JSON.
+//
+// Each case in the suite (see config/eval.example.yaml) is sent through the
+// same AIService and providers as the bot, with its personas, knowledge base
+// and redaction, and the answer is checked for required and forbidden text,
+// length, a regular expression and an LLM-judged rubric.
+//
+// Usage:
+//
+//	go run ./cmd/kit-eval -suite config/eval.example.yaml [-providers groq,gemini] [-judge groq] [-md report.md] [-json report.json]
+//
+// Providers come from the same environment (or .env) as the bot. To run
+// offline, use scripted providers (MOCK_AI_RESPONSES) or replay recorded
+// exchanges (AI_CASSETTE). The exit status is 0 when every case passed, 1
+// when some failed and 2 when the suite couldn't run.
+//
+// The assistant lives in the bot's main package, which can't be imported, so
+// kit-eval runs the bot in its eval mode ("go run . eval"). It needs the Go
+// toolchain and must be started inside the repository.
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	gomod, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		log.Fatalf("❌ Failed to find the Kit module: %v", err)
+	}
+	path := strings.TrimSpace(string(gomod))
+	if path == "" || path == os.DevNull {
+		log.Fatal("❌ kit-eval must be run from inside the Kit repository")
+	}
+
+	args := append([]string{"run", filepath.Dir(path), "eval"}, os.Args[1:]...)
+	cmd := exec.Command("go", args...) // #nosec G204 -- forwards our own arguments
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// Ctrl-C reaches the evaluation from the terminal; kit-eval waits for it
+	// so its exit status is returned, and passes on a SIGTERM sent to
+	// kit-eval alone. signal.Ignore would be inherited by the child and
+	// make the evaluation impossible to interrupt.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("❌ Failed to run the evaluation: %v", err)
+	}
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		log.Fatalf("❌ Failed to run the evaluation: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Comparison is one provider's answer to a !compare question.
+type Comparison struct {
+	Provider string
+	Model    string
+	Text     string
+	Latency  time.Duration
+	Tokens   int
+	Err      error
+}
+
+// Compare sends the question to every provider its circuit breaker lets
+// through, all at once, and returns their answers in routing order. Each provider sees
+// the question on its own, without the user's history, and nothing is stored
+// in the session or cache. Usage and health are recorded as for any request.
+func (a *AIService) Compare(ctx context.Context, req ChatRequest) []Comparison {
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
+	defer cancel()
+	redactions := a.redactor.begin(req)
+	message = redactions.redact(message)
+	_, systemPrompt := a.personas.systemPrompt(req)
+	systemPrompt += a.knowledge.promptContext(ctx, message)
+
+	var providers []Provider
+	for _, provider := range a.providers {
+		if a.health.allow(provider.Name()) {
+			providers = append(providers, provider)
+		}
+	}
+	results := make([]Comparison, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			view := &Session{Platform: req.Platform, UserID: req.UserID, ChannelID: req.ChannelID, SystemPrompt: systemPrompt}
+			reply, err := a.generate(ctx, provider, message, view, nil, nil)
+			a.health.record(provider.Name(), err)
+			if err == nil {
+				a.usage.record(req, reply.Usage)
+			} else {
+				log.Printf("⚠️  %s provider failed to compare: %v", provider.Name(), err)
+			}
+			results[i] = Comparison{
+				Provider: provider.Name(),
+				Model:    reply.Usage.Model,
+				Text:     redactions.restore(strings.TrimSpace(reply.Text)),
+				Latency:  reply.Usage.Latency,
+				Tokens:   reply.Usage.PromptTokens + reply.Usage.CompletionTokens,
+				Err:      err,
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// maxComparisonChars bounds each answer in the side-by-side view so one
+// verbose model doesn't bury the rest.
+const maxComparisonChars = 1200
+
+// formatComparisons renders the answers one after the other, each headed by
+// the provider, model, latency and tokens used.
+func formatComparisons(question string, results []Comparison) string {
+	if len(results) == 0 {
+		return "⚖️ No providers are available to compare right now."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "⚖️ **Compare:** %s\n", question)
+	for _, result := range results {
+		b.WriteString("\n**" + result.Provider + "**")
+		if result.Model != "" {
+			b.WriteString(" (" + result.Model + ")")
+		}
+		fmt.Fprintf(&b, " · ⏱️ %s", result.Latency.Round(10*time.Millisecond))
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(&b, "\n❌ %s\n", classifyError(result.Err))
+			continue
+		case result.Text == "":
+			b.WriteString("\n❌ empty reply\n")
+			continue
+		}
+		fmt.Fprintf(&b, " · %s tokens\n", formatTokenCount(result.Tokens))
+		text := result.Text
+		if runes := []rune(text); len(runes) > maxComparisonChars {
+			text = string(runes[:maxComparisonChars]) + "…"
+		}
+		for _, line := range strings.Split(text, "\n") {
+			b.WriteString("> " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// handleCompareCommand implements !compare and /kit compare for admins:
+// every call goes to all providers, so it costs several times a question.
+func handleCompareCommand(ctx context.Context, req ChatRequest) string {
+	if !isAdmin(req.UserID) {
+		return "🔒 Only Kit admins can compare providers."
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		return "❓ **Usage:** `compare <question>`"
+	}
+	if globalAIService == nil {
+		return "⚖️ No AI providers are configured."
+	}
+	return formatComparisons(req.Message, globalAIService.Compare(ctx, req))
+}
+
+// ChannelProviders lists the providers routed to the user in the channel,
+// in order: the only ones they may pin.
+func (a *AIService) ChannelProviders(platform, userID, channelID string) []string {
+	_, chain := a.route(ChatRequest{Platform: platform, UserID: userID, ChannelID: channelID})
+	names := make([]string, 0, len(chain))
+	for _, provider := range chain {
+		names = append(names, provider.Name())
+	}
+	return names
+}
+
+// PinProvider makes name answer the session first, or unpins it when name
+// is "". Only providers the channel is routed to can be pinned, so a pin
+// never gets around an exclusive route.
+func (a *AIService) PinProvider(platform, userID, channelID, name string) error {
+	if name != "" && !slices.Contains(a.ChannelProviders(platform, userID, channelID), name) {
+		return fmt.Errorf("provider %q is not available here", name)
+	}
+	a.store.SetProvider(a.store.GetOrCreate(platform, userID, channelID), name)
+	return nil
+}
+
+// PinnedProvider returns the provider pinned on the session, if any.
+func (a *AIService) PinnedProvider(platform, userID, channelID string) string {
+	session := a.store.GetOrCreate(platform, userID, channelID)
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Provider
+}
+
+// withPinned puts the session's pinned provider at the front of chain. A
+// pin to a provider that is not in chain, because it is no longer
+// registered or the request was routed elsewhere, is ignored.
+func (a *AIService) withPinned(session *Session, chain []Provider) []Provider {
+	session.mu.Lock()
+	name := session.Provider
+	session.mu.Unlock()
+	if name == "" {
+		return chain
+	}
+	var ordered []Provider
+	for _, provider := range chain {
+		if provider.Name() == name {
+			ordered = append(ordered, provider)
+		}
+	}
+	if len(ordered) == 0 {
+		return chain
+	}
+	for _, provider := range chain {
+		if provider.Name() != name {
+			ordered = append(ordered, provider)
+		}
+	}
+	return ordered
+}
+
+// handleModelCommand implements !model and /kit model: list the providers,
+// pin one for the user in this channel, or go back to the default chain.
+func handleModelCommand(platform, args, userID, channelID string) string {
+	if globalAIService == nil || len(globalAIService.providers) == 0 {
+		return "🧠 No AI providers are configured."
+	}
+	svc := globalAIService
+	fields := strings.Fields(args)
+	usage := "❓ **Usage:** `model list`, `model use <name>` or `model reset`"
+	if len(fields) == 0 {
+		fields = []string{"list"}
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "list":
+		pinned := svc.PinnedProvider(platform, userID, channelID)
+		var b strings.Builder
+		b.WriteString("🧠 **AI providers** (first healthy one answers)\n")
+		for _, status := range svc.health.snapshot(svc.ChannelProviders(platform, userID, channelID)) {
+			mark := ""
+			if status.Name == pinned {
+				mark = " 📌 your pick"
+			}
+			state := "✅"
+			if status.State != "closed" {
+				state = "🔴"
+			}
+			fmt.Fprintf(&b, "• %s `%s`%s\n", state, status.Name, mark)
+		}
+		if pinned == "" {
+			fmt.Fprintf(&b, "\nPin one for yourself here with `%smodel use <name>`.", commandPrefix(platform))
+		}
+		return b.String()
+
+	case "use":
+		if len(fields) != 2 {
+			return usage
+		}
+		if err := svc.PinProvider(platform, userID, channelID, fields[1]); err != nil {
+			return fmt.Sprintf("❓ `%s` isn't available here. Available: `%s`", fields[1], strings.Join(svc.ChannelProviders(platform, userID, channelID), "`, `"))
+		}
+		return fmt.Sprintf("📌 `%s` will answer you here first. If it's unavailable, the usual providers step in.", fields[1])
+
+	case "reset", "default", "auto":
+		if err := svc.PinProvider(platform, userID, channelID, ""); err != nil {
+			return "❌ Sorry, I couldn't reset your provider."
+		}
+		return "🔄 Back to the default providers."
+
+	default:
+		return usage
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// namedProvider answers "<name>: <message>" after delay, or fails with err.
+func namedProvider(name string, delay time.Duration, err error) providerFunc {
+	return providerFunc{name: name, fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		time.Sleep(delay)
+		if err != nil {
+			return Reply{}, err
+		}
+		return Reply{Text: name + ": " + message, Usage: Usage{Model: name + "-model"}}, nil
+	}}
+}
+
+func TestCompareFansOutToHealthyProviders(t *testing.T) {
+	svc := NewAIService(nil, nil,
+		namedProvider("slow", 50*time.Millisecond, nil),
+		namedProvider("fast", 0, nil),
+		namedProvider("broken", 0, &ProviderError{Provider: "broken", StatusCode: 503}),
+		namedProvider("open", 0, nil),
+	)
+	svc.health.record("open", &ProviderError{Provider: "open", StatusCode: 401})
+	useTestService(t, svc, "admin")
+
+	start := time.Now()
+	reply := handleCompareCommand(context.Background(), ChatRequest{Platform: "discord", UserID: "admin", ChannelID: "c1", Message: "hi"})
+	if elapsed := time.Since(start); elapsed > 90*time.Millisecond {
+		t.Fatalf("providers were not called concurrently: %s", elapsed)
+	}
+	for _, want := range []string{"**slow** (slow-model) · ⏱️ 50ms", "> slow: hi", "> fast: hi", "**broken**", "❌ server error"} {
+		if !strings.Contains(reply, want) {
+			t.Fatalf("%q missing from:\n%s", want, reply)
+		}
+	}
+	if strings.Index(reply, "**slow**") > strings.Index(reply, "**fast**") || strings.Contains(reply, "**open**") {
+		t.Fatalf("answers out of routing order or open circuit called:\n%s", reply)
+	}
+	if history := svc.store.GetOrCreate("discord", "admin", "c1").History(10); len(history) != 0 {
+		t.Fatalf("compare should not touch the history: %+v", history)
+	}
+
+	if reply := handleCompareCommand(context.Background(), ChatRequest{UserID: "u1", Message: "hi"}); !strings.Contains(reply, "Only Kit admins") {
+		t.Fatalf("non-admin compared: %q", reply)
+	}
+}
+
+func TestModelCommandPinsProvider(t *testing.T) {
+	failing := namedProvider("claude", 0, errors.New("timeout"))
+	svc := NewAIService(nil, nil, namedProvider("groq", 0, nil), namedProvider("gemini", 0, nil), failing)
+	svc.SetResponseCache(NewResponseCache(time.Hour, 10))
+	useTestService(t, svc)
+	req := ChatRequest{Platform: "slack", UserID: "u1", ChannelID: "c1", Message: "hello"}
+
+	if reply := handleModelCommand("slack", "use gpt-9", "u1", "c1"); !strings.Contains(reply, "Available: `groq`, `gemini`, `claude`") {
+		t.Fatalf("unknown provider accepted: %q", reply)
+	}
+	handleModelCommand("slack", "use gemini", "u1", "c1")
+	if reply := svc.Respond(context.Background(), req); reply != "gemini: hello" {
+		t.Fatalf("pinned provider did not answer: %q", reply)
+	}
+	if list := handleModelCommand("slack", "list", "u1", "c1"); !strings.Contains(list, "`gemini` 📌") {
+		t.Fatalf("pin not shown:\n%s", list)
+	}
+	if reply := svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u2", ChannelID: "c1", Message: "hello"}); reply != "groq: hello" {
+		t.Fatalf("pin leaked to another user: %q", reply)
+	}
+
+	// An unavailable pick falls back to the normal chain
+	handleModelCommand("slack", "use claude", "u1", "c1")
+	if reply := svc.Respond(context.Background(), req); reply != "groq: hello" {
+		t.Fatalf("expected the default chain after the pinned provider failed, got %q", reply)
+	}
+	handleModelCommand("slack", "reset", "u1", "c1")
+	if pinned := svc.PinnedProvider("slack", "u1", "c1"); pinned != "" {
+		t.Fatalf("pin not reset: %q", pinned)
+	}
+}
+
+func TestModelPinStaysInsideExclusiveRoute(t *testing.T) {
+	svc := NewAIService(nil, nil, namedProvider("groq", 0, nil), namedProvider("local", 0, nil))
+	svc.SetRoutingPolicy(&RoutingPolicy{Routes: []Route{{
+		Name:      "private",
+		Match:     RouteMatch{Channels: []string{"private"}},
+		Providers: []string{"local"},
+		Exclusive: true,
+	}}})
+	useTestService(t, svc)
+
+	if reply := handleModelCommand("slack", "use groq", "u1", "private"); !strings.Contains(reply, "isn't available here. Available: `local`") {
+		t.Fatalf("pin outside the route accepted: %q", reply)
+	}
+	if list := handleModelCommand("slack", "list", "u1", "private"); strings.Contains(list, "groq") || !strings.Contains(list, "`local`") {
+		t.Fatalf("list shows providers outside the route:\n%s", list)
+	}
+	// A pin made before the route existed is ignored
+	svc.store.SetProvider(svc.store.GetOrCreate("slack", "u1", "private"), "groq")
+	if reply := svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", ChannelID: "private", Message: "hi"}); reply != "local: hi" {
+		t.Fatalf("pin bypassed the exclusive route: %q", reply)
+	}
+}
+
+func TestPinnedProviderPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	fileStore, err := NewFileSessionStore(path, SessionRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore.SetProvider(fileStore.GetOrCreate("discord", "u1", "c1"), "groq")
+	fileStore.Close()
+	reopened, err := NewFileSessionStore(path, SessionRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	redisStore, _ := newTestRedisStore(t, 10, time.Hour)
+	redisStore.SetProvider(redisStore.GetOrCreate("discord", "u1", "c1"), "groq")
+
+	for _, store := range []SessionStore{reopened, redisStore} {
+		if provider := store.GetOrCreate("discord", "u1", "c1").Provider; provider != "groq" {
+			t.Fatalf("%T lost the pinned provider: %q", store, provider)
+		}
+	}
+}
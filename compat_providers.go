@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCompatTimeout bounds a request to an OpenAI-compatible endpoint
+// when no timeout is configured.
+const defaultCompatTimeout = 60 * time.Second
+
+// CompatProviderConfig declares one named OpenAI-compatible endpoint.
+// Zero values use the client defaults.
+type CompatProviderConfig struct {
+	Name        string            `json:"name"`
+	BaseURL     string            `json:"base_url"`
+	APIKey      string            `json:"api_key"`
+	Model       string            `json:"model"`
+	Timeout     string            `json:"timeout"` // Go duration, e.g. "30s"
+	MaxTokens   int               `json:"max_tokens"`
+	Temperature *float64          `json:"temperature"`
+	Headers     map[string]string `json:"headers"`
+	// Vision marks models that accept images, e.g. gpt-4o or llava.
+	Vision bool `json:"vision"`
+	// StreamUsage asks streamed replies for their token usage
+	// (stream_options), which some endpoints reject. Without it the usage
+	// of streamed replies is estimated.
+	StreamUsage bool `json:"stream_usage"`
+}
+
+// LoadCompatProviders reads a provider list file; see
+// config/providers.example.json. An api_key of the form "$NAME" is read
+// from the environment so keys can stay out of the file.
+func LoadCompatProviders(path string) ([]CompatProviderConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Providers []CompatProviderConfig `json:"providers"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range file.Providers {
+		cfg := &file.Providers[i]
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("openai-compat-%d", i+1)
+		}
+		if envName, ok := strings.CutPrefix(cfg.APIKey, "$"); ok {
+			cfg.APIKey = os.Getenv(envName)
+		}
+		if cfg.BaseURL == "" || cfg.Model == "" {
+			return nil, fmt.Errorf("provider %q needs base_url and model", cfg.Name)
+		}
+		if _, err := cfg.timeout(); err != nil {
+			return nil, fmt.Errorf("provider %q: %w", cfg.Name, err)
+		}
+	}
+	return file.Providers, nil
+}
+
+// compatProvidersFromEnv reads the unnumbered OPENAI_COMPAT_* endpoint
+// followed by OPENAI_COMPAT_1_*, OPENAI_COMPAT_2_* and so on, up to the
+// first number without a BASE_URL.
+func compatProvidersFromEnv() []CompatProviderConfig {
+	var configs []CompatProviderConfig
+	if cfg, ok := compatProviderFromEnv("OPENAI_COMPAT_"); ok {
+		configs = append(configs, cfg)
+	}
+	for n := 1; ; n++ {
+		cfg, ok := compatProviderFromEnv(fmt.Sprintf("OPENAI_COMPAT_%d_", n))
+		if !ok {
+			return configs
+		}
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("openai-compat-%d", n)
+		}
+		configs = append(configs, cfg)
+	}
+}
+
+func compatProviderFromEnv(prefix string) (CompatProviderConfig, bool) {
+	cfg := CompatProviderConfig{
+		Name:    os.Getenv(prefix + "NAME"),
+		BaseURL: os.Getenv(prefix + "BASE_URL"),
+		APIKey:  os.Getenv(prefix + "API_KEY"),
+		Model:   os.Getenv(prefix + "MODEL"),
+		Timeout: os.Getenv(prefix + "TIMEOUT"),
+	}
+	if cfg.BaseURL == "" {
+		return cfg, false
+	}
+	cfg.MaxTokens, _ = strconv.Atoi(os.Getenv(prefix + "MAX_TOKENS"))
+	cfg.Vision = strings.EqualFold(os.Getenv(prefix+"VISION"), "true")
+	if t, err := strconv.ParseFloat(os.Getenv(prefix+"TEMPERATURE"), 64); err == nil {
+		cfg.Temperature = &t
+	}
+	// HEADERS is a comma-separated list of Name=Value pairs
+	for _, pair := range splitList(os.Getenv(prefix + "HEADERS")) {
+		if name, value, ok := strings.Cut(pair, "="); ok {
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			cfg.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return cfg, true
+}
+
+func (c CompatProviderConfig) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return defaultCompatTimeout, nil
+	}
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad timeout %q", c.Timeout)
+	}
+	return d, nil
+}
+
+// newCompatClients creates a client for each endpoint, in priority order:
+// the OPENAI_COMPAT_CONFIG file, if set, then the environment. Endpoints
+// that are misconfigured or reuse a name are skipped with a warning.
+func newCompatClients() []*OpenAICompatClient {
+	var configs []CompatProviderConfig
+	if path := os.Getenv("OPENAI_COMPAT_CONFIG"); path != "" {
+		fromFile, err := LoadCompatProviders(path)
+		if err != nil {
+			log.Printf("❌ Failed to load OpenAI-compatible providers: %v", err)
+		}
+		configs = fromFile
+	}
+	configs = append(configs, compatProvidersFromEnv()...)
+
+	var clients []*OpenAICompatClient
+	seen := make(map[string]bool)
+	for _, cfg := range configs {
+		client := NewOpenAICompatClientFromConfig(cfg)
+		if client == nil {
+			log.Printf("⚠️  Skipping OpenAI-compatible provider %q: invalid base URL or missing model", cfg.Name)
+			continue
+		}
+		if seen[client.name] {
+			log.Printf("⚠️  Skipping OpenAI-compatible provider %q: name already used", client.name)
+			continue
+		}
+		seen[client.name] = true
+		clients = append(clients, client)
+		log.Printf("🧠 OpenAI-compatible provider %s initialized (model %s)", client.name, client.model)
+	}
+	return clients
+}
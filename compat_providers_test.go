@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompatProvidersFromEnv(t *testing.T) {
+	t.Setenv("OPENAI_COMPAT_BASE_URL", "https://api.groq.com/openai/v1")
+	t.Setenv("OPENAI_COMPAT_MODEL", "llama")
+	t.Setenv("OPENAI_COMPAT_NAME", "groq")
+	t.Setenv("OPENAI_COMPAT_1_BASE_URL", "https://openrouter.ai/api/v1")
+	t.Setenv("OPENAI_COMPAT_1_MODEL", "free")
+	t.Setenv("OPENAI_COMPAT_1_HEADERS", "X-Title=Kit, HTTP-Referer=https://example.org")
+	t.Setenv("OPENAI_COMPAT_1_TEMPERATURE", "0")
+	t.Setenv("OPENAI_COMPAT_2_NAME", "ollama")
+	t.Setenv("OPENAI_COMPAT_2_BASE_URL", "http://localhost:11434/v1")
+	t.Setenv("OPENAI_COMPAT_2_MODEL", "llama3.2")
+	t.Setenv("OPENAI_COMPAT_2_TIMEOUT", "2m")
+	t.Setenv("OPENAI_COMPAT_4_BASE_URL", "http://ignored.example") // after a gap
+
+	configs := compatProvidersFromEnv()
+	if len(configs) != 3 {
+		t.Fatalf("expected 3 endpoints, got %+v", configs)
+	}
+	names := []string{configs[0].Name, configs[1].Name, configs[2].Name}
+	if names[0] != "groq" || names[1] != "openai-compat-1" || names[2] != "ollama" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if configs[1].Headers["X-Title"] != "Kit" || configs[1].Headers["HTTP-Referer"] != "https://example.org" {
+		t.Fatalf("headers not parsed: %v", configs[1].Headers)
+	}
+	if configs[1].Temperature == nil || *configs[1].Temperature != 0 {
+		t.Fatalf("explicit zero temperature lost")
+	}
+	if client := NewOpenAICompatClientFromConfig(configs[2]); client == nil || client.timeout != 2*time.Minute {
+		t.Fatalf("timeout not applied: %+v", client)
+	}
+}
+
+func TestLoadCompatProviders(t *testing.T) {
+	t.Setenv("TEST_GROQ_KEY", "secret")
+	path := filepath.Join(t.TempDir(), "providers.json")
+	config := `{"providers": [
+		{"name": "groq", "base_url": "https://api.groq.com/openai/v1", "api_key": "$TEST_GROQ_KEY", "model": "llama"},
+		{"base_url": "http://localhost:11434/v1", "model": "llama3.2", "timeout": "90s"}
+	]}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := LoadCompatProviders(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || configs[0].APIKey != "secret" || configs[1].Name != "openai-compat-2" {
+		t.Fatalf("unexpected configs %+v", configs)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"providers": [{"name": "bad", "base_url": "https://x.example", "model": "m", "timeout": "soon"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCompatProviders(path); err == nil {
+		t.Fatal("expected an error for a bad timeout")
+	}
+}
+
+func TestCompatClientSendsConfiguredSettings(t *testing.T) {
+	var got oaChatRequest
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi"}}]}`))
+	}))
+	defer server.Close()
+
+	zero := 0.0
+	client := NewOpenAICompatClientFromConfig(CompatProviderConfig{
+		Name:        "local",
+		BaseURL:     server.URL,
+		Model:       "m",
+		MaxTokens:   64,
+		Temperature: &zero,
+		Headers:     map[string]string{"X-Title": "Kit"},
+	})
+	reply, err := client.GenerateResponse(context.Background(), Prompt{Message: "hello"})
+	if err != nil || reply.Text != "hi" {
+		t.Fatalf("reply %q, err %v", reply.Text, err)
+	}
+	if got.MaxTokens != 64 || got.Temperature == nil || *got.Temperature != 0 {
+		t.Fatalf("settings not sent: %+v", got)
+	}
+	if header.Get("X-Title") != "Kit" {
+		t.Fatalf("extra header not sent: %v", header)
+	}
+}
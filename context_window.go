@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// defaultContextTokens is the prompt budget (history plus the new
+	// message) when AI_CONTEXT_TOKENS is not set.
+	defaultContextTokens = 6000
+	// messageTokenOverhead approximates the role and formatting tokens each
+	// chat message costs on top of its text.
+	messageTokenOverhead = 4
+	// summaryTimeout bounds a background summary refresh.
+	summaryTimeout = 60 * time.Second
+)
+
+// summaryPreamble introduces the rolling summary, which is replayed to the
+// provider as the first user turn so every backend accepts it.
+const summaryPreamble = "Summary of our earlier conversation:\n"
+
+// summaryAck is the assistant turn paired with the summary, keeping the
+// history alternating for providers that require it.
+const summaryAck = "Thanks, I'll keep that in mind."
+
+// estimateTokens approximates how many tokens a chat message costs with the
+// named provider. Claude's tokenizer averages fewer characters per token for
+// English than the Gemini and OpenAI-style tokenizers; exact counts are not
+// needed to stay within a budget that leaves headroom.
+func estimateTokens(provider, text string) int {
+	charsPerToken := 4.0
+	if provider == "claude" {
+		charsPerToken = 3.5
+	}
+	return int(math.Ceil(float64(utf8.RuneCountInString(text))/charsPerToken)) + messageTokenOverhead
+}
+
+// SetContextBudget sets how many tokens of history and message a prompt may
+// use. The reply and system prompt are not counted.
+func (a *AIService) SetContextBudget(tokens int) {
+	if tokens > 0 {
+		a.contextTokens = tokens
+	}
+}
+
+// fitContext returns the view of session to send to provider along with the
+// message: the rolling summary, if any, followed by the newest unsummarized
+// turns that fit the budget. overflow holds the unsummarized messages that
+// were left out, oldest first, and should be folded into the summary.
+func (a *AIService) fitContext(provider string, session *Session, message string) (view *Session, overflow []ChatMessage) {
+	session.mu.Lock()
+	var pending []ChatMessage
+	for _, msg := range session.Messages {
+		if msg.Timestamp.After(session.SummarizedThrough) {
+			pending = append(pending, msg)
+		}
+	}
+	summary := session.Summary
+	view = &Session{
+		ID:        session.ID,
+		Platform:  session.Platform,
+		UserID:    session.UserID,
+		ChannelID: session.ChannelID,
+		UpdatedAt: session.UpdatedAt,
+	}
+	session.mu.Unlock()
+
+	budget := a.contextTokens - estimateTokens(provider, message)
+	limit := maxPromptHistory
+	// Older turns go to the summary now, in the background, so they are
+	// covered before the next replies push them out of the store.
+	if stored := a.maxStoredMessages(); stored > 0 {
+		limit = min(limit, max(stored-4, 2))
+	}
+	var prefix []ChatMessage
+	if summary != "" {
+		prefix = []ChatMessage{
+			{Role: "user", Content: summaryPreamble + summary},
+			{Role: "assistant", Content: summaryAck},
+		}
+		for _, msg := range prefix {
+			budget -= estimateTokens(provider, msg.Content)
+		}
+		limit = min(limit, maxPromptHistory-len(prefix))
+	}
+
+	start := len(pending)
+	used := 0
+	for i := len(pending) - 1; i >= 0 && len(pending)-i <= limit; i-- {
+		used += estimateTokens(provider, pending[i].Content)
+		if used > budget {
+			break
+		}
+		start = i
+	}
+	// Never open the window on an assistant turn
+	for start < len(pending) && pending[start].Role != "user" {
+		start++
+	}
+
+	view.Messages = append(prefix, pending[start:]...)
+	return view, pending[:start]
+}
+
+// refreshSummary folds overflow into the session's rolling summary in the
+// background, using the provider chain req was routed to so a summary never
+// reaches a provider the conversation itself may not use. At most one
+// refresh per session runs at a time; overflow that arrives meanwhile is
+// picked up by the next request.
+func (a *AIService) refreshSummary(req ChatRequest, chain []Provider, session *Session, overflow []ChatMessage) {
+	if len(overflow) == 0 {
+		return
+	}
+	session.mu.Lock()
+	if session.summarizing {
+		session.mu.Unlock()
+		return
+	}
+	session.summarizing = true
+	previous := session.Summary
+	session.mu.Unlock()
+
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+		defer cancel()
+
+		summary, err := a.summarize(ctx, req, chain, previous, overflow)
+		session.mu.Lock()
+		session.summarizing = false
+		session.mu.Unlock()
+		if err != nil {
+			log.Printf("⚠️  Conversation summary refresh failed: %v", err)
+			return
+		}
+		through := overflow[len(overflow)-1].Timestamp
+		session.mu.Lock()
+		stale := !through.After(session.SummarizedThrough)
+		session.mu.Unlock()
+		if stale {
+			return // summarizeBeforeTrim got further meanwhile
+		}
+		a.store.SetSummary(session, summary, through)
+		log.Printf("📝 Summarized %d older messages for %s user %s", len(overflow), session.Platform, session.UserID)
+	}()
+}
+
+// maxStoredMessages returns how many messages the store keeps per session,
+// or 0 when it doesn't say.
+func (a *AIService) maxStoredMessages() int {
+	if store, ok := a.store.(RetentionStore); ok {
+		return store.Retention().MaxMessages
+	}
+	return 0
+}
+
+// summarizeBeforeTrim folds into the summary the unsummarized messages the
+// store will trim once adding more messages are recorded, and waits for it
+// so they are not lost. fitContext normally has them summarized in the
+// background well before; this covers refreshes that failed or fell behind.
+// If no provider can summarize, the messages are trimmed anyway: the store
+// never holds more than its MaxMessages.
+func (a *AIService) summarizeBeforeTrim(ctx context.Context, req ChatRequest, chain []Provider, session *Session, adding int) {
+	stored := a.maxStoredMessages()
+	if stored <= 0 {
+		return
+	}
+	session.mu.Lock()
+	var trimmed []ChatMessage
+	for _, msg := range session.Messages[:max(len(session.Messages)+adding-stored, 0)] {
+		if msg.Timestamp.After(session.SummarizedThrough) {
+			trimmed = append(trimmed, msg)
+		}
+	}
+	previous := session.Summary
+	session.mu.Unlock()
+	if len(trimmed) == 0 {
+		return
+	}
+
+	// The reply is ready, so the summary may outlast the request's deadline
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), summaryTimeout)
+	defer cancel()
+	summary, err := a.summarize(ctx, req, chain, previous, trimmed)
+	if err != nil {
+		log.Printf("⚠️  Summary before trimming failed, dropping %d unsummarized messages: %v", len(trimmed), err)
+		return
+	}
+	a.store.SetSummary(session, summary, trimmed[len(trimmed)-1].Timestamp)
+	log.Printf("📝 Summarized %d messages before trimming them for %s user %s", len(trimmed), session.Platform, session.UserID)
+}
+
+// summarize asks the first available provider in chain to merge the
+// previous summary and the overflowing turns into a new summary. Its usage
+// is charged to the conversation's user.
+func (a *AIService) summarize(ctx context.Context, req ChatRequest, chain []Provider, previous string, messages []ChatMessage) (string, error) {
+	var b strings.Builder
+	b.WriteString("Summarize this conversation between a user and Kit, an AI assistant, in under 150 words. ")
+	b.WriteString("Keep facts, names, decisions and open questions that later replies may need. Reply with the summary only.\n\n")
+	if previous != "" {
+		fmt.Fprintf(&b, "Earlier summary:\n%s\n\n", previous)
+	}
+	b.WriteString("Conversation:\n")
+	for _, msg := range messages {
+		speaker := "User"
+		if msg.Role == "assistant" {
+			speaker = "Kit"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", speaker, msg.Content)
+	}
+	prompt := b.String()
+
+	for _, provider := range chain {
+		if !a.health.allow(provider.Name()) {
+			continue
+		}
+		reply, err := a.generate(ctx, provider, prompt, &Session{}, nil, nil)
+		a.health.record(provider.Name(), err)
+		if err != nil {
+			continue
+		}
+		a.usage.record(req, reply.Usage)
+		if strings.TrimSpace(reply.Text) != "" {
+			return reply.Text, nil
+		}
+	}
+	return "", fmt.Errorf("no provider available to summarize")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextWindowSummarizesOverflow(t *testing.T) {
+	store := NewInMemorySessionStore(SessionRetention{MaxMessages: 50})
+	var prompts [][]ChatMessage
+	echo := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if strings.HasPrefix(message, "Summarize") {
+				return Reply{Text: "The user asked about camp dates."}, nil
+			}
+			prompts = append(prompts, session.History(maxPromptHistory))
+			return Reply{Text: "ok"}, nil
+		},
+	}
+	svc := NewAIService(store, nil, echo)
+	svc.SetContextBudget(60)
+
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}
+	long := strings.Repeat("camp dates ", 10) // ~30 tokens with overhead
+	for i := 0; i < 3; i++ {
+		req.Message = long
+		svc.Respond(context.Background(), req)
+		svc.background.Wait()
+	}
+
+	session := store.GetOrCreate("discord", "u1", "c1")
+	if session.Summary != "The user asked about camp dates." {
+		t.Fatalf("summary not stored: %q", session.Summary)
+	}
+	last := prompts[len(prompts)-1]
+	if len(last) == 0 || !strings.HasPrefix(last[0].Content, summaryPreamble) || last[1].Role != "assistant" {
+		t.Fatalf("last prompt should open with the summary pair, got %+v", last)
+	}
+
+	view, _ := svc.fitContext("echo", session, "next")
+	for _, msg := range view.Messages[2:] {
+		if !msg.Timestamp.After(session.SummarizedThrough) {
+			t.Fatalf("summarized message replayed: %+v", msg)
+		}
+	}
+}
+
+func TestSummaryFollowsTheRequestRoute(t *testing.T) {
+	summarizedBy := make(map[string]int)
+	provider := func(name string) providerFunc {
+		return providerFunc{name: name, fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if strings.HasPrefix(message, "Summarize") {
+				summarizedBy[name]++
+				return Reply{Text: "summary by " + name}, nil
+			}
+			return Reply{Text: "ok"}, nil
+		}}
+	}
+	store := NewInMemorySessionStore(SessionRetention{MaxMessages: 50})
+	svc := NewAIService(store, nil, provider("cloud"), provider("onprem"), provider("spare"))
+	svc.SetContextBudget(60)
+	svc.SetRoutingPolicy(&RoutingPolicy{Routes: []Route{{
+		Name:      "private",
+		Match:     RouteMatch{Channels: []string{"secret"}},
+		Providers: []string{"onprem"},
+		Exclusive: true,
+	}}})
+
+	chat := func(channel string) *Session {
+		req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: channel, Message: strings.Repeat("camp dates ", 10)}
+		for i := 0; i < 3; i++ {
+			svc.Respond(context.Background(), req)
+			svc.background.Wait()
+		}
+		return store.GetOrCreate("discord", "u1", channel)
+	}
+
+	// The exclusive route's conversation is summarized on its own provider
+	if session := chat("secret"); session.Summary != "summary by onprem" || summarizedBy["cloud"] != 0 {
+		t.Fatalf("summary %q, summarized by %v", session.Summary, summarizedBy)
+	}
+	// A pinned provider summarizes the conversations it answers
+	if err := svc.PinProvider("discord", "u1", "general", "spare"); err != nil {
+		t.Fatal(err)
+	}
+	if session := chat("general"); session.Summary != "summary by spare" || summarizedBy["cloud"] != 0 {
+		t.Fatalf("summary %q, summarized by %v", session.Summary, summarizedBy)
+	}
+}
+
+func TestTrimmedTurnsAreSummarizedFirst(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t, 4, time.Hour)
+	fileStore, err := NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.jsonl"), SessionRetention{MaxMessages: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+
+	for name, store := range map[string]SessionStore{
+		"memory": NewInMemorySessionStore(SessionRetention{MaxMessages: 4}),
+		"file":   fileStore,
+		"redis":  redisStore,
+	} {
+		var summarized strings.Builder
+		echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if strings.HasPrefix(message, "Summarize") {
+				summarized.WriteString(message)
+				return Reply{Text: "a summary"}, nil
+			}
+			return Reply{Text: "ok"}, nil
+		}}
+		svc := NewAIService(store, nil, echo)
+		req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}
+		for i := 0; i < 15; i++ {
+			req.Message = fmt.Sprintf("question %d", i)
+			svc.Respond(context.Background(), req)
+			svc.background.Wait()
+		}
+
+		// Every question is in the summary or still in the history
+		session := store.GetOrCreate("discord", "u1", "c1")
+		for i := 0; i < 15; i++ {
+			question := fmt.Sprintf("question %d\n", i)
+			kept := false
+			for _, msg := range session.Messages {
+				kept = kept || msg.Content+"\n" == question
+			}
+			if !kept && !strings.Contains(summarized.String(), question) {
+				t.Fatalf("%s: %q was trimmed before it was summarized", name, question)
+			}
+		}
+		if session.Summary == "" {
+			t.Fatalf("%s: no summary", name)
+		}
+	}
+}
+
+func TestSessionsNeverExceedMaxMessages(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t, 4, time.Hour)
+	fileStore, err := NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.jsonl"), SessionRetention{MaxMessages: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+
+	for name, store := range map[string]SessionStore{
+		"memory": NewInMemorySessionStore(SessionRetention{MaxMessages: 4}),
+		"file":   fileStore,
+		"redis":  redisStore,
+	} {
+		// Summaries fail, so nothing is ever covered by one
+		echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if strings.HasPrefix(message, "Summarize") {
+				return Reply{}, fmt.Errorf("summaries are down")
+			}
+			return Reply{Text: "ok"}, nil
+		}}
+		svc := NewAIService(store, nil, echo)
+		req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}
+		for i := 0; i < 10; i++ {
+			req.Message = fmt.Sprintf("question %d", i)
+			svc.Respond(context.Background(), req)
+			svc.background.Wait()
+			if session := store.GetOrCreate("discord", "u1", "c1"); len(session.Messages) > 4 {
+				t.Fatalf("%s: %d messages stored after %d turns", name, len(session.Messages), i+1)
+			}
+		}
+		if name == "redis" {
+			if n := redisStore.client.LLen(context.Background(), "kit:session:discord:u1:c1:messages").Val(); n != 4 {
+				t.Fatalf("redis: %d messages in the list", n)
+			}
+		}
+	}
+}
+
+func TestFitContextStartsWithUser(t *testing.T) {
+	svc := NewAIService(nil, nil)
+	svc.SetContextBudget(20)
+	now := time.Now()
+	session := &Session{Messages: []ChatMessage{
+		{Role: "user", Content: strings.Repeat("x", 40), Timestamp: now},
+		{Role: "assistant", Content: "short", Timestamp: now.Add(time.Second)},
+	}}
+
+	view, overflow := svc.fitContext("claude", session, "hi")
+	if len(view.Messages) != 0 || len(overflow) != 2 {
+		t.Fatalf("window should drop the orphaned assistant turn: view=%+v overflow=%d", view.Messages, len(overflow))
+	}
+}
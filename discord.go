@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -43,6 +44,7 @@ func NewDiscordBot(token string, geminiClient *GeminiClient, claudeClient *Claud
 	// Add event handlers
 	session.AddHandler(bot.onReady)
 	session.AddHandler(bot.onMessageCreate)
+	session.AddHandler(bot.onMessageDelete)
 
 	return bot, nil
 }
@@ -96,13 +98,60 @@ func (d *DiscordBot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageC
 
 	log.Printf("🔵 Discord message received from %s: %s", m.Author.Username, m.Content)
 
-	// Process the message
+	// Commands and camp queries are answered directly; AI replies are
+	// streamed into a placeholder message when enabled
 	hasCampRole := d.memberHasCampRole(s, m)
-	response := d.generateDiscordResponse(m.Content, m.Author.ID, m.ChannelID, hasCampRole)
+	cleanMessage := d.cleanDiscordMessage(m.Content)
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!usage") {
+		if args, ok := isUsageExport(strings.Join(fields[1:], " ")); ok {
+			d.sendUsageExport(s, m.ChannelID, args, m.Author.ID)
+			return
+		}
+	}
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!compare") {
+		ctx, done := globalInflight.start(inflightKey("discord", m.ChannelID, m.ID))
+		defer done()
+		s.ChannelTyping(m.ChannelID)
+		question := strings.TrimSpace(strings.TrimPrefix(cleanMessage, fields[0]))
+		if response := settledReply(ctx, handleCompareCommand(ctx, discordChatRequest(m, question, hasCampRole))); response != "" {
+			d.sendChunks(s, m.ChannelID, response)
+		}
+		return
+	}
+	if fields := strings.Fields(cleanMessage); len(fields) > 1 && strings.EqualFold(fields[0], "!history") && strings.EqualFold(fields[1], "export") {
+		d.sendHistoryExport(s, m.ChannelID, strings.Join(fields[2:], " "), m.Author.ID)
+		return
+	}
+	if response := d.handleDirectQueries(cleanMessage, m.Author.ID, m.ChannelID, hasCampRole); response != "" {
+		d.sendChunks(s, m.ChannelID, response)
+		return
+	}
+
+	// The reply is canceled on shutdown or if the user deletes the message
+	ctx, done := globalInflight.start(inflightKey("discord", m.ChannelID, m.ID))
+	defer done()
+	if d.aiService != nil && globalStreamResponses {
+		d.streamDiscordResponse(ctx, s, m, cleanMessage, hasCampRole)
+		return
+	}
+
+	if response := settledReply(ctx, d.generateDiscordResponse(ctx, m, hasCampRole)); response != "" {
+		d.sendChunks(s, m.ChannelID, response)
+	}
+}
+
+// onMessageDelete cancels the reply to a message deleted while Kit was
+// still generating it.
+func (d *DiscordBot) onMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if globalInflight.cancel(inflightKey("discord", m.ChannelID, m.ID)) {
+		log.Printf("🗑️  Discord message %s deleted; reply canceled", m.ID)
+	}
+}
 
-	// Send response, splitting long messages to stay under Discord's limit
-	for _, chunk := range splitMessage(response, 1900) {
-		if _, err := s.ChannelMessageSend(m.ChannelID, chunk); err != nil {
+// sendChunks sends a response, splitting long messages to stay under Discord's limit
+func (d *DiscordBot) sendChunks(s *discordgo.Session, channelID, response string) {
+	for _, chunk := range splitMessage(response, discordStreamLimit) {
+		if _, err := s.ChannelMessageSend(channelID, chunk); err != nil {
 			log.Printf("❌ Failed to send Discord message: %v", err)
 			return
 		}
@@ -110,6 +159,91 @@ func (d *DiscordBot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageC
 	log.Printf("✅ Discord response sent successfully")
 }
 
+// sendUsageExport DMs the admin the usage ledger as a JSON file, since it
+// shows every user's usage.
+func (d *DiscordBot) sendUsageExport(s *discordgo.Session, channelID, args, userID string) {
+	data, reply := usageExport(args, userID)
+	if data == nil {
+		d.sendChunks(s, channelID, reply)
+		return
+	}
+	dm, err := s.UserChannelCreate(userID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+			Content: "📊 Kit usage export",
+			Files: []*discordgo.File{{
+				Name:        "kit-usage.json",
+				ContentType: "application/json",
+				Reader:      bytes.NewReader(data),
+			}},
+		})
+	}
+	if err != nil {
+		log.Printf("❌ Failed to DM Discord usage export: %v", err)
+		d.sendChunks(s, channelID, "❌ I couldn't DM you. Check that you allow direct messages from server members.")
+		return
+	}
+	if dm.ID != channelID {
+		d.sendChunks(s, channelID, "📬 I've sent the usage export in a DM.")
+	}
+}
+
+// sendHistoryExport DMs the user their stored conversations as a file.
+func (d *DiscordBot) sendHistoryExport(s *discordgo.Session, channelID, args, userID string) {
+	data, filename, reply := sessionsExport("discord", args, userID)
+	if data == nil {
+		d.sendChunks(s, channelID, reply)
+		return
+	}
+	contentType := "text/markdown"
+	if strings.HasSuffix(filename, ".json") {
+		contentType = "application/json"
+	}
+	dm, err := s.UserChannelCreate(userID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+			Content: "📦 Your Kit conversation history",
+			Files:   []*discordgo.File{{Name: filename, ContentType: contentType, Reader: bytes.NewReader(data)}},
+		})
+	}
+	if err != nil {
+		log.Printf("❌ Failed to DM Discord history export: %v", err)
+		d.sendChunks(s, channelID, "❌ I couldn't DM you. Check that you allow direct messages from server members.")
+		return
+	}
+	if dm.ID != channelID {
+		d.sendChunks(s, channelID, "📬 I've sent your conversation history in a DM.")
+	}
+}
+
+// streamDiscordResponse posts a placeholder and edits it as the AI reply
+// streams in, rolling over into new messages past Discord's length limit.
+func (d *DiscordBot) streamDiscordResponse(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, cleanMessage string, hasCampRole bool) {
+	channelID := m.ChannelID
+	log.Printf("💭 Streaming Discord response for: '%s'", cleanMessage)
+	streamer := newMessageStreamer(
+		func(text string) (string, error) {
+			msg, err := s.ChannelMessageSend(channelID, text)
+			if err != nil {
+				return "", err
+			}
+			return msg.ID, nil
+		},
+		func(messageID, text string) error {
+			_, err := s.ChannelMessageEdit(channelID, messageID, text)
+			return err
+		},
+		discordStreamLimit,
+		discordEditInterval,
+	)
+	streamer.Start()
+	response := d.aiService.RespondStream(ctx, discordChatRequest(m, cleanMessage, hasCampRole), streamer.Update)
+	streamer.Settle(ctx, response, func(messageID string) error {
+		return s.ChannelMessageDelete(channelID, messageID)
+	})
+	log.Printf("✅ Discord response streamed successfully")
+}
+
 // splitMessage splits text into chunks of at most limit characters,
 // preferring to break on newlines so formatting stays intact.
 func splitMessage(text string, limit int) []string {
@@ -180,13 +314,54 @@ func (d *DiscordBot) memberHasCampRole(s *discordgo.Session, m *discordgo.Messag
 	return false
 }
 
-// generateDiscordResponse generates a response for Discord messages
-func (d *DiscordBot) generateDiscordResponse(content, userID, channelID string, hasCampRole bool) string {
+// discordChatRequest builds the AIService request for a Discord message.
+// Its attachments are downloaded only if the request gets past the limiter.
+func discordChatRequest(m *discordgo.MessageCreate, cleanMessage string, hasCampRole bool) ChatRequest {
+	req := ChatRequest{
+		Platform:      "discord",
+		UserID:        m.Author.ID,
+		ChannelID:     m.ChannelID,
+		WorkspaceID:   m.GuildID,
+		Message:       cleanMessage,
+		DirectMessage: m.GuildID == "",
+		HasCampRole:   hasCampRole,
+	}
+	if len(m.Attachments) > 0 {
+		sources := make([]attachmentSource, 0, len(m.Attachments))
+		for _, attachment := range m.Attachments {
+			sources = append(sources, attachmentSource{
+				Name:     attachment.Filename,
+				URL:      attachment.URL,
+				MIMEType: attachment.ContentType,
+				Size:     int64(attachment.Size),
+			})
+		}
+		req.LoadAttachments = func(ctx context.Context) []Attachment {
+			return globalAttachmentLimits.load(ctx, sources)
+		}
+	}
+	return req
+}
+
+// generateDiscordResponse generates a response for Discord messages that
+// handleDirectQueries left to the AI
+func (d *DiscordBot) generateDiscordResponse(ctx context.Context, m *discordgo.MessageCreate, hasCampRole bool) string {
 	// Clean the message (remove mentions)
-	cleanMessage := d.cleanDiscordMessage(content)
+	cleanMessage := d.cleanDiscordMessage(m.Content)
 
 	log.Printf("💭 Generating Discord response for: '%s'", cleanMessage)
 
+	if d.aiService != nil {
+		return d.aiService.Respond(ctx, discordChatRequest(m, cleanMessage, hasCampRole))
+	}
+
+	// Fallback to basic responses
+	return d.generateDiscordFallback(cleanMessage)
+}
+
+// handleDirectQueries answers commands and camp data questions without an
+// AI provider. Returns "" when the message should go to the AI.
+func (d *DiscordBot) handleDirectQueries(cleanMessage, userID, channelID string, hasCampRole bool) string {
 	// Check for special commands first
 	if response := d.handleDiscordCommands(cleanMessage); response != "" {
 		return response
@@ -200,6 +375,32 @@ func (d *DiscordBot) generateDiscordResponse(content, userID, channelID string,
 		return campLinksMessage()
 	}
 
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!cache") {
+		return handleCacheCommand(strings.Join(fields[1:], " "), userID)
+	}
+
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!usage") {
+		return handleUsageCommand(strings.Join(fields[1:], " "), userID)
+	}
+
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!persona") {
+		return handlePersonaCommand(strings.Join(fields[1:], " "), userID, channelID)
+	}
+
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!kb") {
+		return handleKnowledgeCommand("discord", strings.Join(fields[1:], " "), userID, func(text string) {
+			d.sendChunks(d.session, channelID, text)
+		})
+	}
+
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!model") {
+		return handleModelCommand("discord", strings.Join(fields[1:], " "), userID, channelID)
+	}
+
+	if fields := strings.Fields(cleanMessage); len(fields) > 0 && strings.EqualFold(fields[0], "!forget") {
+		return handleForgetCommand("discord", strings.Join(fields[1:], " "), userID)
+	}
+
 	// Camp Power-Up data queries: answered directly, never sent to AI providers
 	if globalCampClient != nil {
 		if response := globalCampClient.HandleQuery(cleanMessage, userID, hasCampRole); response != "" {
@@ -207,17 +408,7 @@ func (d *DiscordBot) generateDiscordResponse(content, userID, channelID string,
 		}
 	}
 
-	if d.aiService != nil {
-		return d.aiService.Respond(context.Background(), ChatRequest{
-			Platform:  "discord",
-			UserID:    userID,
-			ChannelID: channelID,
-			Message:   cleanMessage,
-		})
-	}
-
-	// Fallback to basic responses
-	return d.generateDiscordFallback(cleanMessage)
+	return ""
 }
 
 // cleanDiscordMessage removes bot mentions and cleans up the message
@@ -238,19 +429,17 @@ func (d *DiscordBot) handleDiscordCommands(message string) string {
 
 	switch {
 	case cleanMessage == "!status" || cleanMessage == "!health":
-		aiStatus := "❌ Offline"
-		if d.geminiClient != nil {
-			aiStatus = "✅ Online (Gemini)"
-		} else if d.claudeClient != nil {
-			aiStatus = "✅ Online (Claude)"
+		var providers []ProviderStatus
+		if d.aiService != nil {
+			providers = d.aiService.ProviderStatus()
 		}
 
 		return fmt.Sprintf("🤖 **Kit Discord Status**\n"+
 			"• Bot Status: ✅ Online and Connected\n"+
-			"• AI Engine: %s\n"+
+			"%s\n"+
 			"• Started: %s\n"+
 			"• Platform: Discord\n"+
-			"• Ready to help! 🚀", aiStatus, d.startTime)
+			"• Ready to help! 🚀", formatServiceStatus(d.aiService, providers), d.startTime)
 
 	case cleanMessage == "!help" || cleanMessage == "!commands":
 		return "🤖 **Kit Discord Commands**\n\n" +
@@ -260,6 +449,14 @@ func (d *DiscordBot) handleDiscordCommands(message string) string {
 			"• `!version` - Show version info\n" +
 			"• `!myid` - Show your Discord user ID\n" +
 			"• `!links` - Camp Power-Up website links\n" +
+			"• `!cache [clear]` - Response cache stats (clear: admins)\n" +
+			"• `!usage [days|export]` - AI usage and cost report (admins)\n" +
+			"• `!persona [set <name>|reset]` - Show or switch this channel's persona (switch: admins)\n" +
+			"• `!kb [search <question>|reindex]` - Search the docs knowledge base (reindex: admins)\n" +
+			"• `!model [list|use <name>|reset]` - Choose which AI provider answers you here\n" +
+			"• `!compare <question>` - Ask every provider and compare answers (admins)\n" +
+			"• `!history export [markdown|json]` - DM you your conversation history\n" +
+			"• `!forget` - Delete everything Kit keeps about you\n" +
 			"• `!camp` - Camp registration commands (authorized users)\n\n" +
 			"**How to use Kit on Discord:**\n" +
 			"• Send direct messages for private conversations\n" +
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultEvalPlatform is the platform eval requests come from when a
+	// case doesn't name one; personas and routing rules see it.
+	defaultEvalPlatform = "discord"
+	// defaultJudgePassScore is the lowest judge score, out of 10, that
+	// passes a rubric.
+	defaultJudgePassScore = 7
+	// evalDefaultTarget labels results from the whole fallback chain.
+	evalDefaultTarget = "default"
+	// maxEvalAnswerChars bounds each answer quoted in the Markdown report;
+	// the JSON report keeps them whole.
+	maxEvalAnswerChars = 600
+)
+
+// judgeSystemPrompt asks the judge for a machine-readable score.
+const judgeSystemPrompt = `You grade answers written by an AI assistant against a rubric. ` +
+	`Reply with JSON only, in the form {"score": <0-10>, "reason": "<one sentence>"}, ` +
+	`where 10 means the answer fully meets the rubric and 0 means it ignores it.`
+
+// EvalSuite is a set of prompts and the properties their answers must have,
+// scored by kit-eval; see config/eval.example.yaml.
+type EvalSuite struct {
+	Name string `yaml:"name"`
+	// Providers are evaluated one at a time, each answering every case on
+	// its own. When empty the whole fallback chain answers, as in the bot.
+	Providers []string `yaml:"providers"`
+	// Judge is the provider that scores rubrics; the first provider when
+	// empty.
+	Judge     string     `yaml:"judge"`
+	PassScore int        `yaml:"pass_score"` // out of 10; 7 by default
+	Cases     []EvalCase `yaml:"cases"`
+}
+
+// EvalCase is one prompt and what its answer is checked for. Every check
+// that is set must pass for the case to pass.
+type EvalCase struct {
+	Name      string `yaml:"name"`
+	Prompt    string `yaml:"prompt"`
+	Platform  string `yaml:"platform"` // "discord" by default
+	ChannelID string `yaml:"channel"`  // a direct message when empty
+
+	MustContain    []string `yaml:"must_contain"`     // case-insensitive
+	MustNotContain []string `yaml:"must_not_contain"` // case-insensitive
+	MaxLength      int      `yaml:"max_length"`       // in characters
+	Regex          string   `yaml:"regex"`
+	Rubric         string   `yaml:"rubric"` // scored by the judge
+
+	pattern *regexp.Regexp
+}
+
+// LoadEvalSuite reads and checks an evaluation suite.
+func LoadEvalSuite(path string) (*EvalSuite, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-supplied path
+	if err != nil {
+		return nil, err
+	}
+	var suite EvalSuite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(suite.Cases) == 0 {
+		return nil, fmt.Errorf("%s has no cases", path)
+	}
+	if suite.Name == "" {
+		suite.Name = path
+	}
+	if suite.PassScore <= 0 {
+		suite.PassScore = defaultJudgePassScore
+	}
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case-%d", i+1)
+		}
+		if strings.TrimSpace(c.Prompt) == "" {
+			return nil, fmt.Errorf("case %q has no prompt", c.Name)
+		}
+		if c.Platform == "" {
+			c.Platform = defaultEvalPlatform
+		}
+		if c.Regex != "" {
+			if c.pattern, err = regexp.Compile(c.Regex); err != nil {
+				return nil, fmt.Errorf("case %q: %w", c.Name, err)
+			}
+		}
+		if len(c.MustContain)+len(c.MustNotContain) == 0 && c.MaxLength <= 0 && c.Regex == "" && c.Rubric == "" {
+			return nil, fmt.Errorf("case %q checks nothing", c.Name)
+		}
+	}
+	return &suite, nil
+}
+
+// EvalCheck is the outcome of one property checked on an answer.
+type EvalCheck struct {
+	Check  string `json:"check"` // e.g. `must_contain "tent"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// EvalResult is one target's answer to one case.
+type EvalResult struct {
+	Case      string      `json:"case"`
+	Target    string      `json:"target"`
+	Prompt    string      `json:"prompt"`
+	Answer    string      `json:"answer"`
+	Provider  string      `json:"provider,omitempty"` // the one that answered
+	Model     string      `json:"model,omitempty"`
+	LatencyMS int64       `json:"latency_ms"`
+	Tokens    int         `json:"tokens"`
+	CostUSD   float64     `json:"cost_usd"`
+	Error     string      `json:"error,omitempty"`
+	Checks    []EvalCheck `json:"checks"`
+	Score     float64     `json:"score"` // fraction of checks passed
+	Passed    bool        `json:"passed"`
+}
+
+// EvalSummary totals a target's results.
+type EvalSummary struct {
+	Target        string  `json:"target"`
+	Cases         int     `json:"cases"`
+	Passed        int     `json:"passed"`
+	Score         float64 `json:"score"` // mean case score
+	MeanLatencyMS int64   `json:"mean_latency_ms"`
+	Tokens        int     `json:"tokens"`
+	CostUSD       float64 `json:"cost_usd"`
+}
+
+// EvalReport is everything a kit-eval run found.
+type EvalReport struct {
+	Suite      string        `json:"suite"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Judge      string        `json:"judge,omitempty"`
+	Summaries  []EvalSummary `json:"summaries"`
+	Results    []EvalResult  `json:"results"`
+}
+
+// Failed counts the results that didn't pass.
+func (r *EvalReport) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// evalRun sends a suite through the assistant once per target.
+type evalRun struct {
+	suite     *EvalSuite
+	providers []Provider
+	// targets are provider names, or evalDefaultTarget for the chain.
+	targets []string
+	judge   Provider
+	// configure applies the bot's settings (personas, knowledge base...) to
+	// each target's service.
+	configure func(*AIService)
+}
+
+// run answers and scores every case for every target, one request at a
+// time so latencies aren't skewed by each other.
+func (e *evalRun) run(ctx context.Context) *EvalReport {
+	report := &EvalReport{Suite: e.suite.Name, StartedAt: time.Now().UTC()}
+	if e.judge != nil {
+		report.Judge = e.judge.Name()
+	}
+	for _, target := range e.targets {
+		svc := e.service(target)
+		summary := EvalSummary{Target: target}
+		var latency int64
+		for i, c := range e.suite.Cases {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("🧪 [%s] %s", target, c.Name)
+			result := e.evaluate(ctx, svc, target, fmt.Sprintf("kit-eval-%d", i+1), c)
+			report.Results = append(report.Results, result)
+			summary.Cases++
+			if result.Passed {
+				summary.Passed++
+			}
+			summary.Score += result.Score
+			summary.Tokens += result.Tokens
+			summary.CostUSD += result.CostUSD
+			latency += result.LatencyMS
+		}
+		if summary.Cases > 0 {
+			summary.Score /= float64(summary.Cases)
+			summary.MeanLatencyMS = latency / int64(summary.Cases)
+		}
+		report.Summaries = append(report.Summaries, summary)
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report
+}
+
+// service builds a fresh assistant for target with an empty session store,
+// so no case sees another's history, and no fallback reply, so a failure
+// shows as one.
+func (e *evalRun) service(target string) *AIService {
+	providers := e.providers
+	if target != evalDefaultTarget {
+		providers = nil
+		for _, provider := range e.providers {
+			if provider.Name() == target {
+				providers = append(providers, provider)
+			}
+		}
+	}
+	svc := NewAIService(NewInMemorySessionStore(DefaultSessionRetention()), nil, providers...)
+	if e.configure != nil {
+		e.configure(svc)
+	}
+	return svc
+}
+
+// evaluate asks one case as userID and checks the answer.
+func (e *evalRun) evaluate(ctx context.Context, svc *AIService, target, userID string, c EvalCase) EvalResult {
+	result := EvalResult{Case: c.Name, Target: target, Prompt: c.Prompt}
+	req := ChatRequest{Platform: c.Platform, UserID: userID, ChannelID: c.ChannelID, Message: c.Prompt, DirectMessage: c.ChannelID == ""}
+	started := time.Now()
+	result.Answer = svc.Respond(ctx, req)
+	result.LatencyMS = time.Since(started).Milliseconds()
+	for _, rec := range svc.Usage().Records(0) {
+		if rec.UserID != userID {
+			continue
+		}
+		if result.Provider == "" {
+			result.Provider, result.Model = rec.Provider, rec.Model
+		}
+		result.Tokens += rec.PromptTokens + rec.CompletionTokens
+		result.CostUSD += rec.CostUSD
+	}
+
+	if strings.TrimSpace(result.Answer) == "" {
+		result.Error = "no provider answered"
+		if ctx.Err() != nil {
+			result.Error = ctx.Err().Error()
+		}
+	}
+	result.Checks = e.check(ctx, c, result.Answer)
+	passed := 0
+	for _, check := range result.Checks {
+		if check.Passed {
+			passed++
+		}
+	}
+	if result.Error == "" && len(result.Checks) > 0 {
+		result.Score = float64(passed) / float64(len(result.Checks))
+		result.Passed = passed == len(result.Checks)
+	}
+	return result
+}
+
+// check runs every check the case sets on answer.
+func (e *evalRun) check(ctx context.Context, c EvalCase, answer string) []EvalCheck {
+	var checks []EvalCheck
+	lower := strings.ToLower(answer)
+	for _, want := range c.MustContain {
+		check := EvalCheck{Check: fmt.Sprintf("must_contain %q", want), Passed: strings.Contains(lower, strings.ToLower(want))}
+		if !check.Passed {
+			check.Detail = "not found"
+		}
+		checks = append(checks, check)
+	}
+	for _, unwanted := range c.MustNotContain {
+		check := EvalCheck{Check: fmt.Sprintf("must_not_contain %q", unwanted), Passed: !strings.Contains(lower, strings.ToLower(unwanted))}
+		if !check.Passed {
+			check.Detail = "found"
+		}
+		checks = append(checks, check)
+	}
+	if c.MaxLength > 0 {
+		length := utf8.RuneCountInString(answer)
+		checks = append(checks, EvalCheck{
+			Check:  fmt.Sprintf("max_length %d", c.MaxLength),
+			Passed: length <= c.MaxLength,
+			Detail: fmt.Sprintf("%d characters", length),
+		})
+	}
+	if c.pattern != nil {
+		check := EvalCheck{Check: "regex " + c.Regex, Passed: c.pattern.MatchString(answer)}
+		if !check.Passed {
+			check.Detail = "no match"
+		}
+		checks = append(checks, check)
+	}
+	if c.Rubric != "" {
+		checks = append(checks, e.judgeRubric(ctx, c, answer))
+	}
+	return checks
+}
+
+// judgeRubric has the judge score answer against the case's rubric.
+func (e *evalRun) judgeRubric(ctx context.Context, c EvalCase, answer string) EvalCheck {
+	check := EvalCheck{Check: "rubric"}
+	switch {
+	case e.judge == nil:
+		check.Detail = "no judge provider"
+		return check
+	case strings.TrimSpace(answer) == "":
+		check.Detail = "nothing to judge"
+		return check
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultRequestTimeout)
+	defer cancel()
+	prompt := fmt.Sprintf("Rubric: %s\n\nQuestion: %s\n\nAnswer:\n%s", c.Rubric, c.Prompt, answer)
+	reply, err := e.judge.Generate(ctx, prompt, &Session{Platform: c.Platform, UserID: "kit-eval-judge", SystemPrompt: judgeSystemPrompt})
+	if err != nil {
+		check.Detail = fmt.Sprintf("judge failed (%s): %v", classifyError(err), err)
+		return check
+	}
+	score, reason, err := parseJudgeReply(reply.Text)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	check.Passed = score >= float64(e.suite.PassScore)
+	check.Detail = fmt.Sprintf("%s/10", strconv.FormatFloat(score, 'f', -1, 64))
+	if reason != "" {
+		check.Detail += ": " + reason
+	}
+	return check
+}
+
+// judgeScorePattern finds a score when the judge ignored the JSON format.
+var judgeScorePattern = regexp.MustCompile(`(?i)score\W{0,5}(\d+(?:\.\d+)?)`)
+
+// parseJudgeReply reads the judge's score out of 10 and its reason. Models
+// often wrap the JSON in prose or a code fence, so the outermost braces are
+// tried first and a bare "score: 8" is accepted.
+func parseJudgeReply(text string) (float64, string, error) {
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		var verdict struct {
+			Score  *float64 `json:"score"`
+			Reason string   `json:"reason"`
+		}
+		if json.Unmarshal([]byte(text[start:end+1]), &verdict) == nil && verdict.Score != nil {
+			return clampScore(*verdict.Score), strings.TrimSpace(verdict.Reason), nil
+		}
+	}
+	if m := judgeScorePattern.FindStringSubmatch(text); m != nil {
+		score, _ := strconv.ParseFloat(m[1], 64)
+		return clampScore(score), "", nil
+	}
+	excerpt := []rune(strings.TrimSpace(text))
+	if len(excerpt) > 80 {
+		excerpt = append(excerpt[:80], '…')
+	}
+	return 0, "", fmt.Errorf("judge gave no score: %q", string(excerpt))
+}
+
+func clampScore(score float64) float64 {
+	return max(0, min(10, score))
+}
+
+// WriteMarkdown renders the report for people: a table per target, then
+// each case with its checks and an excerpt of the answer.
+func (r *EvalReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Kit eval: %s\n\n", r.Suite)
+	fmt.Fprintf(&b, "Run %s, took %s.", r.StartedAt.Format("2006-01-02 15:04 UTC"), r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	if r.Judge != "" {
+		fmt.Fprintf(&b, " Rubrics judged by `%s`.", r.Judge)
+	}
+	b.WriteString("\n\n| Target | Passed | Score | Mean latency | Tokens | Cost |\n|---|---|---|---|---|---|\n")
+	for _, s := range r.Summaries {
+		fmt.Fprintf(&b, "| `%s` | %d/%d | %.0f%% | %s | %s | $%.4f |\n", s.Target, s.Passed, s.Cases, s.Score*100,
+			(time.Duration(s.MeanLatencyMS) * time.Millisecond).Round(10*time.Millisecond), formatTokenCount(s.Tokens), s.CostUSD)
+	}
+
+	for _, s := range r.Summaries {
+		fmt.Fprintf(&b, "\n## %s\n", s.Target)
+		for _, result := range r.Results {
+			if result.Target != s.Target {
+				continue
+			}
+			mark := "✅"
+			if !result.Passed {
+				mark = "❌"
+			}
+			fmt.Fprintf(&b, "\n### %s %s (%.0f%%)\n\n", mark, result.Case, result.Score*100)
+			if result.Provider != "" {
+				fmt.Fprintf(&b, "Answered by `%s`", result.Provider)
+				if result.Model != "" {
+					fmt.Fprintf(&b, " (%s)", result.Model)
+				}
+				fmt.Fprintf(&b, " in %s.\n\n", (time.Duration(result.LatencyMS) * time.Millisecond).Round(10*time.Millisecond))
+			}
+			if result.Error != "" {
+				fmt.Fprintf(&b, "⚠️ %s\n\n", result.Error)
+			}
+			for _, check := range result.Checks {
+				checkMark := "✅"
+				if !check.Passed {
+					checkMark = "❌"
+				}
+				fmt.Fprintf(&b, "- %s `%s`", checkMark, check.Check)
+				if check.Detail != "" {
+					b.WriteString(": " + check.Detail)
+				}
+				b.WriteString("\n")
+			}
+			if answer := strings.TrimSpace(result.Answer); answer != "" {
+				if runes := []rune(answer); len(runes) > maxEvalAnswerChars {
+					answer = string(runes[:maxEvalAnswerChars]) + "…"
+				}
+				b.WriteString("\n")
+				for _, line := range strings.Split(answer, "\n") {
+					b.WriteString("> " + line + "\n")
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the whole report, answers included, for scripts and
+// comparisons between runs.
+func (r *EvalReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// runEval implements kit-eval (the bot's "eval" mode). It returns the exit
+// status: 0 when every case passed, 1 when some failed and 2 when the suite
+// couldn't run.
+func runEval(args []string) int {
+	flags := flag.NewFlagSet("kit-eval", flag.ContinueOnError)
+	suitePath := flags.String("suite", "", "evaluation suite (YAML), e.g. config/eval.example.yaml")
+	providerList := flags.String("providers", "", "comma-separated providers to evaluate one at a time (default: the suite's, or the whole fallback chain)")
+	judgeName := flags.String("judge", "", "provider that scores rubrics (default: the suite's judge, or the first provider)")
+	markdownPath := flags.String("md", "", "write the Markdown report to this file instead of standard output")
+	jsonPath := flags.String("json", "", "also write the JSON report to this file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *suitePath == "" {
+		fmt.Fprintln(os.Stderr, "kit-eval: -suite is required")
+		flags.Usage()
+		return 2
+	}
+	suite, err := LoadEvalSuite(*suitePath)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	clients := aiClientsFromEnv()
+	e := &evalRun{suite: suite, providers: clients.providers(), targets: suite.Providers}
+	if len(e.providers) == 0 {
+		log.Println("❌ No AI providers configured; set MOCK_AI_RESPONSES or AI_CASSETTE to evaluate offline")
+		return 2
+	}
+	if names := splitList(*providerList); len(names) > 0 {
+		e.targets = names
+	}
+	if len(e.targets) == 0 {
+		e.targets = []string{evalDefaultTarget}
+	}
+	judge := suite.Judge
+	if *judgeName != "" {
+		judge = *judgeName
+	}
+	names := make([]string, 0, len(e.providers))
+	for _, provider := range e.providers {
+		names = append(names, provider.Name())
+	}
+	for _, name := range append(slices.Clone(e.targets), judge) {
+		if name != "" && name != evalDefaultTarget && !slices.Contains(names, name) {
+			log.Printf("❌ Unknown provider %q; configured: %s", name, strings.Join(names, ", "))
+			return 2
+		}
+	}
+	e.judge = e.providers[0]
+	for _, provider := range e.providers {
+		if provider.Name() == judge {
+			e.judge = provider
+		}
+	}
+
+	// The same settings as the bot, minus the cache, rate limits and
+	// routing that would make runs differ
+	kb := knowledgeBaseFromEnv(clients.gemini, clients.embeddingClient())
+	if kb != nil {
+		if err := kb.Load(ctx); err != nil {
+			log.Printf("❌ Failed to index knowledge base: %v", err)
+			kb = nil
+		}
+	}
+	var prices PriceTable
+	if pricingPath := os.Getenv("AI_PRICING_CONFIG"); pricingPath != "" {
+		if prices, err = LoadPriceTable(pricingPath); err != nil {
+			log.Printf("❌ Failed to load price table: %v", err)
+		}
+	}
+	personas, redactor := personasFromEnv(), redactorFromEnv()
+	e.configure = func(svc *AIService) {
+		svc.SetPersonas(personas)
+		svc.SetPriceTable(prices)
+		if timeout, ok := requestTimeoutFromEnv(); ok {
+			svc.SetRequestTimeout(timeout)
+		}
+		if kb != nil {
+			svc.SetKnowledgeBase(kb)
+		}
+		if redactor != nil {
+			svc.SetRedactor(redactor)
+		}
+	}
+
+	report := e.run(ctx)
+	if err := writeEvalReport(*markdownPath, report.WriteMarkdown); err != nil {
+		log.Printf("❌ Failed to write Markdown report: %v", err)
+		return 2
+	}
+	if *jsonPath != "" {
+		if err := writeEvalReport(*jsonPath, report.WriteJSON); err != nil {
+			log.Printf("❌ Failed to write JSON report: %v", err)
+			return 2
+		}
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Println("🛑 Evaluation interrupted; the report covers the cases run so far")
+	}
+	if failed := report.Failed(); failed > 0 {
+		log.Printf("📋 %d of %d results failed", failed, len(report.Results))
+		return 1
+	}
+	log.Printf("📋 All %d results passed", len(report.Results))
+	return 0
+}
+
+// writeEvalReport writes a report to path, or to standard output when path
+// is empty.
+func writeEvalReport(path string, write func(io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path) // #nosec G304 -- operator-supplied path
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
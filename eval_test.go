@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEvalSuite(t *testing.T, yaml string) *EvalSuite {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	suite, err := LoadEvalSuite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return suite
+}
+
+func TestLoadEvalSuiteDefaultsAndValidation(t *testing.T) {
+	suite := writeEvalSuite(t, `
+name: smoke
+cases:
+  - prompt: What should I pack?
+    must_contain: [tent]
+    regex: (?i)bag
+`)
+	c := suite.Cases[0]
+	if suite.PassScore != defaultJudgePassScore || c.Name != "case-1" || c.Platform != defaultEvalPlatform || c.pattern == nil {
+		t.Fatalf("defaults not applied: %+v %+v", suite, c)
+	}
+
+	dir := t.TempDir()
+	for name, yaml := range map[string]string{
+		"empty":     "name: none\n",
+		"no-prompt": "cases:\n  - name: a\n    max_length: 10\n",
+		"no-checks": "cases:\n  - prompt: hi\n",
+		"bad-regex": "cases:\n  - prompt: hi\n    regex: \"(\"\n",
+	} {
+		path := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadEvalSuite(path); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEvalRunScoresEachTarget(t *testing.T) {
+	suite := writeEvalSuite(t, `
+name: smoke
+cases:
+  - name: packing
+    prompt: What should I pack?
+    must_contain: [TENT]
+    must_not_contain: [sorry]
+    max_length: 60
+    rubric: Gives a packing list.
+  - name: long
+    prompt: Tell me everything
+    max_length: 10
+`)
+	verdict := MockRule{Match: `Answer:\n.*tent`, Reply: `Looks good. {"score": 9, "reason": "Lists gear."}`}
+	if err := verdict.compile(); err != nil {
+		t.Fatal(err)
+	}
+	e := &evalRun{
+		suite: suite,
+		providers: []Provider{
+			newMockProvider(MockProviderConfig{Name: "camp", Reply: "Bring a tent and a sleeping bag."}),
+			namedProvider("broken", 0, &ProviderError{Provider: "broken", StatusCode: 401}),
+		},
+		targets: []string{"camp", "broken", evalDefaultTarget},
+		judge:   newMockProvider(MockProviderConfig{Name: "judge", Reply: "score: 2", Rules: []MockRule{verdict}}),
+	}
+	report := e.run(context.Background())
+
+	if len(report.Results) != 6 || len(report.Summaries) != 3 {
+		t.Fatalf("expected 2 cases × 3 targets: %+v", report)
+	}
+	packing := report.Results[0]
+	if !packing.Passed || packing.Provider != "camp" || packing.Tokens == 0 || len(packing.Checks) != 4 {
+		t.Fatalf("packing case should pass on camp: %+v", packing)
+	}
+	if rubric := packing.Checks[3]; rubric.Detail != "9/10: Lists gear." {
+		t.Fatalf("judge verdict not parsed: %+v", rubric)
+	}
+	if long := report.Results[1]; long.Passed || long.Score != 0 {
+		t.Fatalf("long answer should fail max_length: %+v", long)
+	}
+	if broken := report.Results[2]; broken.Passed || broken.Error != "no provider answered" {
+		t.Fatalf("failing provider should fail its cases: %+v", broken)
+	}
+	if s := report.Summaries[0]; s.Target != "camp" || s.Passed != 1 || s.Score != 0.5 {
+		t.Fatalf("camp summary: %+v", s)
+	}
+	// camp leads the default chain, so it answers there too
+	if s := report.Summaries[2]; s.Passed != 1 {
+		t.Fatalf("default chain summary: %+v", s)
+	}
+	if report.Failed() != 4 {
+		t.Fatalf("failed = %d, want 4", report.Failed())
+	}
+
+	var md bytes.Buffer
+	if err := report.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"| `camp` | 1/2 | 50% |", "### ✅ packing (100%)", "- ❌ `max_length 10`: 32 characters", "> Bring a tent"} {
+		if !strings.Contains(md.String(), want) {
+			t.Fatalf("%q missing from:\n%s", want, md.String())
+		}
+	}
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded EvalReport
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded.Results) != 6 {
+		t.Fatalf("JSON report does not round-trip: %v", err)
+	}
+}
+
+func TestParseJudgeReply(t *testing.T) {
+	for _, tc := range []struct {
+		text   string
+		score  float64
+		reason string
+		ok     bool
+	}{
+		{`{"score": 7, "reason": "Fine."}`, 7, "Fine.", true},
+		{"```json\n{\"score\": 12}\n```", 10, "", true},
+		{"Score: 4.5 out of 10", 4.5, "", true},
+		{"I can't grade this.", 0, "", false},
+	} {
+		score, reason, err := parseJudgeReply(tc.text)
+		if (err == nil) != tc.ok || score != tc.score || reason != tc.reason {
+			t.Fatalf("parseJudgeReply(%q) = %v, %q, %v", tc.text, score, reason, err)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compactSlack is how many lines the journal may grow by, on top of double
+// its size after the last compaction, before it is rewritten.
+const compactSlack = 1000
+
+// journalRecord is one line of the session journal: a message appended to
+// the session identified by Key, a new rolling summary or pinned provider
+// for it, or the new state of the rate-limit bucket or counter named Key.
+type journalRecord struct {
+	Key       string          `json:"key"`
+	ID        string          `json:"id,omitempty"`
+	Platform  string          `json:"platform,omitempty"`
+	UserID    string          `json:"user_id,omitempty"`
+	ChannelID string          `json:"channel_id,omitempty"`
+	Message   *ChatMessage    `json:"message,omitempty"`
+	Summary   *journalSummary `json:"summary,omitempty"`
+	Provider  *string         `json:"provider,omitempty"` // "" unpins
+	// UpdatedAt keeps a session with no messages yet from looking idle
+	UpdatedAt *time.Time   `json:"updated_at,omitempty"`
+	Bucket    *tokenBucket `json:"bucket,omitempty"`
+	Counter   *counter     `json:"counter,omitempty"`
+}
+
+type journalSummary struct {
+	Text    string    `json:"text"`
+	Through time.Time `json:"through"`
+}
+
+// newJournalRecord fills the session fields of a record. Callers must hold
+// session.mu.
+func newJournalRecord(key string, session *Session) journalRecord {
+	return journalRecord{
+		Key:       key,
+		ID:        session.ID,
+		Platform:  session.Platform,
+		UserID:    session.UserID,
+		ChannelID: session.ChannelID,
+	}
+}
+
+// FileSessionStore is an in-memory store backed by a single append-only
+// journal file (JSON lines), so conversations survive restarts without an
+// external database. Each Append writes one line; the journal is replayed on
+// open and periodically compacted to just the live, retained messages.
+// Retention is the same as for InMemorySessionStore.
+type FileSessionStore struct {
+	*InMemorySessionStore
+
+	mu        sync.Mutex // guards the fields below; taken before the store lock
+	path      string
+	file      *os.File
+	records   int // lines in the journal
+	compactAt int // records count that triggers the next compaction
+}
+
+// NewFileSessionStore opens (or creates) the journal at path and loads the
+// sessions it contains.
+func NewFileSessionStore(path string, retention SessionRetention) (*FileSessionStore, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("create session dir: %w", err)
+		}
+	}
+
+	s := &FileSessionStore{
+		InMemorySessionStore: NewInMemorySessionStore(retention),
+		path:                 path,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	// Rewrite on open so expired and trimmed entries don't accumulate
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the journal into memory, applying retention as it goes.
+func (s *FileSessionStore) load() error {
+	f, err := os.Open(s.path) // #nosec G304 -- operator-configured path
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open session journal: %w", err)
+	}
+	defer f.Close()
+
+	mem := s.InMemorySessionStore
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	skipped := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Key == "" {
+			skipped++ // e.g. a line cut short by a crash
+			continue
+		}
+		if rec.Bucket != nil {
+			mem.buckets[rec.Key] = rec.Bucket
+			continue
+		}
+		if rec.Counter != nil {
+			mem.counters[rec.Key] = rec.Counter
+			continue
+		}
+		session, ok := mem.sessions[rec.Key]
+		if !ok {
+			session = &Session{ID: rec.ID, Platform: rec.Platform, UserID: rec.UserID, ChannelID: rec.ChannelID}
+			mem.sessions[rec.Key] = session
+		}
+		if rec.Summary != nil {
+			session.Summary = rec.Summary.Text
+			session.SummarizedThrough = rec.Summary.Through
+		}
+		if rec.Provider != nil {
+			session.Provider = *rec.Provider
+		}
+		if rec.UpdatedAt != nil && rec.UpdatedAt.After(session.UpdatedAt) {
+			session.UpdatedAt = *rec.UpdatedAt
+		}
+		if rec.Message != nil {
+			session.Messages = mem.retention.trim(append(session.Messages, *rec.Message))
+			if rec.Message.Timestamp.After(session.UpdatedAt) {
+				session.UpdatedAt = rec.Message.Timestamp
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read session journal: %w", err)
+	}
+	if skipped > 0 {
+		log.Printf("⚠️  Skipped %d unreadable lines in %s", skipped, s.path)
+	}
+	mem.sweepLocked(mem.now())
+	return nil
+}
+
+// Append records the message in memory and appends it to the journal.
+func (s *FileSessionStore) Append(session *Session, role, content string) {
+	if session == nil || strings.TrimSpace(content) == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemorySessionStore.Append(session, role, content)
+	session.mu.Lock()
+	if session.forgotten {
+		session.mu.Unlock()
+		return
+	}
+	rec := newJournalRecord(sessionKey(session.Platform, session.UserID, session.ChannelID), session)
+	msg := session.Messages[len(session.Messages)-1]
+	rec.Message = &msg
+	session.mu.Unlock()
+	s.writeLocked(rec)
+}
+
+// SetSummary records the summary in memory and appends it to the journal.
+func (s *FileSessionStore) SetSummary(session *Session, summary string, through time.Time) {
+	if session == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemorySessionStore.SetSummary(session, summary, through)
+	session.mu.Lock()
+	if session.forgotten {
+		session.mu.Unlock()
+		return
+	}
+	rec := newJournalRecord(sessionKey(session.Platform, session.UserID, session.ChannelID), session)
+	rec.Summary = &journalSummary{Text: session.Summary, Through: through}
+	session.mu.Unlock()
+	s.writeLocked(rec)
+}
+
+// SetProvider pins the provider in memory and appends it to the journal.
+func (s *FileSessionStore) SetProvider(session *Session, provider string) {
+	if session == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemorySessionStore.SetProvider(session, provider)
+	session.mu.Lock()
+	if session.forgotten {
+		session.mu.Unlock()
+		return
+	}
+	rec := newJournalRecord(sessionKey(session.Platform, session.UserID, session.ChannelID), session)
+	rec.Provider = &provider
+	updated := session.UpdatedAt
+	rec.UpdatedAt = &updated
+	session.mu.Unlock()
+	s.writeLocked(rec)
+}
+
+// TakeToken applies the bucket in memory and records its new state.
+func (s *FileSessionStore) TakeToken(key string, capacity int, per time.Duration, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok, wait := s.InMemorySessionStore.TakeToken(key, capacity, per, now)
+	s.InMemorySessionStore.mu.Lock()
+	bucket := *s.InMemorySessionStore.buckets[key]
+	s.InMemorySessionStore.mu.Unlock()
+	s.writeLocked(journalRecord{Key: key, Bucket: &bucket})
+	return ok, wait
+}
+
+// ReturnToken refills the bucket in memory and records its new state.
+func (s *FileSessionStore) ReturnToken(key string, capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemorySessionStore.ReturnToken(key, capacity)
+	s.InMemorySessionStore.mu.Lock()
+	b, ok := s.InMemorySessionStore.buckets[key]
+	var bucket tokenBucket
+	if ok {
+		bucket = *b
+	}
+	s.InMemorySessionStore.mu.Unlock()
+	if ok {
+		s.writeLocked(journalRecord{Key: key, Bucket: &bucket})
+	}
+}
+
+// AddCounter updates the counter in memory and records its new value.
+func (s *FileSessionStore) AddCounter(key string, delta int, expires time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value := s.InMemorySessionStore.AddCounter(key, delta, expires)
+	s.writeLocked(journalRecord{Key: key, Counter: &counter{Value: value, Expires: expires}})
+	return value
+}
+
+// ForgetUser deletes the user's sessions and rewrites the journal so their
+// messages are gone from disk too.
+func (s *FileSessionStore) ForgetUser(platform, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, _ := s.InMemorySessionStore.ForgetUser(platform, userID)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := s.compactLocked(); err != nil {
+		return removed, fmt.Errorf("rewrite session journal: %w", err)
+	}
+	return removed, nil
+}
+
+// ForgetSession deletes one session and rewrites the journal without it.
+func (s *FileSessionStore) ForgetSession(platform, userID, channelID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, _ := s.InMemorySessionStore.ForgetSession(platform, userID, channelID)
+	if !removed {
+		return false, nil
+	}
+	if err := s.compactLocked(); err != nil {
+		return true, fmt.Errorf("rewrite session journal: %w", err)
+	}
+	return true, nil
+}
+
+// writeLocked appends a record to the journal, compacting it when it has
+// grown too large. Callers must hold s.mu.
+func (s *FileSessionStore) writeLocked(rec journalRecord) {
+	if err := s.appendRecord(rec); err != nil {
+		log.Printf("⚠️  Session journal write failed: %v", err)
+		return
+	}
+	if s.records >= s.compactAt {
+		if err := s.compactLocked(); err != nil {
+			log.Printf("⚠️  Session journal compaction failed: %v", err)
+		}
+	}
+}
+
+func (s *FileSessionStore) appendRecord(rec journalRecord) error {
+	if s.file == nil {
+		return fmt.Errorf("session journal is closed")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+func (s *FileSessionStore) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the journal with only the live sessions, via a
+// temporary file and rename so a crash never leaves a partial journal.
+func (s *FileSessionStore) compactLocked() error {
+	var records []journalRecord
+	mem := s.InMemorySessionStore
+	mem.mu.Lock()
+	for key, session := range mem.sessions {
+		session.mu.Lock()
+		if session.Summary != "" {
+			rec := newJournalRecord(key, session)
+			rec.Summary = &journalSummary{Text: session.Summary, Through: session.SummarizedThrough}
+			records = append(records, rec)
+		}
+		if session.Provider != "" {
+			rec := newJournalRecord(key, session)
+			provider := session.Provider
+			rec.Provider = &provider
+			updated := session.UpdatedAt
+			rec.UpdatedAt = &updated
+			records = append(records, rec)
+		}
+		for _, msg := range session.Messages {
+			rec := newJournalRecord(key, session)
+			rec.Message = &msg
+			records = append(records, rec)
+		}
+		session.mu.Unlock()
+	}
+	for key, b := range mem.buckets {
+		bucket := *b
+		records = append(records, journalRecord{Key: key, Bucket: &bucket})
+	}
+	for key, c := range mem.counters {
+		value := *c
+		records = append(records, journalRecord{Key: key, Counter: &value})
+	}
+	mem.mu.Unlock()
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return fmt.Errorf("create session journal: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace session journal: %w", err)
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return fmt.Errorf("reopen session journal: %w", err)
+	}
+	s.records = len(records)
+	s.compactAt = 2*len(records) + compactSlack
+	return nil
+}
+
+// Close flushes the journal to disk and closes it.
+func (s *FileSessionStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
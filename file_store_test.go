@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSessionStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	retention := SessionRetention{MaxMessages: 4, IdleTTL: time.Hour}
+
+	store, err := NewFileSessionStore(path, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := store.GetOrCreate("discord", "u1", "c1")
+	for i := 0; i < 6; i++ {
+		store.Append(session, "user", fmt.Sprintf("message %d", i))
+	}
+	store.Close()
+
+	// Simulate a crash mid-write
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"key":"discord:u1:c1","mess`)
+	f.Close()
+
+	reopened, err := NewFileSessionStore(path, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got := reopened.GetOrCreate("discord", "u1", "c1")
+	if got.ID != session.ID {
+		t.Fatalf("session ID changed: %q != %q", got.ID, session.ID)
+	}
+	if len(got.Messages) != 4 || got.Messages[0].Content != "message 2" {
+		t.Fatalf("history not restored and trimmed: %+v", got.Messages)
+	}
+}
+
+func TestFileSessionStoreDropsIdleSessionsOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	store, err := NewFileSessionStore(path, SessionRetention{IdleTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	store.Append(store.GetOrCreate("slack", "u1", "D1"), "user", "old")
+	store.now = time.Now
+	store.Append(store.GetOrCreate("slack", "u2", "D2"), "user", "new")
+	store.Close()
+
+	reopened, err := NewFileSessionStore(path, SessionRetention{IdleTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 1 {
+		t.Fatalf("expected only the recent session after reload, got %d", reopened.Len())
+	}
+}
+
+func TestFileSessionStoreKeepsRateState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	store, err := NewFileSessionStore(path, DefaultSessionRetention())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	store.AddCounter("quota:requests:slack:u1:today", 3, expires)
+	store.TakeToken("rate:user:slack:u1", 1, time.Minute, time.Now())
+	store.Close()
+
+	reopened, err := NewFileSessionStore(path, DefaultSessionRetention())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.Counter("quota:requests:slack:u1:today"); got != 3 {
+		t.Fatalf("counter after reopen = %d, want 3", got)
+	}
+	if ok, _ := reopened.TakeToken("rate:user:slack:u1", 1, time.Minute, time.Now()); ok {
+		t.Fatal("empty bucket should survive a restart")
+	}
+}
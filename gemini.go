@@ -2,17 +2,28 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// geminiTimeout bounds one Gemini call within the request's deadline, so a
+// hung call leaves time for the fallback providers. Streams and tool loops
+// get twice as long.
+const geminiTimeout = 30 * time.Second
+
 // GeminiClient wraps the Google Generative AI client with our specific configuration
 type GeminiClient struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
+	client    *genai.Client
+	model     *genai.GenerativeModel
+	modelName string
+	timeout   time.Duration
 }
 
 // NewGeminiClient creates a new Gemini client
@@ -37,57 +48,193 @@ func NewGeminiClient(apiKey, modelName string) *GeminiClient {
 	model.SetTopP(0.95)
 	model.SetMaxOutputTokens(1000)
 
-	// Set system instruction for Kit's personality
-	model.SystemInstruction = &genai.Content{
-		Parts: []genai.Part{
-			genai.Text(`You are Kit, a helpful and friendly AI assistant integrated into Slack.
-
-Your personality:
-- Professional but approachable
-- Concise but thorough when needed
-- Helpful and solution-oriented
-- Use emojis sparingly but appropriately
-- Keep responses under 300 words for readability in Slack
-
-Always be ready to:
-- Answer questions clearly
-- Help with problem-solving
-- Provide explanations and guidance
-- Assist with work-related tasks
-- Maintain a positive, collaborative tone`),
-		},
-	}
-
 	return &GeminiClient{
-		client: client,
-		model:  model,
+		client:    client,
+		model:     model,
+		modelName: modelName,
+		timeout:   geminiTimeout,
 	}
 }
 
-// GenerateResponse generates a response using Gemini AI
-func (g *GeminiClient) GenerateResponse(message string) (string, error) {
+// GenerateResponse generates a response using Gemini AI.
+func (g *GeminiClient) GenerateResponse(ctx context.Context, prompt Prompt) (Reply, error) {
 	if g == nil || g.client == nil || g.model == nil {
-		return "", nil // Return empty to use fallback
+		return Reply{}, nil // Return empty to use fallback
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
 	defer cancel()
 
 	// Generate content
-	resp, err := g.model.GenerateContent(ctx, genai.Text(message))
+	resp, err := startGeminiChat(g.modelFor(prompt.System), prompt.History).SendMessage(ctx, geminiParts(prompt)...)
 	if err != nil {
 		log.Printf("❌ Gemini API error: %v", err)
-		return "", err
+		return Reply{}, err
 	}
 
 	// Extract text from response
-	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
+	reply := Reply{Usage: g.usage(resp)}
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil && len(resp.Candidates[0].Content.Parts) > 0 {
 		if textPart, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
-			return string(textPart), nil
+			reply.Text = string(textPart)
+		}
+	}
+	return reply, nil
+}
+
+// GenerateStream is like GenerateResponse but streams the reply, calling
+// onDelta with each fragment of text as it arrives.
+func (g *GeminiClient) GenerateStream(ctx context.Context, prompt Prompt, onDelta func(string)) (Reply, error) {
+	if g == nil || g.client == nil || g.model == nil {
+		return Reply{}, nil // Return empty to use fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*g.timeout)
+	defer cancel()
+
+	// Each chunk carries the usage so far; the last one has the totals.
+	var text strings.Builder
+	usage := Usage{Model: g.modelName}
+	iter := startGeminiChat(g.modelFor(prompt.System), prompt.History).SendMessageStream(ctx, geminiParts(prompt)...)
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("❌ Gemini API stream error: %v", err)
+			return Reply{}, err
+		}
+		if resp.UsageMetadata != nil {
+			usage = g.usage(resp)
+		}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if textPart, ok := part.(genai.Text); ok {
+				text.WriteString(string(textPart))
+				if onDelta != nil {
+					onDelta(string(textPart))
+				}
+			}
+		}
+	}
+
+	return Reply{Text: text.String(), Usage: usage}, nil
+}
+
+// GenerateWithTools runs the model → tool → model loop: function calls are
+// run through tools.Call and answered with function responses, until Gemini
+// answers in text or tools.MaxSteps rounds have been used.
+func (g *GeminiClient) GenerateWithTools(ctx context.Context, prompt Prompt, tools *ToolSet) (Reply, error) {
+	if g == nil || g.client == nil || g.model == nil {
+		return Reply{}, nil // Return empty to use fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*g.timeout)
+	defer cancel()
+
+	// Tools are configured on the per-request copy of the model so concurrent
+	// requests for different users don't see each other's tools.
+	model := g.modelFor(prompt.System)
+	declarations := make([]*genai.FunctionDeclaration, 0, len(tools.Tools))
+	for _, tool := range tools.Tools {
+		declarations = append(declarations, tool.geminiDeclaration())
+	}
+	model.Tools = []*genai.Tool{{FunctionDeclarations: declarations}}
+
+	chat := startGeminiChat(model, prompt.History)
+	usage := Usage{Model: g.modelName}
+	resp, err := chat.SendMessage(ctx, geminiParts(prompt)...)
+	for step := 1; err == nil; step++ {
+		usage.add(g.usage(resp))
+		if len(resp.Candidates) == 0 {
+			return Reply{Usage: usage}, nil
+		}
+		calls := resp.Candidates[0].FunctionCalls()
+		if len(calls) == 0 || step > tools.MaxSteps {
+			return Reply{Text: geminiText(resp.Candidates[0]), Usage: usage}, nil
+		}
+		if step >= tools.MaxSteps {
+			// Out of steps: make the model answer
+			model.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{Mode: genai.FunctionCallingNone}}
+		}
+
+		parts := make([]genai.Part, 0, len(calls))
+		for _, call := range calls {
+			args, _ := json.Marshal(call.Args)
+			parts = append(parts, genai.FunctionResponse{
+				Name:     call.Name,
+				Response: map[string]any{"result": tools.Call(ctx, call.Name, args)},
+			})
 		}
+		resp, err = chat.SendMessage(ctx, parts...)
 	}
 
-	return "", nil
+	log.Printf("❌ Gemini API error: %v", err)
+	return Reply{Usage: usage}, err
+}
+
+// usage reads the token counts Gemini attaches to a response.
+func (g *GeminiClient) usage(resp *genai.GenerateContentResponse) Usage {
+	usage := Usage{Model: g.modelName}
+	if resp.UsageMetadata != nil {
+		usage.PromptTokens = int(resp.UsageMetadata.PromptTokenCount)
+		usage.CompletionTokens = int(resp.UsageMetadata.CandidatesTokenCount)
+	}
+	return usage
+}
+
+// modelFor returns a per-request copy of the model with the persona's
+// system instruction, so concurrent requests in different channels don't
+// share one.
+func (g *GeminiClient) modelFor(system string) *genai.GenerativeModel {
+	model := *g.model
+	if system != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(system))
+	}
+	return &model
+}
+
+// geminiParts is the new user turn: the message followed by any images.
+func geminiParts(prompt Prompt) []genai.Part {
+	parts := []genai.Part{genai.Text(prompt.Message)}
+	for _, image := range prompt.Images {
+		parts = append(parts, genai.Blob{MIMEType: image.MIMEType, Data: image.Data})
+	}
+	return parts
+}
+
+// geminiText joins the text parts of a candidate.
+func geminiText(candidate *genai.Candidate) string {
+	if candidate.Content == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if textPart, ok := part.(genai.Text); ok {
+			text.WriteString(string(textPart))
+		}
+	}
+	return text.String()
+}
+
+// startGeminiChat opens a chat session seeded with the conversation so far.
+// Gemini calls the assistant role "model".
+func startGeminiChat(model *genai.GenerativeModel, history []ChatMessage) *genai.ChatSession {
+	chat := model.StartChat()
+	for _, turn := range history {
+		role := "user"
+		if turn.Role == "assistant" {
+			role = "model"
+		}
+		chat.History = append(chat.History, &genai.Content{
+			Role:  role,
+			Parts: []genai.Part{genai.Text(turn.Content)},
+		})
+	}
+	return chat
 }
 
 // Close closes the Gemini client
@@ -97,3 +244,19 @@ func (g *GeminiClient) Close() error {
 	}
 	return nil
 }
+
+// geminiEmbeddingModel is used for response cache similarity matching.
+const geminiEmbeddingModel = "text-embedding-004"
+
+// Embed returns the embedding of text, so GeminiClient can serve as the
+// response cache's Embedder.
+func (g *GeminiClient) Embed(ctx context.Context, text string) ([]float32, error) {
+	res, err := g.client.EmbeddingModel(geminiEmbeddingModel).EmbedContent(ctx, genai.Text(text))
+	if err != nil {
+		return nil, err
+	}
+	if res.Embedding == nil {
+		return nil, fmt.Errorf("gemini returned no embedding")
+	}
+	return res.Embedding.Values, nil
+}
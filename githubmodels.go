@@ -8,7 +8,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"time"
 )
 
 const githubModelsEndpoint = "https://models.github.ai/inference/chat/completions"
@@ -18,6 +17,7 @@ type GitHubModelsClient struct {
 	token      string
 	model      string
 	httpClient *http.Client
+	retry      retryPolicy
 }
 
 // NewGitHubModelsClient creates a new GitHub Models client
@@ -32,7 +32,8 @@ func NewGitHubModelsClient(token, model string) *GitHubModelsClient {
 	return &GitHubModelsClient{
 		token:      token,
 		model:      model,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		httpClient: &http.Client{}, // bounded by the request context
+		retry:      defaultRetryPolicy,
 	}
 }
 
@@ -54,74 +55,88 @@ type ghChatResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *oaUsage `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
-// GenerateResponse generates a response using the GitHub Models API
-func (g *GitHubModelsClient) GenerateResponse(message string) (string, error) {
-	if g == nil || g.token == "" {
-		return "", nil // Return empty to use fallback
+// ghMessages builds the chat messages array: system prompt, if any, prior
+// turns, then the new user message.
+func ghMessages(prompt Prompt) []ghChatMessage {
+	messages := make([]ghChatMessage, 0, len(prompt.History)+2)
+	if prompt.System != "" {
+		messages = append(messages, ghChatMessage{Role: "system", Content: prompt.System})
 	}
+	for _, turn := range prompt.History {
+		messages = append(messages, ghChatMessage{Role: turn.Role, Content: turn.Content})
+	}
+	return append(messages, ghChatMessage{Role: "user", Content: prompt.Message})
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	systemPrompt := `You are Kit, a helpful and friendly AI assistant integrated into Slack and Discord. Keep responses under 300 words and be professional but approachable.`
+// GenerateResponse generates a response using the GitHub Models API. Images
+// are not sent.
+func (g *GitHubModelsClient) GenerateResponse(ctx context.Context, prompt Prompt) (Reply, error) {
+	if g == nil || g.token == "" {
+		return Reply{}, nil // Return empty to use fallback
+	}
 
 	payload := ghChatRequest{
-		Model: g.model,
-		Messages: []ghChatMessage{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: message},
-		},
+		Model:       g.model,
+		Messages:    ghMessages(prompt.textOnly()),
 		MaxTokens:   1000,
 		Temperature: 0.7,
 	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return "", err
+		return Reply{}, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, githubModelsEndpoint, bytes.NewReader(body))
+	resp, err := g.retry.do(ctx, "github-models", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, githubModelsEndpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Authorization", "Bearer "+g.token)
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+		resp, err := g.httpClient.Do(req)
+		if err != nil {
+			log.Printf("❌ GitHub Models API error: %v", err)
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			log.Printf("❌ GitHub Models API returned status %d", resp.StatusCode) // #nosec G706 -- StatusCode is an int
+			return nil, newProviderError("github-models", resp, errBody)
+		}
+		return resp, nil
+	})
 	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/vnd.github+json")
-	req.Header.Set("Authorization", "Bearer "+g.token)
-	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-
-	resp, err := g.httpClient.Do(req)
-	if err != nil {
-		log.Printf("❌ GitHub Models API error: %v", err)
-		return "", err
+		return Reply{}, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("❌ GitHub Models API returned status %d", resp.StatusCode) // #nosec G706 -- StatusCode is an int
-		return "", fmt.Errorf("github models api status %d", resp.StatusCode)
+		return Reply{}, err
 	}
 
 	var parsed ghChatResponse
 	if err := json.Unmarshal(respBody, &parsed); err != nil {
-		return "", err
+		return Reply{}, err
 	}
 	if parsed.Error != nil {
-		return "", fmt.Errorf("github models api: %s", parsed.Error.Message)
+		return Reply{}, fmt.Errorf("github models api: %s", parsed.Error.Message)
 	}
 
-	if len(parsed.Choices) > 0 && parsed.Choices[0].Message.Content != "" {
-		return parsed.Choices[0].Message.Content, nil
+	reply := Reply{Usage: parsed.Usage.usage(g.model)}
+	if len(parsed.Choices) > 0 {
+		reply.Text = parsed.Choices[0].Message.Content
 	}
-
-	return "", nil
+	return reply, nil
 }
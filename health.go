@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Circuit breaker tuning. A provider opens after breakerThreshold consecutive
+// failures and stays open for a cooldown that doubles on every failed probe.
+// Auth failures, retired endpoints and rate limits open it immediately.
+const (
+	breakerThreshold   = 3
+	breakerCooldown    = 30 * time.Second
+	breakerMaxCooldown = 10 * time.Minute
+	authCooldown       = 30 * time.Minute
+	quotaCooldown      = time.Hour
+	retiredCooldown    = 24 * time.Hour
+	rateLimitCooldown  = time.Minute
+)
+
+// ProviderError carries the HTTP status of a failed provider call so the
+// health tracker can tell auth failures and rate limits from outages.
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *ProviderError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s api status %d: %s", e.Provider, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s api status %d", e.Provider, e.StatusCode)
+}
+
+// maxErrorMessage bounds the error text kept from a response body.
+const maxErrorMessage = 300
+
+// newProviderError builds a ProviderError from a non-200 HTTP response and
+// its body, which may hold an OpenAI-style {"error": {"message": ...}}.
+func newProviderError(provider string, resp *http.Response, body []byte) *ProviderError {
+	return &ProviderError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Message:    errorBodyMessage(body),
+	}
+}
+
+// errorBodyMessage extracts the error message from a response body, falling
+// back to the raw text.
+func errorBodyMessage(body []byte) string {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &parsed) == nil {
+		var detail struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"`
+		}
+		var text string
+		switch {
+		case json.Unmarshal(parsed.Error, &detail) == nil && detail.Message != "":
+			message = detail.Message
+			if detail.Type != "" {
+				message = detail.Type + ": " + message
+			}
+		case json.Unmarshal(parsed.Error, &text) == nil && text != "":
+			message = text
+		case parsed.Message != "":
+			message = parsed.Message
+		}
+	}
+	message = strings.Join(strings.Fields(message), " ")
+	if len(message) > maxErrorMessage {
+		message = strings.ToValidUTF8(message[:maxErrorMessage], "") + "…"
+	}
+	return message
+}
+
+// errorClass is the kind of failure a provider call ended with. It decides
+// whether the call is retried and how long the provider is set aside.
+type errorClass int
+
+const (
+	errorUnknown     errorClass = iota // network failures, timeouts, unparseable replies
+	errorRateLimited                   // 429: slow down, usually for seconds
+	errorQuota                         // out of credits or billing quota
+	errorAuth                          // bad or revoked key
+	errorServer                        // 5xx: the provider is having trouble
+	errorBadRequest                    // the provider rejected this request
+	errorRetired                       // 410: the model or endpoint is gone
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errorRateLimited:
+		return "rate limited"
+	case errorQuota:
+		return "quota exhausted"
+	case errorAuth:
+		return "auth failed"
+	case errorServer:
+		return "server error"
+	case errorBadRequest:
+		return "bad request"
+	case errorRetired:
+		return "retired"
+	default:
+		return "error"
+	}
+}
+
+// retryable reports whether the same call may succeed if sent again shortly.
+func (c errorClass) retryable() bool {
+	return c == errorRateLimited || c == errorServer
+}
+
+// quotaHints mark a 429 (or 403) that means the account is out of quota
+// rather than being asked to slow down.
+var quotaHints = []string{"insufficient_quota", "quota exceeded for this month", "billing", "credit", "exceeded your current quota"}
+
+// classifyError sorts a provider error into an errorClass.
+func classifyError(err error) errorClass {
+	if err == nil {
+		return errorUnknown
+	}
+	if errors.Is(err, errStreamTruncated) {
+		return errorServer // the connection dropped mid-reply
+	}
+	statusCode, _ := providerStatusCode(err)
+	text := strings.ToLower(err.Error())
+	switch {
+	case statusCode == http.StatusPaymentRequired:
+		return errorQuota
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusForbidden:
+		for _, hint := range quotaHints {
+			if strings.Contains(text, hint) {
+				return errorQuota
+			}
+		}
+		if statusCode == http.StatusForbidden {
+			return errorAuth
+		}
+		return errorRateLimited
+	case statusCode == http.StatusUnauthorized:
+		return errorAuth
+	case statusCode == http.StatusGone:
+		return errorRetired
+	case statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		return errorServer
+	case statusCode >= 400:
+		return errorBadRequest
+	}
+	return errorUnknown
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// providerStatusCode extracts an HTTP status from the error types returned by
+// our own HTTP clients and the Anthropic and Google SDKs. Returns 0 when the
+// error carries no status (timeouts, DNS failures, etc).
+func providerStatusCode(err error) (int, time.Duration) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.StatusCode, providerErr.RetryAfter
+	}
+	var claudeErr *anthropic.Error
+	if errors.As(err, &claudeErr) {
+		var retryAfter time.Duration
+		if claudeErr.Response != nil {
+			retryAfter = parseRetryAfter(claudeErr.Response.Header.Get("Retry-After"))
+		}
+		return claudeErr.StatusCode, retryAfter
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code, parseRetryAfter(googleErr.Header.Get("Retry-After"))
+	}
+	var httpCoder interface{ HTTPCode() int }
+	if errors.As(err, &httpCoder) && httpCoder.HTTPCode() > 0 {
+		return httpCoder.HTTPCode(), 0
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unauthenticated:
+			return http.StatusUnauthorized, 0
+		case codes.PermissionDenied:
+			return http.StatusForbidden, 0
+		case codes.ResourceExhausted:
+			return http.StatusTooManyRequests, 0
+		}
+	}
+	return 0, 0
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// providerHealth is the breaker state for a single provider.
+type providerHealth struct {
+	state       breakerState
+	failures    int // consecutive failures
+	cooldown    time.Duration
+	retryAt     time.Time
+	probing     bool // a half-open probe request is in flight
+	lastError   string
+	lastSuccess time.Time
+}
+
+// ProviderStatus is a read-only snapshot of a provider's health, used by
+// the status commands.
+type ProviderStatus struct {
+	Name                string
+	State               string
+	ConsecutiveFailures int
+	LastError           string
+	RetryAt             time.Time
+	LastSuccess         time.Time
+}
+
+// healthTracker keeps a circuit breaker per provider name.
+type healthTracker struct {
+	mu        sync.Mutex
+	providers map[string]*providerHealth
+	now       func() time.Time
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{providers: make(map[string]*providerHealth), now: time.Now}
+}
+
+func (h *healthTracker) get(name string) *providerHealth {
+	health, ok := h.providers[name]
+	if !ok {
+		health = &providerHealth{}
+		h.providers[name] = health
+	}
+	return health
+}
+
+// allow reports whether a request may be sent to the provider. Once an open
+// breaker's cooldown has passed, exactly one probe request is let through.
+func (h *healthTracker) allow(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := h.get(name)
+	switch health.state {
+	case breakerOpen:
+		if h.now().Before(health.retryAt) {
+			return false
+		}
+		health.state = breakerHalfOpen
+		health.probing = true
+		return true
+	case breakerHalfOpen:
+		if health.probing {
+			return false
+		}
+		health.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a request.
+func (h *healthTracker) record(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := h.get(name)
+	health.probing = false
+	if err == nil {
+		health.state = breakerClosed
+		health.failures = 0
+		health.cooldown = 0
+		health.lastSuccess = h.now()
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return // the caller gave up; says nothing about the provider
+	}
+
+	health.lastError = err.Error()
+	class := classifyError(err)
+	if class == errorBadRequest {
+		// The request was refused, not the provider unwell: a prompt that is
+		// too long or an unsupported attachment shouldn't trip the breaker
+		return
+	}
+	health.failures++
+
+	_, retryAfter := providerStatusCode(err)
+	switch class {
+	case errorRetired:
+		h.open(health, retiredCooldown)
+	case errorAuth:
+		h.open(health, authCooldown)
+	case errorQuota:
+		h.open(health, max(retryAfter, quotaCooldown))
+	case errorRateLimited:
+		if retryAfter <= 0 {
+			retryAfter = rateLimitCooldown
+		}
+		h.open(health, retryAfter)
+	default:
+		if health.state == breakerHalfOpen {
+			// The probe failed: back off twice as long as last time
+			h.open(health, min(2*max(health.cooldown, breakerCooldown), breakerMaxCooldown))
+		} else if health.failures >= breakerThreshold {
+			h.open(health, breakerCooldown)
+		}
+	}
+}
+
+func (h *healthTracker) open(health *providerHealth, cooldown time.Duration) {
+	health.state = breakerOpen
+	health.cooldown = cooldown
+	health.retryAt = h.now().Add(cooldown)
+}
+
+// snapshot returns the status of the named providers in the given order.
+func (h *healthTracker) snapshot(names []string) []ProviderStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	statuses := make([]ProviderStatus, 0, len(names))
+	for _, name := range names {
+		health := h.get(name)
+		statuses = append(statuses, ProviderStatus{
+			Name:                name,
+			State:               health.state.String(),
+			ConsecutiveFailures: health.failures,
+			LastError:           health.lastError,
+			RetryAt:             health.retryAt,
+			LastSuccess:         health.lastSuccess,
+		})
+	}
+	return statuses
+}
+
+// formatProviderStatus renders provider health for the !status and
+// /kit status commands, one bullet per provider.
+func formatProviderStatus(statuses []ProviderStatus) string {
+	if len(statuses) == 0 {
+		return "• AI Engine: ❌ Offline (basic responses only)"
+	}
+
+	var b strings.Builder
+	b.WriteString("• AI Providers:")
+	for _, st := range statuses {
+		switch st.State {
+		case "open":
+			wait := time.Until(st.RetryAt).Round(time.Second)
+			fmt.Fprintf(&b, "\n  ◦ %s: 🔴 unavailable (retry in %s)", st.Name, max(wait, 0))
+		case "half-open":
+			fmt.Fprintf(&b, "\n  ◦ %s: 🟡 recovering", st.Name)
+		default:
+			if st.ConsecutiveFailures > 0 {
+				fmt.Fprintf(&b, "\n  ◦ %s: 🟡 online (%d recent failures)", st.Name, st.ConsecutiveFailures)
+			} else {
+				fmt.Fprintf(&b, "\n  ◦ %s: ✅ online", st.Name)
+			}
+		}
+	}
+	return b.String()
+}
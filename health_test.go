@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthTrackerOpensAfterThreshold(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	h := newHealthTracker()
+	h.now = func() time.Time { return now }
+
+	for i := 0; i < breakerThreshold; i++ {
+		if !h.allow("groq") {
+			t.Fatalf("breaker opened early after %d failures", i)
+		}
+		h.record("groq", errors.New("timeout"))
+	}
+	if h.allow("groq") {
+		t.Fatal("breaker should be open after threshold failures")
+	}
+
+	// After the cooldown exactly one probe goes through
+	now = now.Add(breakerCooldown)
+	if !h.allow("groq") {
+		t.Fatal("probe should be allowed after cooldown")
+	}
+	if h.allow("groq") {
+		t.Fatal("only one probe may be in flight")
+	}
+
+	// A failed probe reopens with a longer cooldown
+	h.record("groq", errors.New("timeout"))
+	now = now.Add(breakerCooldown)
+	if h.allow("groq") {
+		t.Fatal("cooldown should double after a failed probe")
+	}
+	now = now.Add(breakerCooldown)
+	if !h.allow("groq") {
+		t.Fatal("probe should be allowed after doubled cooldown")
+	}
+	h.record("groq", nil)
+	if st := h.snapshot([]string{"groq"})[0]; st.State != "closed" || st.ConsecutiveFailures != 0 {
+		t.Fatalf("successful probe should close the breaker, got %+v", st)
+	}
+}
+
+func TestHealthTrackerStatusCodes(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	h := newHealthTracker()
+	h.now = func() time.Time { return now }
+
+	h.record("github-models", &ProviderError{Provider: "github-models", StatusCode: http.StatusGone})
+	h.record("groq", &ProviderError{Provider: "groq", StatusCode: http.StatusTooManyRequests, RetryAfter: 20 * time.Second})
+
+	if h.allow("github-models") {
+		t.Fatal("410 should open the breaker immediately")
+	}
+	now = now.Add(20 * time.Second)
+	if !h.allow("groq") {
+		t.Fatal("429 breaker should honor Retry-After")
+	}
+	if h.allow("github-models") {
+		t.Fatal("retired provider should stay open")
+	}
+}
+
+func TestClassifyProviderErrors(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"7"}}
+	quota := newProviderError("openai", &http.Response{StatusCode: http.StatusTooManyRequests, Header: header},
+		[]byte(`{"error":{"message":"You exceeded your current quota","type":"insufficient_quota"}}`))
+	if quota.RetryAfter != 7*time.Second || quota.Message != "insufficient_quota: You exceeded your current quota" {
+		t.Fatalf("unexpected error %+v", quota)
+	}
+	for err, want := range map[error]errorClass{
+		quota: errorQuota,
+		&ProviderError{StatusCode: http.StatusTooManyRequests}:       errorRateLimited,
+		&ProviderError{StatusCode: http.StatusPaymentRequired}:       errorQuota,
+		&ProviderError{StatusCode: http.StatusUnauthorized}:          errorAuth,
+		&ProviderError{StatusCode: http.StatusBadGateway}:            errorServer,
+		&ProviderError{StatusCode: http.StatusRequestEntityTooLarge}: errorBadRequest,
+		errors.New("connection reset"):                               errorUnknown,
+	} {
+		if got := classifyError(err); got != want {
+			t.Errorf("%v classified as %s, want %s", err, got, want)
+		}
+	}
+
+	h := newHealthTracker()
+	for i := 0; i < breakerThreshold; i++ {
+		h.record("groq", &ProviderError{Provider: "groq", StatusCode: http.StatusBadRequest})
+	}
+	if !h.allow("groq") {
+		t.Fatal("rejected requests should not open the breaker")
+	}
+	h.record("openai", quota)
+	if st := h.snapshot([]string{"openai"})[0]; st.State != "open" || time.Until(st.RetryAt) < quotaCooldown-time.Minute {
+		t.Fatalf("quota should open the breaker for a long time, got %+v", st)
+	}
+}
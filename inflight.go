@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// errMessageDeleted is the cancellation cause when the user deletes the
+// message a reply is being generated for.
+var errMessageDeleted = errors.New("triggering message deleted")
+
+// inflightRequests hands out the contexts AI replies are generated under.
+// They all derive from the process context, which is canceled on shutdown,
+// and are tracked by the message that triggered them so deleting that
+// message cancels its reply.
+type inflightRequests struct {
+	mu      sync.Mutex
+	parent  context.Context
+	cancels map[string]context.CancelCauseFunc
+}
+
+func newInflightRequests(parent context.Context) *inflightRequests {
+	return &inflightRequests{parent: parent, cancels: make(map[string]context.CancelCauseFunc)}
+}
+
+// globalInflight tracks in-flight replies; main replaces it with one tied
+// to the shutdown signal.
+var globalInflight = newInflightRequests(context.Background())
+
+// inflightKey identifies the message that triggered a reply.
+func inflightKey(platform, channelID, messageID string) string {
+	return platform + ":" + channelID + ":" + messageID
+}
+
+// start returns the context for a reply to the message identified by key,
+// and a function to call when the reply is done. An empty key gives a
+// context that is only canceled on shutdown.
+func (r *inflightRequests) start(key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(r.parent)
+	if key == "" {
+		return ctx, func() { cancel(nil) }
+	}
+	r.mu.Lock()
+	r.cancels[key] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels, key)
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel stops the reply to the message identified by key, if one is in
+// flight, and reports whether it was.
+func (r *inflightRequests) cancel(key string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[key]
+	r.mu.Unlock()
+	if ok {
+		cancel(errMessageDeleted)
+	}
+	return ok
+}
+
+// messageDeleted reports whether ctx was canceled because the triggering
+// message was deleted, in which case the reply should be removed rather
+// than finished.
+func messageDeleted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errMessageDeleted)
+}
+
+// shutdownNotice replaces a reply that was cut short by shutdown.
+const shutdownNotice = "🛑 Kit is restarting and couldn't finish this reply. Please ask again in a moment."
+
+// settledReply is what to send for a reply generated under ctx: the reply
+// itself, the shutdown notice if it was cut short, or "" if the user
+// deleted their message and nothing should be sent.
+func settledReply(ctx context.Context, reply string) string {
+	switch {
+	case messageDeleted(ctx):
+		return ""
+	case errors.Is(ctx.Err(), context.Canceled) && strings.TrimSpace(reply) == "":
+		return shutdownNotice
+	default:
+		return reply
+	}
+}
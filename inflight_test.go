@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInflightRequestsCancelOnDelete(t *testing.T) {
+	parent, shutdown := context.WithCancel(context.Background())
+	inflight := newInflightRequests(parent)
+	key := inflightKey("discord", "c1", "m1")
+
+	ctx, done := inflight.start(key)
+	if !inflight.cancel(key) || !messageDeleted(ctx) {
+		t.Fatal("deleting the message should cancel its reply")
+	}
+	if settledReply(ctx, "partial") != "" {
+		t.Fatal("nothing should be sent for a deleted message")
+	}
+	done()
+	if inflight.cancel(key) {
+		t.Fatal("finished replies should no longer be tracked")
+	}
+
+	ctx, done = inflight.start("")
+	defer done()
+	shutdown()
+	if messageDeleted(ctx) || settledReply(ctx, "") != shutdownNotice || settledReply(ctx, "done") != "done" {
+		t.Fatal("shutdown should only replace missing replies with the notice")
+	}
+}
+
+func TestStreamerSettleRemovesCanceledReply(t *testing.T) {
+	posted := map[string]string{}
+	streamer := newMessageStreamer(
+		func(text string) (string, error) { posted["1"] = text; return "1", nil },
+		func(id, text string) error { posted[id] = text; return nil },
+		100, time.Hour,
+	)
+	streamer.Start()
+
+	inflight := newInflightRequests(context.Background())
+	ctx, done := inflight.start("k")
+	defer done()
+	inflight.cancel("k")
+	streamer.Settle(ctx, "", func(id string) error { delete(posted, id); return nil })
+	if len(posted) != 0 {
+		t.Fatalf("placeholder not removed: %v", posted)
+	}
+}
@@ -0,0 +1,662 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// kbChunkChars is the target size of an indexed passage. Sections longer
+	// than this are split at paragraph breaks.
+	kbChunkChars = 1200
+	// defaultKBTopK is how many passages are added to a prompt when KB_TOP_K
+	// is not set.
+	defaultKBTopK = 3
+	// defaultKBMinScore is the lowest BM25 score worth showing the model. In
+	// a few dozen passages a word found in half of them scores under 1 and a
+	// rare one around 3.
+	defaultKBMinScore = 1.0
+	// defaultKBMinSimilarity is the lowest cosine similarity used when the
+	// index has embeddings.
+	defaultKBMinSimilarity = 0.5
+	// kbReindexTimeout bounds a rebuild, including embedding every new passage.
+	kbReindexTimeout = 10 * time.Minute
+	// BM25 parameters; the usual defaults suit short documentation passages.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// kbExtensions are the files indexed from the knowledge base directories.
+var kbExtensions = map[string]bool{".md": true, ".markdown": true, ".txt": true}
+
+// kbStopwords are dropped from passages and queries before BM25 scoring.
+var kbStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "do": true, "does": true, "for": true,
+	"from": true, "how": true, "i": true, "if": true, "in": true, "is": true,
+	"it": true, "me": true, "my": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "we": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "why": true,
+	"will": true, "with": true, "you": true, "your": true,
+}
+
+// kbChunk is one indexed passage: a markdown section, or part of one.
+type kbChunk struct {
+	Source  string    `json:"source"` // e.g. "docs/HOW_TO_USE.md"
+	Heading string    `json:"heading,omitempty"`
+	Text    string    `json:"text"`
+	Vector  []float32 `json:"vector,omitempty"`
+
+	terms  map[string]int // term frequencies for BM25
+	length int            // number of terms
+}
+
+// key identifies a passage's content for reusing its embedding.
+func (c *kbChunk) key() string {
+	return c.Source + "\x00" + c.Heading + "\x00" + c.Text
+}
+
+// kbIndexFile is the on-disk index. Embedding is the embedder the vectors
+// came from; a different one means they can't be reused. Files lists every
+// source file read, so added and deleted files are noticed.
+type kbIndexFile struct {
+	Built     time.Time  `json:"built"`
+	Embedding string     `json:"embedding,omitempty"`
+	Files     []string   `json:"files,omitempty"`
+	Chunks    []*kbChunk `json:"chunks"`
+}
+
+// sources returns the files the index was built from. Indexes saved
+// without the list fall back to the files that have passages.
+func (f *kbIndexFile) sources() []string {
+	if f.Files != nil {
+		return f.Files
+	}
+	var files []string
+	for _, chunk := range f.Chunks {
+		if !slices.Contains(files, chunk.Source) {
+			files = append(files, chunk.Source)
+		}
+	}
+	return files
+}
+
+// kbPassage is a search result.
+type kbPassage struct {
+	Source  string
+	Heading string
+	Text    string
+	Score   float64
+}
+
+// KBStats describes the current index.
+type KBStats struct {
+	Files     int
+	Chunks    int
+	Embedding string // empty when searching with BM25
+	Built     time.Time
+}
+
+// KnowledgeBase indexes markdown and text files from local directories and
+// finds the passages most relevant to a question. With an Embedder it ranks
+// by embedding similarity, otherwise (or when embedding a query fails) by
+// BM25. The index is kept on disk so restarts don't re-embed everything.
+type KnowledgeBase struct {
+	mu            sync.RWMutex
+	dirs          []string
+	indexPath     string // empty keeps the index in memory only
+	embedder      Embedder
+	embedding     string // name of the embedder, recorded in the index
+	topK          int
+	minScore      float64
+	minSimilarity float64
+	chunks        []*kbChunk
+	docFreq       map[string]int
+	avgLength     float64
+	built         time.Time
+	reindexing    sync.Mutex // serializes rebuilds
+}
+
+// NewKnowledgeBase creates an empty knowledge base over dirs; call Load to
+// read or build the index.
+func NewKnowledgeBase(dirs []string, indexPath string) *KnowledgeBase {
+	return &KnowledgeBase{
+		dirs:          dirs,
+		indexPath:     indexPath,
+		topK:          defaultKBTopK,
+		minScore:      defaultKBMinScore,
+		minSimilarity: defaultKBMinSimilarity,
+		docFreq:       make(map[string]int),
+	}
+}
+
+// SetEmbedder ranks passages by embedding similarity. name identifies the
+// embedding model so a changed model triggers re-embedding.
+func (k *KnowledgeBase) SetEmbedder(embedder Embedder, name string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.embedder = embedder
+	k.embedding = name
+}
+
+// Load reads the on-disk index, or rebuilds it when it is missing, was
+// built with another embedder or from other files, or is older than any of
+// the source files.
+func (k *KnowledgeBase) Load(ctx context.Context) error {
+	if k.indexPath != "" {
+		index, err := readKBIndex(k.indexPath)
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			log.Printf("⚠️  Knowledge base index unreadable, rebuilding: %v", err)
+		case err == nil && index.Embedding == k.embeddingName() && !k.changedSince(index.Built, index.sources()):
+			k.install(index.Chunks, index.Embedding, index.Built)
+			return nil
+		}
+	}
+	_, err := k.Reindex(ctx)
+	return err
+}
+
+func readKBIndex(path string) (*kbIndexFile, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return nil, err
+	}
+	var index kbIndexFile
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &index, nil
+}
+
+func (k *KnowledgeBase) embeddingName() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.embedding
+}
+
+// changedSince reports whether the source files are not exactly files, or
+// any of them was modified after t.
+func (k *KnowledgeBase) changedSince(t time.Time, files []string) bool {
+	unseen := make(map[string]bool, len(files))
+	for _, file := range files {
+		unseen[file] = true
+	}
+	changed := false
+	_ = k.walk(func(path string, info fs.FileInfo) error {
+		source := filepath.ToSlash(path)
+		if !unseen[source] || info.ModTime().After(t) {
+			changed = true
+			return filepath.SkipAll
+		}
+		delete(unseen, source)
+		return nil
+	})
+	return changed || len(unseen) > 0
+}
+
+// walk calls fn for every indexable file in the directories.
+func (k *KnowledgeBase) walk(fn func(path string, info fs.FileInfo) error) error {
+	for _, dir := range k.dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !kbExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			return fn(path, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reindex reads every file again, embeds passages that are new or changed
+// and saves the index. Searches keep using the old index until it finishes.
+func (k *KnowledgeBase) Reindex(ctx context.Context) (KBStats, error) {
+	k.reindexing.Lock()
+	defer k.reindexing.Unlock()
+
+	k.mu.RLock()
+	embedder, embedding := k.embedder, k.embedding
+	previous := make(map[string][]float32, len(k.chunks))
+	for _, chunk := range k.chunks {
+		if chunk.Vector != nil {
+			previous[chunk.key()] = chunk.Vector
+		}
+	}
+	k.mu.RUnlock()
+
+	built := time.Now()
+	var chunks []*kbChunk
+	files := []string{}
+	err := k.walk(func(path string, info fs.FileInfo) error {
+		files = append(files, filepath.ToSlash(path))
+		data, err := os.ReadFile(path) // #nosec G304 -- operator-configured directory
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(data) {
+			log.Printf("⚠️  Knowledge base skipped %s: not UTF-8 text", path)
+			return nil
+		}
+		chunks = append(chunks, chunkDocument(filepath.ToSlash(path), string(data))...)
+		return nil
+	})
+	if err != nil {
+		return KBStats{}, err
+	}
+
+	if embedder != nil {
+		embedded := 0
+		for _, chunk := range chunks {
+			if vector, ok := previous[chunk.key()]; ok {
+				chunk.Vector = vector
+				continue
+			}
+			embedCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+			vector, err := embedder.Embed(embedCtx, chunk.Heading+"\n"+chunk.Text)
+			cancel()
+			if err != nil {
+				// A partly embedded index is still searchable with BM25
+				log.Printf("⚠️  Knowledge base embedding failed, using BM25: %v", err)
+				embedding = ""
+				break
+			}
+			chunk.Vector = vector
+			embedded++
+		}
+		if embedding == "" {
+			for _, chunk := range chunks {
+				chunk.Vector = nil
+			}
+		} else {
+			log.Printf("📚 Embedded %d new knowledge base passages", embedded)
+		}
+	}
+
+	k.install(chunks, embedding, built)
+	if k.indexPath != "" {
+		if err := writeKBIndex(k.indexPath, &kbIndexFile{Built: built, Embedding: embedding, Files: files, Chunks: chunks}); err != nil {
+			return k.Stats(), fmt.Errorf("save index: %w", err)
+		}
+	}
+	stats := k.Stats()
+	log.Printf("📚 Knowledge base indexed %d passages from %d files", stats.Chunks, stats.Files)
+	return stats, nil
+}
+
+// writeKBIndex saves the index atomically, creating its directory if needed.
+func writeKBIndex(path string, index *kbIndexFile) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// install replaces the searchable index and recomputes the BM25 statistics.
+// embedding is the embedder that produced the vectors, if any.
+func (k *KnowledgeBase) install(chunks []*kbChunk, embedding string, built time.Time) {
+	docFreq := make(map[string]int)
+	total := 0
+	for _, chunk := range chunks {
+		chunk.terms = make(map[string]int)
+		terms := kbTerms(chunk.Heading + " " + chunk.Text)
+		for _, term := range terms {
+			chunk.terms[term]++
+		}
+		chunk.length = len(terms)
+		total += chunk.length
+		for term := range chunk.terms {
+			docFreq[term]++
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.chunks = chunks
+	k.docFreq = docFreq
+	k.avgLength = 0
+	if len(chunks) > 0 {
+		k.avgLength = float64(total) / float64(len(chunks))
+	}
+	k.built = built
+	if embedding == "" || embedding != k.embedding {
+		// Vectors from another model can't be compared with the queries
+		for _, chunk := range chunks {
+			chunk.Vector = nil
+		}
+	}
+}
+
+// chunkDocument splits a file into passages at markdown headings, and long
+// sections further at paragraph breaks.
+func chunkDocument(source, text string) []*kbChunk {
+	var chunks []*kbChunk
+	heading := ""
+	var section strings.Builder
+	inFence := false
+
+	flush := func() {
+		for _, part := range splitSection(section.String()) {
+			chunks = append(chunks, &kbChunk{Source: source, Heading: heading, Text: part})
+		}
+		section.Reset()
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+		if title, ok := strings.CutPrefix(strings.TrimLeft(trimmed, "#"), " "); !inFence && ok && title != "" {
+			flush()
+			heading = strings.TrimSpace(title)
+			continue
+		}
+		section.WriteString(line + "\n")
+	}
+	flush()
+	return chunks
+}
+
+// splitSection packs a section's paragraphs into passages of about
+// kbChunkChars, cutting paragraphs that are longer on their own.
+func splitSection(section string) []string {
+	var parts []string
+	var current strings.Builder
+	add := func() {
+		if text := strings.TrimSpace(current.String()); text != "" {
+			parts = append(parts, text)
+		}
+		current.Reset()
+	}
+	for _, paragraph := range strings.Split(section, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if current.Len() > 0 && current.Len()+len(paragraph) > kbChunkChars {
+			add()
+		}
+		for utf8.RuneCountInString(paragraph) > kbChunkChars {
+			runes := []rune(paragraph)
+			current.WriteString(string(runes[:kbChunkChars]))
+			add()
+			paragraph = string(runes[kbChunkChars:])
+		}
+		current.WriteString(paragraph + "\n\n")
+	}
+	add()
+	return parts
+}
+
+// kbTerms splits text into lowercase search terms without stopwords, with
+// plural "s" removed so "commands" matches "command".
+func kbTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.Fields(normalizePrompt(text)) {
+		if kbStopwords[word] {
+			continue
+		}
+		if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+			word = word[:len(word)-1]
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// Search returns up to limit passages relevant to query, best first.
+func (k *KnowledgeBase) Search(ctx context.Context, query string, limit int) []kbPassage {
+	if k == nil || strings.TrimSpace(query) == "" {
+		return nil
+	}
+	k.mu.RLock()
+	embedder, embedding := k.embedder, k.embedding
+	k.mu.RUnlock()
+
+	if embedder != nil && embedding != "" && k.hasVectors() {
+		embedCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+		vector, err := embedder.Embed(embedCtx, query)
+		cancel()
+		if err == nil {
+			return k.rank(limit, k.minSimilarity, func(chunk *kbChunk) float64 {
+				return cosineSimilarity(vector, chunk.Vector)
+			})
+		}
+		log.Printf("⚠️  Knowledge base query embedding failed, using BM25: %v", err)
+	}
+	return k.searchBM25(query, limit)
+}
+
+func (k *KnowledgeBase) hasVectors() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.chunks) > 0 && k.chunks[0].Vector != nil
+}
+
+func (k *KnowledgeBase) searchBM25(query string, limit int) []kbPassage {
+	terms := kbTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	k.mu.RLock()
+	n := float64(len(k.chunks))
+	idf := make(map[string]float64, len(terms))
+	for _, term := range terms {
+		df := float64(k.docFreq[term])
+		idf[term] = math.Log(1 + (n-df+0.5)/(df+0.5))
+	}
+	avgLength := k.avgLength
+	k.mu.RUnlock()
+
+	return k.rank(limit, k.minScore, func(chunk *kbChunk) float64 {
+		score := 0.0
+		for _, term := range terms {
+			tf := float64(chunk.terms[term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(chunk.length)/avgLength
+			score += idf[term] * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		return score
+	})
+}
+
+// rank scores every passage and returns the best limit at or above min.
+func (k *KnowledgeBase) rank(limit int, min float64, score func(*kbChunk) float64) []kbPassage {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var passages []kbPassage
+	for _, chunk := range k.chunks {
+		if s := score(chunk); s >= min && s > 0 {
+			passages = append(passages, kbPassage{Source: chunk.Source, Heading: chunk.Heading, Text: chunk.Text, Score: s})
+		}
+	}
+	sort.SliceStable(passages, func(i, j int) bool { return passages[i].Score > passages[j].Score })
+	if len(passages) > limit {
+		passages = passages[:limit]
+	}
+	return passages
+}
+
+// promptContext returns the passages relevant to a question formatted for
+// the system prompt, or "" when none are.
+func (k *KnowledgeBase) promptContext(ctx context.Context, question string) string {
+	if k == nil {
+		return ""
+	}
+	passages := k.Search(ctx, question, k.topK)
+	if len(passages) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nExcerpts from our documentation that may help answer the next message are below. " +
+		"Use them when they are relevant and cite the source in brackets, e.g. [docs/HOW_TO_USE.md]. " +
+		"If they don't answer the question, say so rather than guessing.\n")
+	for i, passage := range passages {
+		fmt.Fprintf(&b, "\n[%d] %s", i+1, passage.citation())
+		b.WriteString("\n" + passage.Text + "\n")
+	}
+	return b.String()
+}
+
+func (p kbPassage) citation() string {
+	if p.Heading == "" {
+		return p.Source
+	}
+	return p.Source + " - " + p.Heading
+}
+
+// Stats describes the current index.
+func (k *KnowledgeBase) Stats() KBStats {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	files := make(map[string]bool)
+	for _, chunk := range k.chunks {
+		files[chunk.Source] = true
+	}
+	stats := KBStats{Files: len(files), Chunks: len(k.chunks), Built: k.built}
+	if len(k.chunks) > 0 && k.chunks[0].Vector != nil {
+		stats.Embedding = k.embedding
+	}
+	return stats
+}
+
+// knowledgeBaseFromEnv configures the knowledge base from KB_DIRS,
+// KB_INDEX_FILE, KB_TOP_K, KB_MIN_SCORE and KB_MIN_SIMILARITY. Passages are
+// embedded with Gemini when it is configured, or with the OpenAI-compatible
+// provider when KB_EMBEDDING_MODEL is set; KB_EMBEDDING_MODEL=none keeps
+// BM25. It returns nil when KB_DIRS is not set.
+func knowledgeBaseFromEnv(gemini *GeminiClient, compat *OpenAICompatClient) *KnowledgeBase {
+	dirs := splitList(os.Getenv("KB_DIRS"))
+	if len(dirs) == 0 {
+		return nil
+	}
+	kb := NewKnowledgeBase(dirs, os.Getenv("KB_INDEX_FILE"))
+	if n, err := strconv.Atoi(os.Getenv("KB_TOP_K")); err == nil && n > 0 {
+		kb.topK = n
+	}
+	if score, err := strconv.ParseFloat(os.Getenv("KB_MIN_SCORE"), 64); err == nil && score >= 0 {
+		kb.minScore = score
+	}
+	if similarity, err := strconv.ParseFloat(os.Getenv("KB_MIN_SIMILARITY"), 64); err == nil && similarity >= 0 {
+		kb.minSimilarity = similarity
+	}
+
+	embeddingModel := os.Getenv("KB_EMBEDDING_MODEL")
+	switch {
+	case embeddingModel == "none":
+	case gemini != nil:
+		kb.SetEmbedder(gemini, "gemini/"+geminiEmbeddingModel)
+	case compat != nil && embeddingModel != "":
+		kb.SetEmbedder(compat.Embedder(embeddingModel), compat.name+"/"+embeddingModel)
+	}
+	return kb
+}
+
+// handleKnowledgeCommand implements !kb and /kit kb: show the index, search
+// it, or let admins rebuild it after the docs change. A rebuild runs in the
+// background and its outcome is passed to notify.
+func handleKnowledgeCommand(platform, args, userID string, notify func(string)) string {
+	if globalAIService == nil || globalAIService.knowledge == nil {
+		return "📚 The knowledge base is not enabled (set KB_DIRS)."
+	}
+	kb := globalAIService.knowledge
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return formatKBStats(kb.Stats()) + "\n\nTry `kb search <question>`; admins can `kb reindex`."
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "search":
+		query := strings.Join(fields[1:], " ")
+		if query == "" {
+			return "❓ **Usage:** `kb search <question>`"
+		}
+		// The query is masked like a chat message before it reaches the
+		// embedder; the reply shows it as typed.
+		masked := globalAIService.redactor.begin(ChatRequest{Platform: platform, UserID: userID}).redact(query)
+		passages := kb.Search(context.Background(), masked, kb.topK)
+		if len(passages) == 0 {
+			return fmt.Sprintf("📚 Nothing in the knowledge base matches \"%s\".", query)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "📚 **Top matches for \"%s\":**\n", query)
+		for i, passage := range passages {
+			fmt.Fprintf(&b, "\n**%d. %s** (score %.2f)\n%s\n", i+1, passage.citation(), passage.Score, truncateRunes(passage.Text, 200))
+		}
+		return b.String()
+	case "reindex":
+		if !isAdmin(userID) {
+			return "🔒 Only Kit admins can rebuild the knowledge base."
+		}
+		go func() {
+			notify(reindexKnowledgeBase(globalAIService, userID))
+		}()
+		return "⏳ Reindexing the knowledge base; I'll post the result here when it's done."
+	default:
+		return "❓ **Usage:** `kb`, `kb search <question>` or `kb reindex`"
+	}
+}
+
+// reindexKnowledgeBase rebuilds svc's knowledge base for an admin and
+// describes the outcome.
+func reindexKnowledgeBase(svc *AIService, userID string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), kbReindexTimeout)
+	defer cancel()
+	stats, err := svc.knowledge.Reindex(ctx)
+	if err != nil {
+		log.Printf("❌ Knowledge base reindex failed: %v", err)
+		return "❌ Reindexing failed; see the logs. The previous index is still in use."
+	}
+	// Cached replies may quote the old docs
+	removed := 0
+	if svc.cache != nil {
+		removed = svc.cache.Purge("")
+	}
+	log.Printf("📚 User %s reindexed the knowledge base (%d cached replies cleared)", userID, removed)
+	return "✅ Reindexed.\n" + formatKBStats(stats)
+}
+
+func formatKBStats(stats KBStats) string {
+	mode := "keyword search (BM25)"
+	if stats.Embedding != "" {
+		mode = "embeddings (" + stats.Embedding + ")"
+	}
+	built := "never"
+	if !stats.Built.IsZero() {
+		built = stats.Built.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("📚 **Knowledge Base**\n• %d passages from %d files\n• Search: %s\n• Indexed: %s",
+		stats.Chunks, stats.Files, mode, built)
+}
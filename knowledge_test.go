@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const kbTestDoc = `# Using Kit
+
+Kit answers questions in Slack and Discord.
+
+## Slash Commands
+
+Type /kit status to check the bot. Slash commands work in any channel.
+
+## Camp Packing
+
+Bring a tent, a sleeping bag and a water bottle.
+
+` + "```sh\n# not a heading\n```\n"
+
+func writeKBDocs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "HOW_TO_USE.md"), []byte(kbTestDoc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "image.png"), []byte("\x89PNG"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestChunkDocumentSplitsAtHeadings(t *testing.T) {
+	chunks := chunkDocument("docs/HOW_TO_USE.md", kbTestDoc)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[1].Heading != "Slash Commands" || !strings.Contains(chunks[2].Text, "# not a heading") {
+		t.Fatalf("unexpected chunks %+v %+v", chunks[1], chunks[2])
+	}
+
+	long := strings.Repeat("word ", kbChunkChars/2) + "\n\n" + strings.Repeat("more ", kbChunkChars/2)
+	if parts := splitSection(long); len(parts) < 3 {
+		t.Fatalf("long section not split: %d parts", len(parts))
+	}
+}
+
+func TestKnowledgeBaseBM25Search(t *testing.T) {
+	kb := NewKnowledgeBase([]string{writeKBDocs(t)}, "")
+	kb.minScore = 0.5 // three passages give every word a low IDF
+	if err := kb.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := kb.Stats(); stats.Files != 1 || stats.Chunks != 3 || stats.Embedding != "" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	passages := kb.Search(context.Background(), "what should I pack for camp? do I need a tent?", 3)
+	if len(passages) == 0 || passages[0].Heading != "Camp Packing" {
+		t.Fatalf("expected the packing section first, got %+v", passages)
+	}
+	if passages := kb.Search(context.Background(), "how is the weather", 3); len(passages) != 0 {
+		t.Fatalf("unrelated question matched %+v", passages)
+	}
+
+	prompt := kb.promptContext(context.Background(), "which slash commands are there")
+	if !strings.Contains(prompt, "[1] "+filepath.ToSlash(kb.dirs[0])+"/HOW_TO_USE.md - Slash Commands") {
+		t.Fatalf("citation missing from prompt context:\n%s", prompt)
+	}
+}
+
+// keywordEmbedder embeds text by which of a few keywords it mentions.
+type keywordEmbedder struct{ calls int }
+
+func (k *keywordEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	k.calls++
+	text = strings.ToLower(text)
+	vector := make([]float32, 3)
+	for i, word := range []string{"tent", "slash", "kit"} {
+		if strings.Contains(text, word) {
+			vector[i] = 1
+		}
+	}
+	return vector, nil
+}
+
+// queryEmbedder is a keywordEmbedder that remembers what it was asked.
+type queryEmbedder struct {
+	keywordEmbedder
+	texts []string
+}
+
+func (q *queryEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	q.texts = append(q.texts, text)
+	return q.keywordEmbedder.Embed(ctx, text)
+}
+
+func TestKBSearchCommandRedactsQuery(t *testing.T) {
+	embedder := &queryEmbedder{}
+	kb := NewKnowledgeBase([]string{writeKBDocs(t)}, "")
+	kb.SetEmbedder(embedder, "keywords")
+	if err := kb.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := NewRedactor(RedactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewAIService(nil, nil, providerFunc{name: "none"})
+	svc.SetKnowledgeBase(kb)
+	svc.SetRedactor(redactor)
+	useTestService(t, svc)
+
+	embedder.texts = nil
+	reply := handleKnowledgeCommand("slack", "search tent for sam@example.com", "u1", nil)
+	if len(embedder.texts) != 1 || strings.Contains(embedder.texts[0], "sam@example.com") || !strings.Contains(embedder.texts[0], "tent") {
+		t.Fatalf("embedded queries: %q", embedder.texts)
+	}
+	if !strings.Contains(reply, "sam@example.com") {
+		t.Fatalf("reply should quote the query as typed:\n%s", reply)
+	}
+}
+
+func TestKnowledgeBaseEmbeddingsPersistAndReuse(t *testing.T) {
+	dir := writeKBDocs(t)
+	indexPath := filepath.Join(t.TempDir(), "kb-index.json")
+	embedder := &keywordEmbedder{}
+	kb := NewKnowledgeBase([]string{dir}, indexPath)
+	kb.SetEmbedder(embedder, "keywords")
+	if err := kb.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.calls != 3 {
+		t.Fatalf("expected 3 passages embedded, got %d", embedder.calls)
+	}
+	if passages := kb.Search(context.Background(), "shelter: tent?", 1); len(passages) != 1 || passages[0].Heading != "Camp Packing" {
+		t.Fatalf("embedding search returned %+v", passages)
+	}
+
+	// A restart loads the saved vectors instead of embedding again
+	reloaded := NewKnowledgeBase([]string{dir}, indexPath)
+	reloaded.SetEmbedder(embedder, "keywords")
+	embedder.calls = 0
+	if err := reloaded.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.calls != 0 || reloaded.Stats().Embedding != "keywords" {
+		t.Fatalf("index not reused: %d calls, %+v", embedder.calls, reloaded.Stats())
+	}
+
+	// Reindexing only embeds passages that changed
+	doc := strings.Replace(kbTestDoc, "water bottle", "flashlight", 1)
+	if err := os.WriteFile(filepath.Join(dir, "HOW_TO_USE.md"), []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Reindex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.calls != 1 {
+		t.Fatalf("expected 1 passage re-embedded, got %d", embedder.calls)
+	}
+}
+
+func TestRespondAddsKnowledgeToSystemPrompt(t *testing.T) {
+	var system string
+	provider := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			system = session.SystemPrompt
+			return Reply{Text: "Bring a tent [docs]."}, nil
+		},
+	}
+	kb := NewKnowledgeBase([]string{writeKBDocs(t)}, "")
+	kb.minScore = 0.5
+	if err := kb.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewAIService(nil, nil, provider)
+	svc.SetKnowledgeBase(kb)
+
+	svc.Respond(context.Background(), ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "Do I need a tent?"})
+	if !strings.Contains(system, "You are Kit") || !strings.Contains(system, "Bring a tent, a sleeping bag") {
+		t.Fatalf("knowledge not in system prompt:\n%s", system)
+	}
+	history := svc.store.GetOrCreate("discord", "u1", "c1").History(10)
+	if strings.Contains(history[0].Content, "sleeping bag") {
+		t.Fatal("passages should not be stored in the history")
+	}
+}
+
+func TestKnowledgeBaseLoadNoticesDeletedFiles(t *testing.T) {
+	dir := writeKBDocs(t)
+	extra := filepath.Join(dir, "hikes.md")
+	if err := os.WriteFile(extra, []byte("# Hikes\n\nThe ridge trail starts at the lodge."), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(t.TempDir(), "kb-index.json")
+	if err := NewKnowledgeBase([]string{dir}, indexPath).Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a file changes no modification time the index can see
+	if err := os.Remove(extra); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewKnowledgeBase([]string{dir}, indexPath)
+	if err := reloaded.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := reloaded.Stats(); stats.Files != 1 {
+		t.Fatalf("deleted file still indexed: %+v", stats)
+	}
+	if passages := reloaded.Search(context.Background(), "ridge trail", 3); len(passages) != 0 {
+		t.Fatalf("deleted file still searched: %+v", passages)
+	}
+}
+
+func TestKBReindexCommandRunsInBackground(t *testing.T) {
+	kb := NewKnowledgeBase([]string{writeKBDocs(t)}, "")
+	svc := NewAIService(nil, nil, providerFunc{name: "none"})
+	svc.SetKnowledgeBase(kb)
+	useTestService(t, svc, "admin")
+
+	if reply := handleKnowledgeCommand("discord", "reindex", "u1", nil); !strings.Contains(reply, "Only Kit admins") {
+		t.Fatalf("non-admin reindexed: %q", reply)
+	}
+	done := make(chan string, 1)
+	if reply := handleKnowledgeCommand("discord", "reindex", "admin", func(text string) { done <- text }); !strings.Contains(reply, "when it's done") {
+		t.Fatalf("reply = %q", reply)
+	}
+	if result := <-done; !strings.Contains(result, "✅ Reindexed") || !strings.Contains(result, "from 1 files") {
+		t.Fatalf("result = %q", result)
+	}
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -22,7 +27,9 @@ type Bot struct {
 	geminiClient *GeminiClient
 	claudeClient *ClaudeClient
 	aiService    *AIService
+	mcpClients   []*mcpClient
 	botUserID    string
+	teamID       string
 	startTime    string
 }
 
@@ -33,6 +40,17 @@ var globalBot *Bot
 var globalAIService *AIService
 var globalSessionStore SessionStore
 var globalCampClient *CampClient
+var globalCampMonitor *CampMonitor
+
+// globalAdminIDs lists Slack and Discord user IDs allowed to run admin
+// commands (ADMIN_USER_IDS).
+var globalAdminIDs []string
+
+// globalStreamResponses enables live-edited streaming replies (STREAM_RESPONSES).
+var globalStreamResponses = true
+
+// globalAttachmentLimits bounds the files read from messages (ATTACHMENT_*).
+var globalAttachmentLimits = DefaultAttachmentLimits()
 
 func main() {
 	// Load environment variables
@@ -40,6 +58,17 @@ func main() {
 		log.Println("Warning: .env file not found")
 	}
 
+	// "eval" scores an evaluation suite instead of starting the bot; see
+	// eval.go and cmd/kit-eval
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(runEval(os.Args[2:]))
+	}
+	// "mcp" serves the Model Context Protocol on stdio for a client that
+	// launched Kit; see mcp.go
+	if len(os.Args) > 1 && os.Args[1] == "mcp" {
+		os.Exit(runMCPStdio())
+	}
+
 	log.Printf("🚀 Starting Kit AI Bot (Multi-Platform)...")
 
 	// Get Slack tokens
@@ -49,32 +78,21 @@ func main() {
 	// Get Discord token
 	discordToken := os.Getenv("DISCORD_BOT_TOKEN")
 
-	// Check if at least one platform is configured
-	if slackBotToken == "" && discordToken == "" {
-		log.Fatal("❌ At least one platform must be configured (SLACK_BOT_TOKEN or DISCORD_BOT_TOKEN)")
-	}
-
-	// Get AI configuration
-	geminiAPIKey := os.Getenv("GEMINI_API_KEY")
-	geminiModel := os.Getenv("GEMINI_MODEL")
-	if geminiModel == "" {
-		geminiModel = "gemini-1.5-flash" // default model
-	}
-
-	claudeAPIKey := os.Getenv("CLAUDE_API_KEY")
-	claudeModel := os.Getenv("CLAUDE_MODEL")
-	if claudeModel == "" {
-		claudeModel = "claude-3-sonnet-20240229" // default model
+	// Check if at least one platform is configured; the HTTP chat API alone
+	// runs Kit headless
+	if slackBotToken == "" && discordToken == "" && os.Getenv("API_ADDR") == "" {
+		log.Fatal("❌ At least one platform must be configured (SLACK_BOT_TOKEN, DISCORD_BOT_TOKEN or API_ADDR)")
 	}
 
-	githubModelsToken := os.Getenv("GITHUB_MODELS_TOKEN")
-	githubModelsModel := os.Getenv("GITHUB_MODELS_MODEL") // defaults inside the client
+	// Streaming replies are on unless explicitly disabled
+	globalStreamResponses = !strings.EqualFold(os.Getenv("STREAM_RESPONSES"), "false")
+	globalAdminIDs = splitList(os.Getenv("ADMIN_USER_IDS"))
+	globalAttachmentLimits = attachmentLimitsFromEnv()
 
-	// Generic OpenAI-compatible endpoint (Groq, Mistral, OpenRouter, Ollama, OpenAI...)
-	compatBaseURL := os.Getenv("OPENAI_COMPAT_BASE_URL")
-	compatAPIKey := os.Getenv("OPENAI_COMPAT_API_KEY")
-	compatModel := os.Getenv("OPENAI_COMPAT_MODEL")
-	compatName := os.Getenv("OPENAI_COMPAT_NAME")
+	// Canceled on SIGINT/SIGTERM: in-flight replies stop and the bot exits
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	globalInflight = newInflightRequests(appCtx)
 
 	// Create Bot instance with configuration
 	bot := &Bot{
@@ -83,76 +101,19 @@ func main() {
 	globalBot = bot
 
 	// Initialize AI clients
-	if geminiAPIKey != "" {
-		bot.geminiClient = NewGeminiClient(geminiAPIKey, geminiModel)
-		globalGeminiClient = bot.geminiClient
-		if bot.geminiClient != nil {
-			log.Println("🧠 Gemini AI initialized (model from GEMINI_MODEL)")
-		}
-	}
+	clients := aiClientsFromEnv()
+	bot.geminiClient = clients.gemini
+	bot.claudeClient = clients.claude
+	globalGeminiClient = clients.gemini
+	globalClaudeClient = clients.claude
 
-	if claudeAPIKey != "" {
-		bot.claudeClient = NewClaudeClient(claudeAPIKey, claudeModel)
-		globalClaudeClient = bot.claudeClient
-		if bot.claudeClient != nil {
-			log.Println("🧠 Claude AI initialized (model from CLAUDE_MODEL)")
-		}
-	}
-
-	var githubModelsClient *GitHubModelsClient
-	if githubModelsToken != "" {
-		githubModelsClient = NewGitHubModelsClient(githubModelsToken, githubModelsModel)
-		if githubModelsClient != nil {
-			log.Println("🧠 GitHub Models initialized (model from GITHUB_MODELS_MODEL)")
-		}
-	}
-
-	var compatClient *OpenAICompatClient
-	if compatBaseURL != "" && compatModel != "" {
-		compatClient = NewOpenAICompatClient(compatBaseURL, compatAPIKey, compatModel, compatName)
-		if compatClient != nil {
-			log.Println("🧠 OpenAI-compatible provider initialized (see OPENAI_COMPAT_* config)")
-		}
-	}
-
-	if bot.geminiClient == nil && bot.claudeClient == nil && githubModelsClient == nil && compatClient == nil {
-		log.Println("⚠️  No AI clients available - using basic responses only")
-	}
+	// Camp Power-Up integration (optional)
+	globalCampClient = campClientFromEnv()
 
-	globalSessionStore = NewInMemorySessionStore()
-	providers := make([]Provider, 0, 4)
-	if compatClient != nil {
-		providers = append(providers, newOpenAICompatProvider(compatClient))
-	}
-	if githubModelsClient != nil {
-		providers = append(providers, newGitHubModelsProvider(githubModelsClient))
-	}
-	if bot.geminiClient != nil {
-		providers = append(providers, newGeminiProvider(bot.geminiClient))
-	}
-	if bot.claudeClient != nil {
-		providers = append(providers, newClaudeProvider(bot.claudeClient))
-	}
-	bot.aiService = NewAIService(globalSessionStore, generateBasicResponse, providers...)
+	globalSessionStore = newSessionStoreFromEnv()
+	bot.aiService = newAIServiceFromEnv(clients, globalSessionStore, globalCampClient)
 	globalAIService = bot.aiService
 
-	// Camp Power-Up integration (optional)
-	campBaseURL := os.Getenv("CAMP_API_BASE_URL")
-	if campBaseURL != "" {
-		campCapacity, _ := strconv.Atoi(os.Getenv("CAMP_CAPACITY"))
-		globalCampClient = NewCampClient(
-			campBaseURL,
-			os.Getenv("CAMP_ADMIN_USERNAME"),
-			os.Getenv("CAMP_ADMIN_PASSWORD"),
-			os.Getenv("CAMP_ALLOWED_DISCORD_IDS"),
-			os.Getenv("CAMP_ALLOWED_ROLE"),
-			campCapacity,
-		)
-		if globalCampClient != nil {
-			log.Println("🏕️  Camp Power-Up integration enabled (CAMP_API_BASE_URL)")
-		}
-	}
-
 	// Initialize Slack if tokens are available
 	if slackBotToken != "" && slackAppToken != "" {
 		log.Printf("🔵 Initializing Slack integration...")
@@ -168,6 +129,7 @@ func main() {
 			log.Printf("❌ Failed to authenticate with Slack: %v", err)
 		} else {
 			bot.botUserID = authTest.UserID
+			bot.teamID = authTest.TeamID
 			log.Println("✅ Slack authenticated successfully")
 		}
 	}
@@ -192,18 +154,26 @@ func main() {
 				if pollMinutes <= 0 {
 					pollMinutes = 5
 				}
-				monitor := NewCampMonitor(
+				globalCampMonitor = NewCampMonitor(
 					globalCampClient,
 					discordBot.session,
 					os.Getenv("CAMP_ALERTS_CHANNEL"),
 					os.Getenv("CAMP_STATUS_CHANNEL"),
 					time.Duration(pollMinutes)*time.Minute,
 				)
-				monitor.Start()
+				globalCampMonitor.Start()
 			}
 		}
 	}
 
+	registerTools(bot.aiService, globalCampClient, globalCampMonitor)
+	bot.mcpClients = connectMCPServersFromEnv(appCtx, bot.aiService)
+
+	// Optional MCP server for IDE assistants and other agents (MCP_ADDR)
+	startMCPServerFromEnv(appCtx, bot.aiService)
+	// Optional HTTP chat API for web apps and scripts (API_ADDR)
+	startChatAPIFromEnv(appCtx, bot.aiService)
+
 	// Only proceed with Slack if it's configured
 	if bot.slackAPI != nil {
 		// Create Socket Mode client
@@ -213,26 +183,339 @@ func main() {
 			socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
 		)
 
-		// Create context for graceful shutdown
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
 		log.Println("📡 Starting Slack event listener...")
 
 		// Start event handler goroutine
-		go handleEvents(ctx, socketClient, bot.slackAPI)
+		go handleEvents(appCtx, socketClient, bot.slackAPI)
 
-		// Start the Socket Mode connection
+		// Start the Socket Mode connection; it returns on shutdown
 		log.Println("🔌 Connecting to Slack...")
-		if err := socketClient.Run(); err != nil {
+		if err := socketClient.RunContext(appCtx); err != nil && appCtx.Err() == nil {
 			log.Fatalf("❌ Failed to start Slack Socket Mode: %v", err)
 		}
 	} else {
-		log.Println("🔵 Slack not configured, running Discord-only mode...")
+		if discordToken != "" {
+			log.Println("🔵 Slack not configured, running Discord-only mode...")
+		} else {
+			log.Println("🌐 No chat platforms configured, serving the chat API only...")
+		}
+
+		// Keep the program running for Discord and the API until shutdown
+		<-appCtx.Done()
+	}
+	shutdown(bot)
+}
+
+// shutdown runs after the shutdown signal, once in-flight replies have been
+// canceled: it disconnects Discord and closes the session store.
+func shutdown(bot *Bot) {
+	log.Println("🛑 Shutting down...")
+	if bot.discordBot != nil {
+		if err := bot.discordBot.Stop(); err != nil {
+			log.Printf("⚠️  Failed to close Discord connection: %v", err)
+		}
+	}
+	closeMCPClients(bot.mcpClients)
+	if closer, ok := globalSessionStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("⚠️  Failed to close session store: %v", err)
+		}
+	}
+}
+
+// newAIServiceFromEnv builds the assistant the chat adapters and the MCP
+// server share: providers, personas, limits, cache, knowledge base, routing
+// and redaction, as configured in the environment.
+func newAIServiceFromEnv(clients aiClients, store SessionStore, camp *CampClient) *AIService {
+	providers := clients.providers()
+	if len(providers) == 0 {
+		log.Println("⚠️  No AI clients available - using basic responses only")
+	}
+	svc := NewAIService(store, generateBasicResponse, providers...)
+
+	// Personas supply the system prompt, per channel if configured
+	svc.SetPersonas(personasFromEnv())
+
+	// Overall deadline per request, across every provider tried
+	if timeout, ok := requestTimeoutFromEnv(); ok {
+		svc.SetRequestTimeout(timeout)
+	}
+
+	// Prompt budget; older turns beyond it are summarized
+	if contextTokens, err := strconv.Atoi(os.Getenv("AI_CONTEXT_TOKENS")); err == nil {
+		svc.SetContextBudget(contextTokens)
+	}
+
+	// Per-user/channel/workspace rate limits and daily quotas, with state
+	// kept in the session backend
+	if limits := rateLimitsFromEnv(); limits.enabled() {
+		if rateStore, ok := store.(RateStore); ok {
+			svc.SetRateLimiter(NewRateLimiter(limits, rateStore))
+			log.Printf("🚦 Rate limiting enabled (%d/min per user, %d exempt users)", limits.PerUser, len(limits.Exempt))
+		}
+	}
+
+	// Token usage pricing and an optional persisted ledger for /kit usage
+	if pricingPath := os.Getenv("AI_PRICING_CONFIG"); pricingPath != "" {
+		prices, err := LoadPriceTable(pricingPath)
+		if err != nil {
+			log.Printf("❌ Failed to load price table: %v", err)
+		} else {
+			svc.SetPriceTable(prices)
+			log.Printf("💲 Price table loaded (%d models)", len(prices.Models))
+		}
+	}
+	if usagePath := os.Getenv("USAGE_FILE"); usagePath != "" {
+		if err := svc.Usage().Persist(usagePath); err != nil {
+			log.Printf("❌ Failed to load usage ledger: %v", err)
+		}
+	}
+
+	// Optional response cache for repeated standalone questions
+	if strings.EqualFold(os.Getenv("RESPONSE_CACHE"), "true") {
+		svc.SetResponseCache(newResponseCacheFromEnv(clients.gemini, clients.embeddingClient()))
+	}
+
+	// Optional knowledge base of local docs for retrieval-augmented answers.
+	// Indexing may embed every passage, so it runs in the background.
+	if kb := knowledgeBaseFromEnv(clients.gemini, clients.embeddingClient()); kb != nil {
+		svc.SetKnowledgeBase(kb)
+		go func() {
+			if err := kb.Load(context.Background()); err != nil {
+				log.Printf("❌ Failed to index knowledge base: %v", err)
+			}
+		}()
+	}
+
+	// Optional per-platform/channel/user provider routing
+	if routingPath := os.Getenv("AI_ROUTING_CONFIG"); routingPath != "" {
+		policy, err := LoadRoutingPolicy(routingPath)
+		if err != nil {
+			log.Printf("❌ Failed to load routing policy: %v", err)
+		} else {
+			svc.SetRoutingPolicy(policy)
+			log.Printf("🧭 Provider routing policy loaded (%d routes)", len(policy.Routes))
+		}
+	}
+
+	// Optional PII redaction of everything sent to providers, including the
+	// names of registered campers when the camp integration is enabled
+	if redactor := redactorFromEnv(); redactor != nil {
+		svc.SetRedactor(redactor)
+		go redactor.watchRoster(camp, rosterRefreshInterval)
+		log.Println("🕶️  PII redaction enabled")
+	}
+	return svc
+}
+
+// campClientFromEnv connects the optional Camp Power-Up integration
+// (CAMP_API_BASE_URL); nil when it isn't configured.
+func campClientFromEnv() *CampClient {
+	campBaseURL := os.Getenv("CAMP_API_BASE_URL")
+	if campBaseURL == "" {
+		return nil
+	}
+	campCapacity, _ := strconv.Atoi(os.Getenv("CAMP_CAPACITY"))
+	camp := NewCampClient(
+		campBaseURL,
+		os.Getenv("CAMP_ADMIN_USERNAME"),
+		os.Getenv("CAMP_ADMIN_PASSWORD"),
+		os.Getenv("CAMP_ALLOWED_DISCORD_IDS"),
+		os.Getenv("CAMP_ALLOWED_ROLE"),
+		campCapacity,
+	)
+	if camp != nil {
+		log.Println("🏕️  Camp Power-Up integration enabled (CAMP_API_BASE_URL)")
+	}
+	return camp
+}
+
+// registerTools registers the tools the AI can call (function calling).
+// monitor may be nil; the website tool then checks the site itself.
+func registerTools(svc *AIService, camp *CampClient, monitor *CampMonitor) {
+	// Tools the AI can call (function calling). Camp tools only expose
+	// aggregate numbers and website status, never registration details.
+	if maxSteps, err := strconv.Atoi(os.Getenv("AI_TOOL_MAX_STEPS")); err == nil {
+		svc.SetToolMaxSteps(maxSteps)
+	}
+	if camp != nil {
+		svc.RegisterTool(campStatsTool(camp))
+		svc.RegisterTool(campWebsiteTool(camp, monitor))
+		log.Println("🔧 Camp tools registered for AI function calling")
+	}
+}
+
+// aiClients are the AI clients configured in the environment.
+type aiClients struct {
+	gemini       *GeminiClient
+	claude       *ClaudeClient
+	githubModels *GitHubModelsClient
+	compat       []*OpenAICompatClient
+	mocks        []Provider
+}
+
+// aiClientsFromEnv creates every AI client the environment configures, with
+// AI_CASSETTE applied to the HTTP ones. The bot and kit-eval share it.
+func aiClientsFromEnv() aiClients {
+	var clients aiClients
+	if apiKey := os.Getenv("GEMINI_API_KEY"); apiKey != "" {
+		model := os.Getenv("GEMINI_MODEL")
+		if model == "" {
+			model = "gemini-1.5-flash" // default model
+		}
+		if clients.gemini = NewGeminiClient(apiKey, model); clients.gemini != nil {
+			log.Println("🧠 Gemini AI initialized (model from GEMINI_MODEL)")
+		}
+	}
+
+	if apiKey := os.Getenv("CLAUDE_API_KEY"); apiKey != "" {
+		model := os.Getenv("CLAUDE_MODEL")
+		if model == "" {
+			model = "claude-3-sonnet-20240229" // default model
+		}
+		if clients.claude = NewClaudeClient(apiKey, model); clients.claude != nil {
+			log.Println("🧠 Claude AI initialized (model from CLAUDE_MODEL)")
+		}
+	}
+
+	if token := os.Getenv("GITHUB_MODELS_TOKEN"); token != "" {
+		// The model defaults inside the client
+		if clients.githubModels = NewGitHubModelsClient(token, os.Getenv("GITHUB_MODELS_MODEL")); clients.githubModels != nil {
+			log.Println("🧠 GitHub Models initialized (model from GITHUB_MODELS_MODEL)")
+		}
+	}
 
-		// Keep the program running for Discord
-		select {}
+	// Named OpenAI-compatible endpoints (Groq, Mistral, OpenRouter, Ollama,
+	// OpenAI...) from OPENAI_COMPAT_CONFIG and OPENAI_COMPAT_*, in priority order
+	clients.compat = newCompatClients()
+
+	// Record or replay the HTTP providers' exchanges for offline runs
+	if cassette := cassetteFromEnv(); cassette != nil {
+		for _, client := range clients.compat {
+			client.httpClient = cassette.Client(client.httpClient)
+		}
+		if clients.githubModels != nil {
+			clients.githubModels.httpClient = cassette.Client(clients.githubModels.httpClient)
+		}
+	}
+	clients.mocks = mockProvidersFromEnv()
+	return clients
+}
+
+// embeddingClient is the OpenAI-compatible client used for cache and
+// knowledge base embeddings, if any.
+func (c aiClients) embeddingClient() *OpenAICompatClient {
+	if len(c.compat) == 0 {
+		return nil
+	}
+	return c.compat[0]
+}
+
+// providers lists the configured providers in fallback order.
+func (c aiClients) providers() []Provider {
+	providers := make([]Provider, 0, len(c.compat)+3+len(c.mocks))
+	for _, client := range c.compat {
+		providers = append(providers, newOpenAICompatProvider(client))
+	}
+	if c.githubModels != nil {
+		providers = append(providers, newGitHubModelsProvider(c.githubModels))
+	}
+	if c.gemini != nil {
+		providers = append(providers, newGeminiProvider(c.gemini))
+	}
+	if c.claude != nil {
+		providers = append(providers, newClaudeProvider(c.claude))
+	}
+	// Mock providers come last so real ones answer when configured
+	return append(providers, c.mocks...)
+}
+
+// requestTimeoutFromEnv reads AI_REQUEST_TIMEOUT as seconds ("30") or a Go
+// duration ("45s").
+func requestTimeoutFromEnv() (time.Duration, bool) {
+	value := os.Getenv("AI_REQUEST_TIMEOUT")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+		return timeout, true
+	}
+	return 0, false
+}
+
+// newResponseCacheFromEnv configures the response cache from RESPONSE_CACHE_*.
+// Similarity matching uses Gemini embeddings when Gemini is configured, or
+// the OpenAI-compatible provider when RESPONSE_CACHE_EMBEDDING_MODEL is set.
+func newResponseCacheFromEnv(gemini *GeminiClient, compat *OpenAICompatClient) *ResponseCache {
+	ttl, _ := time.ParseDuration(os.Getenv("RESPONSE_CACHE_TTL"))
+	maxEntries, _ := strconv.Atoi(os.Getenv("RESPONSE_CACHE_MAX_ENTRIES"))
+	cache := NewResponseCache(ttl, maxEntries)
+	for _, channelID := range splitList(os.Getenv("RESPONSE_CACHE_DISABLED_CHANNELS")) {
+		cache.DisableChannel(channelID)
+	}
+
+	threshold, _ := strconv.ParseFloat(os.Getenv("RESPONSE_CACHE_SIMILARITY"), 64)
+	embeddingModel := os.Getenv("RESPONSE_CACHE_EMBEDDING_MODEL")
+	switch {
+	case threshold <= 0:
+	case gemini != nil:
+		cache.SetEmbedder(gemini, threshold)
+	case compat != nil && embeddingModel != "":
+		cache.SetEmbedder(compat.Embedder(embeddingModel), threshold)
+	default:
+		log.Println("⚠️  RESPONSE_CACHE_SIMILARITY set but no embedding provider available; exact matches only")
+		threshold = 0
+	}
+	log.Printf("🗃️  Response cache enabled (similarity threshold %.2f)", threshold)
+	return cache
+}
+
+// splitList parses a comma-separated config value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
+
+// isAdmin reports whether userID is listed in ADMIN_USER_IDS.
+func isAdmin(userID string) bool {
+	return userID != "" && slices.Contains(globalAdminIDs, userID)
+}
+
+// newSessionStoreFromEnv picks the session backend: Redis when REDIS_URL is
+// set, else the single-file journal when SESSION_FILE is set, else in-memory.
+// ENABLE_CONVERSATION_MEMORY=false keeps sessions in memory only. Every
+// backend applies the same retention (see sessionRetentionFromEnv).
+func newSessionStoreFromEnv() SessionStore {
+	retention := sessionRetentionFromEnv()
+	if strings.EqualFold(os.Getenv("ENABLE_CONVERSATION_MEMORY"), "false") {
+		return NewInMemorySessionStore(retention)
+	}
+
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		store, err := NewRedisSessionStore(redisURL, retention)
+		if err == nil {
+			log.Println("🗄️  Redis session store connected (REDIS_URL)")
+			return store
+		}
+		log.Printf("❌ Redis session store unavailable: %v", err)
+	}
+
+	if path := os.Getenv("SESSION_FILE"); path != "" {
+		store, err := NewFileSessionStore(path, retention)
+		if err == nil {
+			log.Printf("🗄️  File session store loaded %d sessions from %s (SESSION_FILE)", store.Len(), path)
+			return store
+		}
+		log.Printf("❌ File session store unavailable: %v", err)
+	}
+
+	log.Println("🗄️  Using in-memory sessions")
+	return NewInMemorySessionStore(retention)
 }
 
 // handleEvents processes all incoming Slack events
@@ -302,6 +585,26 @@ func handleSlashCommand(event socketmode.Event, client *socketmode.Client, api *
 
 	log.Printf("⚡ Slash command: %s %s from %s in %s", cmd.Command, cmd.Text, cmd.UserName, cmd.ChannelName)
 
+	// The usage export is sent to the admin privately as a file
+	if fields := strings.Fields(cmd.Text); cmd.Command == "/kit" && len(fields) > 0 && strings.EqualFold(fields[0], "usage") {
+		if args, ok := isUsageExport(strings.Join(fields[1:], " ")); ok {
+			sendUsageExport(api, cmd.ChannelID, args, cmd.UserID)
+			return
+		}
+	}
+
+	// Conversation exports are sent to the user privately as a file
+	if fields := strings.Fields(cmd.Text); cmd.Command == "/kit" && len(fields) > 0 {
+		switch {
+		case strings.EqualFold(fields[0], "export"):
+			sendHistoryExport(api, cmd.ChannelID, strings.Join(fields[1:], " "), cmd.UserID)
+			return
+		case strings.EqualFold(fields[0], "history") && len(fields) > 1 && strings.EqualFold(fields[1], "export"):
+			sendHistoryExport(api, cmd.ChannelID, strings.Join(fields[2:], " "), cmd.UserID)
+			return
+		}
+	}
+
 	// Generate response based on command
 	response := handleSlashCommandLogic(cmd)
 
@@ -317,20 +620,28 @@ func handleSlashCommandLogic(cmd slack.SlashCommand) string {
 
 	switch cmd.Command {
 	case "/kit":
-		return handleKitCommand(commandText, cmd.UserID)
+		return handleKitCommand(commandText, cmd.UserID, cmd.ChannelID)
 	default:
 		return fmt.Sprintf("❓ Unknown command: %s", cmd.Command)
 	}
 }
 
 // handleKitCommand processes /kit subcommands
-func handleKitCommand(args, userID string) string {
+func handleKitCommand(args, userID, channelID string) string {
 	if args == "" {
 		return "👋 **Kit Slash Commands**\n\n" +
 			"Available commands:\n" +
 			"• `/kit status` - Check bot health\n" +
 			"• `/kit help` - Show help information\n" +
 			"• `/kit version` - Show version info\n" +
+			"• `/kit cache [clear]` - Response cache stats (clear: admins)\n" +
+			"• `/kit usage [days|export]` - AI usage and cost report (admins)\n" +
+			"• `/kit persona [set <name>|reset]` - Show or switch this channel's persona (switch: admins)\n" +
+			"• `/kit kb [search <question>|reindex]` - Search the docs knowledge base (reindex: admins)\n" +
+			"• `/kit model [list|use <name>|reset]` - Choose which AI provider answers you here\n" +
+			"• `/kit compare <question>` - Ask every provider and compare answers (admins)\n" +
+			"• `/kit export [markdown|json]` - DM you your conversation history\n" +
+			"• `/kit forget` - Delete everything Kit keeps about you\n" +
 			"• `/kit ask [question]` - Ask Kit a question\n\n" +
 			"Example: `/kit ask What is Go programming?`"
 	}
@@ -348,12 +659,40 @@ func handleKitCommand(args, userID string) string {
 	case "version":
 		return handleSpecialCommands("version")
 
+	case "cache":
+		return handleCacheCommand(strings.Join(parts[1:], " "), userID)
+
+	case "usage":
+		return handleUsageCommand(strings.Join(parts[1:], " "), userID)
+
+	case "persona":
+		return handlePersonaCommand(strings.Join(parts[1:], " "), userID, channelID)
+
+	case "kb":
+		return handleKnowledgeCommand("slack", strings.Join(parts[1:], " "), userID, func(text string) {
+			if globalBot != nil && globalBot.slackAPI != nil {
+				postEphemeral(globalBot.slackAPI, channelID, userID, text)
+			}
+		})
+
+	case "forget":
+		return handleForgetCommand("slack", strings.Join(parts[1:], " "), userID)
+
+	case "model":
+		return handleModelCommand("slack", strings.Join(parts[1:], " "), userID, channelID)
+
+	case "compare":
+		ctx, done := globalInflight.start("")
+		defer done()
+		question := strings.Join(parts[1:], " ")
+		return settledReply(ctx, handleCompareCommand(ctx, slackChatRequest(userID, channelID, question)))
+
 	case "ask":
 		if len(parts) < 2 {
 			return "❓ **Usage:** `/kit ask [your question]`\n\nExample: `/kit ask What is artificial intelligence?`"
 		}
 		question := strings.Join(parts[1:], " ")
-		return generateResponse(question, userID)
+		return generateResponse(question, userID, channelID)
 
 	default:
 		return fmt.Sprintf("❓ **Unknown subcommand:** `%s`\n\n"+
@@ -361,6 +700,12 @@ func handleKitCommand(args, userID string) string {
 			"• `/kit status` - Check bot health\n"+
 			"• `/kit help` - Show help\n"+
 			"• `/kit version` - Show version\n"+
+			"• `/kit cache [clear]` - Response cache\n"+
+			"• `/kit usage [days|export]` - AI usage report\n"+
+			"• `/kit persona` - Channel persona\n"+
+			"• `/kit kb [search <question>]` - Knowledge base\n"+
+			"• `/kit model` / `/kit compare` - AI providers\n"+
+			"• `/kit export` / `/kit forget` - Your data\n"+
 			"• `/kit ask [question]` - Ask a question", subcommand)
 	}
 }
@@ -383,6 +728,74 @@ func sendSlashCommandResponse(api *slack.Client, responseURL, channelID, text st
 	}
 }
 
+// sendUsageExport uploads the usage ledger as a JSON file to a DM with the
+// admin, since it shows every user's usage, telling them privately in the
+// channel where they asked.
+func sendUsageExport(api *slack.Client, channelID, args, userID string) {
+	data, reply := usageExport(args, userID)
+	if data == nil {
+		postEphemeral(api, channelID, userID, reply)
+		return
+	}
+	dm, _, _, err := api.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+	if err == nil {
+		_, err = api.UploadFile(slack.FileUploadParameters{
+			Content:  string(data),
+			Filetype: "json",
+			Filename: "kit-usage.json",
+			Title:    "Kit usage export",
+			Channels: []string{dm.ID},
+		})
+	}
+	if err != nil {
+		log.Printf("❌ Failed to DM usage export: %v", err)
+		postEphemeral(api, channelID, userID, "❌ Sorry, I couldn't send the usage export right now.")
+		return
+	}
+	if dm.ID != channelID {
+		postEphemeral(api, channelID, userID, "📬 I've sent the usage export in a DM.")
+	}
+}
+
+// sendHistoryExport uploads the user's stored conversations to a DM with
+// them, telling them privately in the channel where they asked.
+func sendHistoryExport(api *slack.Client, channelID, args, userID string) {
+	data, filename, reply := sessionsExport("slack", args, userID)
+	if data == nil {
+		postEphemeral(api, channelID, userID, reply)
+		return
+	}
+	dm, _, _, err := api.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+	if err == nil {
+		filetype := "markdown"
+		if strings.HasSuffix(filename, ".json") {
+			filetype = "json"
+		}
+		_, err = api.UploadFile(slack.FileUploadParameters{
+			Content:  string(data),
+			Filetype: filetype,
+			Filename: filename,
+			Title:    "Your Kit conversation history",
+			Channels: []string{dm.ID},
+		})
+	}
+	if err != nil {
+		log.Printf("❌ Failed to DM history export: %v", err)
+		postEphemeral(api, channelID, userID, "❌ Sorry, I couldn't send your conversation history right now.")
+		return
+	}
+	if dm.ID != channelID {
+		postEphemeral(api, channelID, userID, "📬 I've sent your conversation history in a DM.")
+	}
+}
+
+// postEphemeral shows text only to userID in the channel.
+func postEphemeral(api *slack.Client, channelID, userID, text string) {
+	if _, err := api.PostEphemeral(channelID, userID, slack.MsgOptionText(text, false)); err != nil {
+		log.Printf("❌ Failed to send ephemeral message: %v", err)
+	}
+}
+
 // handleEventsAPI processes EventsAPI events (messages, mentions, etc.)
 func handleEventsAPI(event socketmode.Event, client *socketmode.Client, api *slack.Client) {
 	// Acknowledge the event first
@@ -439,11 +852,18 @@ func handleMessageEvent(event *slackevents.MessageEvent, api *slack.Client) {
 		return
 	}
 
+	// A deleted message cancels the reply Kit may still be generating
+	if event.SubType == "message_deleted" {
+		if event.PreviousMessage != nil && globalInflight.cancel(inflightKey("slack", event.Channel, event.PreviousMessage.TimeStamp)) {
+			log.Printf("🗑️  Slack message %s deleted; reply canceled", event.PreviousMessage.TimeStamp)
+		}
+		return
+	}
+
 	// Only respond to direct messages (DM channels start with 'D')
 	if strings.HasPrefix(event.Channel, "D") {
 		log.Println("📨 Direct message - generating response...")
-		response := generateResponse(event.Text, event.User)
-		sendMessage(api, event.Channel, response)
+		respondInSlack(api, event.Channel, event.TimeStamp, event.Text, event.User, event.Files)
 	} else {
 		log.Printf("👀 Public channel message ignored (channel: %s)", event.Channel)
 	}
@@ -453,11 +873,57 @@ func handleMessageEvent(event *slackevents.MessageEvent, api *slack.Client) {
 func handleMentionEvent(event *slackevents.AppMentionEvent, api *slack.Client) {
 	log.Printf("🎯 Kit mentioned in channel %s", event.Channel)
 
-	// Remove bot mention from message text
+	// Remove bot mention from message text. app_mention events don't carry
+	// files, so attachments are only read in DMs.
 	cleanMessage := removeBotMention(event.Text)
 
-	response := generateResponse(cleanMessage, event.User)
-	sendMessage(api, event.Channel, response)
+	respondInSlack(api, event.Channel, event.TimeStamp, cleanMessage, event.User, nil)
+}
+
+// respondInSlack answers a DM or mention along with any attached files. AI
+// replies are streamed into a placeholder message that is edited as text
+// arrives; commands and non-streaming setups get a single message. ts
+// identifies the triggering message, whose deletion cancels the reply.
+func respondInSlack(api *slack.Client, channel, ts, message, userID string, files []slackevents.File) {
+	cleanMessage := cleanSlackMessage(message, userID)
+	if globalAIService == nil || handleSpecialCommands(cleanMessage) != "" {
+		sendMessage(api, channel, generateResponse(message, userID, channel))
+		return
+	}
+	ctx, done := globalInflight.start(inflightKey("slack", channel, ts))
+	defer done()
+	req := slackChatRequest(userID, channel, cleanMessage)
+	if sources := slackAttachmentSources(files); len(sources) > 0 {
+		req.LoadAttachments = func(ctx context.Context) []Attachment {
+			return globalAttachmentLimits.load(ctx, sources)
+		}
+	}
+	if !globalStreamResponses {
+		if response := settledReply(ctx, globalAIService.Respond(ctx, req)); response != "" {
+			sendMessage(api, channel, response)
+		}
+		return
+	}
+
+	log.Printf("💭 Streaming response for: '%s'", cleanMessage)
+	streamer := newMessageStreamer(
+		func(text string) (string, error) {
+			_, ts, err := api.PostMessage(channel, slack.MsgOptionText(text, false), slack.MsgOptionAsUser(true))
+			return ts, err
+		},
+		func(ts, text string) error {
+			_, _, _, err := api.UpdateMessage(channel, ts, slack.MsgOptionText(text, false))
+			return err
+		},
+		slackStreamLimit,
+		slackEditInterval,
+	)
+	streamer.Start()
+	response := globalAIService.RespondStream(ctx, req, streamer.Update)
+	streamer.Settle(ctx, response, func(ts string) error {
+		_, _, err := api.DeleteMessage(channel, ts)
+		return err
+	})
 }
 
 // removeBotMention removes bot mention tags from message text
@@ -474,14 +940,10 @@ func removeBotMention(text string) string {
 	return strings.TrimSpace(cleanText)
 }
 
-// generateResponse creates a response to user messages with AI integration
-func generateResponse(message, userID string) string {
-	// Clean the message text
-	cleanMessage := strings.TrimSpace(message)
-
-	// Remove mention tags like <@U123456789>
-	cleanMessage = strings.ReplaceAll(cleanMessage, fmt.Sprintf("<@%s>", userID), "")
-	cleanMessage = strings.TrimSpace(cleanMessage)
+// generateResponse creates a response to user messages with AI integration.
+// channelID scopes the conversation history, so each DM or channel keeps its own.
+func generateResponse(message, userID, channelID string) string {
+	cleanMessage := cleanSlackMessage(message, userID)
 
 	log.Printf("💭 Generating response for: '%s'", cleanMessage)
 
@@ -491,33 +953,66 @@ func generateResponse(message, userID string) string {
 	}
 
 	if globalAIService != nil {
-		return globalAIService.Respond(context.Background(), ChatRequest{
-			Platform: "slack",
-			UserID:   userID,
-			Message:  cleanMessage,
-		})
+		ctx, done := globalInflight.start("")
+		defer done()
+		return settledReply(ctx, globalAIService.Respond(ctx, slackChatRequest(userID, channelID, cleanMessage)))
 	}
 
 	// Fallback to basic responses
 	return generateBasicResponse(cleanMessage)
 }
 
+// slackChatRequest builds the AIService request for a Slack message.
+// DM channel IDs start with 'D'.
+func slackChatRequest(userID, channelID, cleanMessage string) ChatRequest {
+	return ChatRequest{
+		Platform:      "slack",
+		UserID:        userID,
+		ChannelID:     channelID,
+		WorkspaceID:   globalBot.teamID,
+		Message:       cleanMessage,
+		DirectMessage: strings.HasPrefix(channelID, "D"),
+	}
+}
+
+// slackAttachmentSources lists a message's files for download with the bot
+// token, which needs the files:read scope.
+func slackAttachmentSources(files []slackevents.File) []attachmentSource {
+	sources := make([]attachmentSource, 0, len(files))
+	for _, file := range files {
+		sources = append(sources, attachmentSource{
+			Name:     file.Name,
+			URL:      file.URLPrivateDownload,
+			MIMEType: file.Mimetype,
+			Size:     int64(file.Size),
+			Header:   http.Header{"Authorization": {"Bearer " + os.Getenv("SLACK_BOT_TOKEN")}},
+		})
+	}
+	return sources
+}
+
+// cleanSlackMessage trims the message and removes mention tags like <@U123456789>
+func cleanSlackMessage(message, userID string) string {
+	cleanMessage := strings.ReplaceAll(strings.TrimSpace(message), fmt.Sprintf("<@%s>", userID), "")
+	return strings.TrimSpace(cleanMessage)
+}
+
 // handleSpecialCommands processes special bot commands
 func handleSpecialCommands(message string) string {
 	cleanMessage := strings.ToLower(strings.TrimSpace(message))
 
 	switch {
 	case cleanMessage == "status" || cleanMessage == "health":
-		aiStatus := "❌ Offline"
-		if globalGeminiClient != nil {
-			aiStatus = "✅ Online (Gemini)"
+		var providers []ProviderStatus
+		if globalAIService != nil {
+			providers = globalAIService.ProviderStatus()
 		}
 
 		return fmt.Sprintf("🤖 **Kit Status Report**\n"+
 			"• Bot Status: ✅ Online and Connected\n"+
-			"• AI Engine: %s\n"+
+			"%s\n"+
 			"• Started: %s\n"+
-			"• Ready to help! 🚀", aiStatus, globalBot.startTime)
+			"• Ready to help! 🚀", formatServiceStatus(globalAIService, providers), globalBot.startTime)
 
 	case cleanMessage == "help" || cleanMessage == "commands":
 		return "🤖 **Kit Commands**\n\n" +
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MCP protocol versions the server speaks, newest first. A client asking
+// for another gets the newest.
+var mcpProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+const (
+	// mcpChannelID is the channel ask_kit talks in when the caller names
+	// none, so MCP conversations keep their own history. Named
+	// conversations are "mcp:<name>": a caller never speaks in a real Slack
+	// or Discord channel, whose routes and tools it was not given.
+	mcpChannelID = "mcp"
+	// mcpMaxMessageBytes bounds one JSON-RPC message from a client.
+	mcpMaxMessageBytes = 1 << 20
+	// mcpSSEBuffer is how many responses an SSE stream may fall behind
+	// before messages for it are refused.
+	mcpSSEBuffer = 16
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+)
+
+// jsonrpcMessage is a JSON-RPC 2.0 request, notification or response.
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// MCPServer exposes Kit to Model Context Protocol clients such as IDE
+// assistants and other agents, over stdio or HTTP. Every caller acts as a
+// Slack or Discord user and gets exactly the tools and data that user would
+// get in chat: ask_kit goes through AIService.Respond with its rate limits,
+// persona and redaction, and the function-calling tools keep their
+// Authorize rules.
+type MCPServer struct {
+	svc *AIService
+	// callers maps HTTP bearer tokens to the users they act as.
+	callers map[string]ToolContext
+
+	mu      sync.Mutex
+	streams map[string]*mcpStream // SSE sessions by ID
+}
+
+// mcpStream is an open SSE connection and who opened it. ctx ends when the
+// connection closes or the server shuts down, canceling the work on its
+// messages.
+type mcpStream struct {
+	ctx      context.Context
+	caller   ToolContext
+	messages chan []byte
+}
+
+// NewMCPServer returns a server for svc. callers maps bearer tokens to users
+// for the HTTP transport, which refuses every request without one.
+func NewMCPServer(svc *AIService, callers map[string]ToolContext) *MCPServer {
+	return &MCPServer{svc: svc, callers: callers, streams: make(map[string]*mcpStream)}
+}
+
+// parseMCPCaller reads a "platform:userID" reference such as
+// "discord:123456789".
+func parseMCPCaller(ref string) (ToolContext, error) {
+	platform, userID := parseUserRef("", strings.TrimSpace(ref))
+	if platform == "" || userID == "" {
+		return ToolContext{}, fmt.Errorf("%q is not slack:<user ID> or discord:<user ID>", ref)
+	}
+	return ToolContext{Platform: platform, UserID: userID}, nil
+}
+
+// mcpCallersFromEnv reads MCP_TOKENS, a comma-separated list of
+// token=platform:userID pairs.
+func mcpCallersFromEnv() (map[string]ToolContext, error) {
+	callers := make(map[string]ToolContext)
+	for _, entry := range splitList(os.Getenv("MCP_TOKENS")) {
+		token, ref, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(token) == "" {
+			return nil, errors.New("MCP_TOKENS entries must look like token=discord:123")
+		}
+		caller, err := parseMCPCaller(ref)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_TOKENS: %w", err)
+		}
+		callers[strings.TrimSpace(token)] = caller
+	}
+	return callers, nil
+}
+
+// tools lists what caller may use: Kit's own MCP tools, then the
+// function-calling tools they are authorized for.
+func (s *MCPServer) tools(caller ToolContext) []Tool {
+	return append([]Tool{s.askKitTool(), s.sessionsTool()}, s.svc.tools.forContext(caller)...)
+}
+
+// askKitTool asks Kit a question as the caller.
+func (s *MCPServer) askKitTool() Tool {
+	return Tool{
+		Name:        "ask_kit",
+		Description: "Ask Kit, the camp community assistant, a question. Kit remembers the conversation per channel.",
+		Params: []ToolParam{
+			{Name: "message", Type: "string", Description: "The question or message for Kit", Required: true},
+			{Name: "channel", Type: "string", Description: "Name of the conversation to continue (default: a conversation just for MCP)"},
+		},
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var params struct {
+				Message string `json:"message"`
+				Channel string `json:"channel"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil || strings.TrimSpace(params.Message) == "" {
+				return "", errors.New("message is required")
+			}
+			channel := mcpChannelID
+			if params.Channel != "" {
+				channel += ":" + params.Channel
+			}
+			reply := s.svc.Respond(ctx, ChatRequest{
+				Platform:  tc.Platform,
+				UserID:    tc.UserID,
+				ChannelID: channel,
+				Message:   params.Message,
+			})
+			if reply == "" {
+				return "", errors.New("Kit has no answer right now")
+			}
+			return reply, nil
+		},
+	}
+}
+
+// sessionsTool returns the caller's stored conversations. Admins may look
+// up any user's, as with forget user.
+func (s *MCPServer) sessionsTool() Tool {
+	return Tool{
+		Name:        "get_sessions",
+		Description: "Look up stored conversations with Kit: your own, or any user's for admins.",
+		Params: []ToolParam{
+			{Name: "channel", Type: "string", Description: "Only the conversation in this channel (\"mcp\" for ask_kit's default, \"mcp:<name>\" for a named one)"},
+			{Name: "user", Type: "string", Description: "Admins only: user ID or platform:userID to look up"},
+		},
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var params struct {
+				Channel string `json:"channel"`
+				User    string `json:"user"`
+			}
+			if len(args) > 0 {
+				if err := json.Unmarshal(args, &params); err != nil {
+					return "", errors.New("invalid arguments")
+				}
+			}
+			platform, userID := tc.Platform, tc.UserID
+			if params.User != "" {
+				platform, userID = parseUserRef(tc.Platform, params.User)
+				if (platform != tc.Platform || userID != tc.UserID) && !isAdmin(tc.UserID) {
+					return "", errors.New("only Kit admins can look up another user's conversations")
+				}
+			}
+			sessions, err := s.svc.UserSessions(platform, userID)
+			if err != nil {
+				return "", err
+			}
+			if params.Channel != "" {
+				sessions = slices.DeleteFunc(sessions, func(session *Session) bool { return session.ChannelID != params.Channel })
+			}
+			if sessions == nil {
+				sessions = []*Session{}
+			}
+			data, err := json.Marshal(sessions)
+			return string(data), err
+		},
+	}
+}
+
+// handle answers one JSON-RPC message, or a batch of them, from caller. It
+// returns nil when there is nothing to send back (notifications).
+func (s *MCPServer) handle(ctx context.Context, caller ToolContext, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+			return mustMarshal(jsonrpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{jsonrpcParseError, "invalid batch"}})
+		}
+		var responses []json.RawMessage
+		for _, message := range batch {
+			if response := s.handle(ctx, caller, message); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return mustMarshal(responses)
+	}
+
+	var req jsonrpcMessage
+	if err := json.Unmarshal(data, &req); err != nil {
+		return mustMarshal(jsonrpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{jsonrpcParseError, "parse error"}})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if req.ID == nil {
+			return nil // a response to nothing we sent, or junk
+		}
+		return mustMarshal(jsonrpcMessage{JSONRPC: "2.0", ID: req.ID, Error: &jsonrpcError{jsonrpcInvalidRequest, "invalid request"}})
+	}
+	result, rpcErr := s.call(ctx, caller, req.Method, req.Params)
+	if req.ID == nil {
+		return nil // notification
+	}
+	response := jsonrpcMessage{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+	if rpcErr == nil && result == nil {
+		response.Result = struct{}{}
+	}
+	return mustMarshal(response)
+}
+
+// call runs one MCP method.
+func (s *MCPServer) call(ctx context.Context, caller ToolContext, method string, params json.RawMessage) (any, *jsonrpcError) {
+	switch method {
+	case "initialize":
+		var init struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(params, &init)
+		version := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, init.ProtocolVersion) {
+			version = init.ProtocolVersion
+		}
+		log.Printf("🔌 MCP client connected as %s user %s (protocol %s)", caller.Platform, caller.UserID, version)
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+			"serverInfo":      map[string]string{"name": "kit", "version": "1.0"},
+			"instructions":    "Kit is the camp community assistant. Use ask_kit for questions; the other tools return data directly.",
+		}, nil
+
+	case "ping":
+		return nil, nil
+
+	case "tools/list":
+		tools := s.tools(caller)
+		list := make([]map[string]any, 0, len(tools))
+		for _, tool := range tools {
+			list = append(list, map[string]any{"name": tool.Name, "description": tool.Description, "inputSchema": tool.jsonSchema()})
+		}
+		return map[string]any{"tools": list}, nil
+
+	case "tools/call":
+		var call struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &call); err != nil || call.Name == "" {
+			return nil, &jsonrpcError{jsonrpcInvalidParams, "tools/call needs a tool name"}
+		}
+		text, err := s.callTool(ctx, caller, call.Name, call.Arguments)
+		if errors.Is(err, errMCPUnknownTool) {
+			return nil, &jsonrpcError{jsonrpcInvalidParams, fmt.Sprintf("unknown tool %q", call.Name)}
+		}
+		result := map[string]any{"isError": err != nil}
+		if err != nil {
+			text = err.Error()
+		}
+		result["content"] = []map[string]string{{"type": "text", "text": text}}
+		return result, nil
+
+	default:
+		if strings.HasPrefix(method, "notifications/") {
+			return nil, nil
+		}
+		return nil, &jsonrpcError{jsonrpcMethodNotFound, fmt.Sprintf("method %q not found", method)}
+	}
+}
+
+// errMCPUnknownTool is returned for tools that don't exist or that the
+// caller may not use; the two look the same so tools aren't disclosed.
+var errMCPUnknownTool = errors.New("unknown tool")
+
+// callTool runs a tool caller is authorized for, with the same time limit
+// as tools called by a model. ask_kit is bounded by the
+// assistant's own request timeout instead.
+func (s *MCPServer) callTool(ctx context.Context, caller ToolContext, name string, args json.RawMessage) (string, error) {
+	tools := s.tools(caller)
+	i := slices.IndexFunc(tools, func(tool Tool) bool { return tool.Name == name })
+	if i < 0 {
+		log.Printf("🔒 MCP tool %s unavailable to %s user %s", name, caller.Platform, caller.UserID)
+		return "", errMCPUnknownTool
+	}
+	log.Printf("🔧 MCP tool %s called for %s user %s", name, caller.Platform, caller.UserID)
+	if name != "ask_kit" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tools[i].timeout())
+		defer cancel()
+	}
+	return tools[i].Run(ctx, caller, args)
+}
+
+func mustMarshal(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Only our own response types are marshaled
+		panic(err)
+	}
+	return data
+}
+
+// ServeStdio speaks MCP over newline-delimited JSON on r and w until r ends
+// or ctx is canceled. Requests run concurrently, so a ping isn't stuck
+// behind a slow ask_kit.
+func (s *MCPServer) ServeStdio(ctx context.Context, caller ToolContext, r io.Reader, w io.Writer) error {
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReaderSize(r, 64*1024)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			return err
+		case line := <-lines:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response := s.handle(ctx, caller, line)
+				if response == nil {
+					return
+				}
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				if _, err := w.Write(append(response, '\n')); err != nil {
+					log.Printf("⚠️  Failed to write MCP response: %v", err)
+				}
+			}()
+		}
+	}
+}
+
+// Handler serves MCP over HTTP: POST /mcp answers JSON-RPC directly
+// (streamable HTTP without server-initiated messages), and GET /sse with
+// POST /messages is the older SSE transport. Every request needs a bearer
+// token from MCP_TOKENS.
+func (s *MCPServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /mcp", s.authenticated(s.servePost))
+	mux.HandleFunc("GET /sse", s.authenticated(s.serveSSE))
+	mux.HandleFunc("POST /messages", s.authenticated(s.serveSSEMessage))
+	return mux
+}
+
+// authenticated resolves the bearer token to a caller before calling next.
+func (s *MCPServer) authenticated(next func(http.ResponseWriter, *http.Request, ToolContext)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for known, caller := range s.callers {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+					next(w, r, caller)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kit-mcp"`)
+		http.Error(w, "missing or unknown bearer token", http.StatusUnauthorized)
+	}
+}
+
+func (s *MCPServer) servePost(w http.ResponseWriter, r *http.Request, caller ToolContext) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, mcpMaxMessageBytes))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	response := s.handle(r.Context(), caller, body)
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+// serveSSE opens a stream, tells the client where to post its messages and
+// relays the responses until the client goes away.
+func (s *MCPServer) serveSSE(w http.ResponseWriter, r *http.Request, caller ToolContext) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, "could not start session", http.StatusInternalServerError)
+		return
+	}
+	sessionID := hex.EncodeToString(id)
+	stream := &mcpStream{ctx: r.Context(), caller: caller, messages: make(chan []byte, mcpSSEBuffer)}
+	s.mu.Lock()
+	s.streams[sessionID] = stream
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, sessionID)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "event: endpoint\ndata: /messages?sessionId=%s\n\n", sessionID)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case message := <-stream.messages:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", message)
+		}
+		flusher.Flush()
+	}
+}
+
+// serveSSEMessage accepts a message for an SSE session; the response goes
+// out on the session's stream.
+func (s *MCPServer) serveSSEMessage(w http.ResponseWriter, r *http.Request, caller ToolContext) {
+	s.mu.Lock()
+	stream := s.streams[r.URL.Query().Get("sessionId")]
+	s.mu.Unlock()
+	// A token may only post to its own streams
+	if stream == nil || stream.caller != caller {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, mcpMaxMessageBytes))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	go func() {
+		// The request context ends with this handler; the answer may take
+		// as long as an ask_kit does, but no longer than the stream it
+		// goes out on
+		response := s.handle(stream.ctx, caller, body)
+		if response == nil {
+			return
+		}
+		if stream.ctx.Err() != nil {
+			log.Printf("⚠️  MCP stream for %s user %s closed before its response was ready", caller.Platform, caller.UserID)
+			return
+		}
+		select {
+		case stream.messages <- response:
+		default:
+			log.Printf("⚠️  MCP stream for %s user %s is not keeping up; response dropped", caller.Platform, caller.UserID)
+		}
+	}()
+}
+
+// ListenAndServe serves the HTTP transport on addr until ctx is canceled.
+func (s *MCPServer) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Shutdown doesn't cancel requests in flight; this ends SSE streams
+		// and the messages they are answering
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("🔌 MCP server listening on %s (%d tokens)", addr, len(s.callers))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// startMCPServerFromEnv serves MCP over HTTP on MCP_ADDR alongside the chat
+// adapters, when it is set and MCP_TOKENS names at least one caller.
+func startMCPServerFromEnv(ctx context.Context, svc *AIService) {
+	addr := os.Getenv("MCP_ADDR")
+	if addr == "" {
+		return
+	}
+	callers, err := mcpCallersFromEnv()
+	switch {
+	case err != nil:
+		log.Printf("❌ MCP server not started: %v", err)
+		return
+	case len(callers) == 0:
+		log.Println("❌ MCP server not started: MCP_ADDR is set but MCP_TOKENS is empty")
+		return
+	}
+	go func() {
+		if err := NewMCPServer(svc, callers).ListenAndServe(ctx, addr); err != nil {
+			log.Printf("❌ MCP server stopped: %v", err)
+		}
+	}()
+}
+
+// runMCPStdio implements the bot's "mcp" mode: Kit serves MCP on standard
+// input and output for a client that started it, without the chat
+// adapters. The client acts as MCP_STDIO_USER; without one it only gets the
+// tools open to everyone. It returns the exit status.
+func runMCPStdio() int {
+	caller := ToolContext{Platform: "mcp", UserID: "local"}
+	if ref := os.Getenv("MCP_STDIO_USER"); ref != "" {
+		var err error
+		if caller, err = parseMCPCaller(ref); err != nil {
+			log.Printf("❌ MCP_STDIO_USER: %v", err)
+			return 2
+		}
+	}
+	globalAdminIDs = splitList(os.Getenv("ADMIN_USER_IDS"))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	camp := campClientFromEnv()
+	store := newSessionStoreFromEnv()
+	svc := newAIServiceFromEnv(aiClientsFromEnv(), store, camp)
+	globalAIService = svc
+	registerTools(svc, camp, nil)
+	clients := connectMCPServersFromEnv(ctx, svc)
+	defer closeMCPClients(clients)
+
+	log.Printf("🔌 MCP server on stdio as %s user %s", caller.Platform, caller.UserID)
+	err := NewMCPServer(svc, nil).ServeStdio(ctx, caller, os.Stdin, os.Stdout)
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("⚠️  Failed to close session store: %v", err)
+		}
+	}
+	if err != nil {
+		log.Printf("❌ MCP stdio: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,768 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// mcpConnectTimeout bounds starting a server, the handshake and tool
+	// discovery.
+	mcpConnectTimeout = 30 * time.Second
+	// maxMCPResponseBytes bounds one message from an MCP server.
+	maxMCPResponseBytes = 4 << 20
+	// maxMCPToolName is the longest tool name every provider accepts.
+	maxMCPToolName = 64
+)
+
+// mcpInheritedEnv are the only variables of Kit's environment passed to MCP
+// server processes, so they never see Kit's tokens and API keys. Anything
+// else they need goes in their "env".
+var mcpInheritedEnv = []string{"PATH", "HOME", "TMPDIR", "LANG"}
+
+// MCPClientConfig lists the external MCP servers whose tools Kit may use,
+// and where; see config/mcp-servers.example.json.
+type MCPClientConfig struct {
+	Servers []MCPServerConfig `json:"servers"`
+	// Channels maps channel IDs to the tools usable there, as "server/tool"
+	// or "server/*". "*" applies to every channel not listed; without it,
+	// other channels get no MCP tools.
+	Channels map[string][]string `json:"channels"`
+	// AuditLog is a file that gets a JSON line for every tool call, in
+	// addition to the log.
+	AuditLog string `json:"audit_log"`
+}
+
+// MCPServerConfig is one MCP server: a command Kit starts and talks to over
+// stdio, or a streamable HTTP endpoint. ${VAR} in env, url and headers is
+// replaced from Kit's environment, so secrets stay out of the file.
+type MCPServerConfig struct {
+	Name    string            `json:"name"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// Tools limits which of the server's tools are offered; all when empty.
+	Tools   []string `json:"tools"`
+	Timeout string   `json:"timeout"` // Go duration per call; 20s by default
+
+	timeout time.Duration
+}
+
+// LoadMCPClientConfig reads and checks the MCP server list.
+func LoadMCPClientConfig(path string) (*MCPClientConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return nil, err
+	}
+	var config MCPClientConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i := range config.Servers {
+		server := &config.Servers[i]
+		switch {
+		case server.Name == "" || strings.ContainsAny(server.Name, "/ "):
+			return nil, fmt.Errorf("server %d needs a name without spaces or slashes", i+1)
+		case seen[server.Name]:
+			return nil, fmt.Errorf("server %q is listed twice", server.Name)
+		case (server.Command == "") == (server.URL == ""):
+			return nil, fmt.Errorf("server %q needs either a command or a url", server.Name)
+		}
+		seen[server.Name] = true
+		server.timeout = toolTimeout
+		if server.Timeout != "" {
+			if server.timeout, err = time.ParseDuration(server.Timeout); err != nil || server.timeout <= 0 {
+				return nil, fmt.Errorf("server %q: invalid timeout %q", server.Name, server.Timeout)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// allows reports whether server's tool may be used in channelID.
+func (c *MCPClientConfig) allows(channelID, server, tool string) bool {
+	patterns, ok := c.Channels[channelID]
+	if !ok {
+		patterns = c.Channels["*"]
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == server+"/*" || pattern == server+"/"+tool {
+			return true
+		}
+	}
+	return false
+}
+
+// mcpConn carries JSON-RPC messages to one MCP server.
+type mcpConn interface {
+	// roundTrip sends message and returns the response with the given ID,
+	// or nil at once when id is 0 (a notification).
+	roundTrip(ctx context.Context, id int64, message []byte) ([]byte, error)
+	Close() error
+}
+
+// mcpClient talks to one external MCP server.
+type mcpClient struct {
+	name   string
+	conn   mcpConn
+	nextID atomic.Int64
+}
+
+// mcpToolInfo is a tool as a server lists it.
+type mcpToolInfo struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// connectMCPServer starts or dials the server, completes the MCP handshake
+// and lists its tools.
+func connectMCPServer(ctx context.Context, cfg MCPServerConfig) (*mcpClient, []mcpToolInfo, error) {
+	var conn mcpConn
+	var err error
+	if cfg.Command != "" {
+		conn, err = startMCPStdio(cfg)
+	} else {
+		conn = newMCPHTTPConn(cfg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	client := &mcpClient{name: cfg.Name, conn: conn}
+	tools, err := client.handshake(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return client, tools, nil
+}
+
+func (c *mcpClient) handshake(ctx context.Context) ([]mcpToolInfo, error) {
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	err := c.request(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersions[0],
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "kit", "version": "1.0"},
+	}, &init)
+	if err != nil {
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	if err := c.notify(ctx, "notifications/initialized"); err != nil {
+		return nil, err
+	}
+	log.Printf("🔌 MCP server %s connected (%s %s, protocol %s)", c.name, init.ServerInfo.Name, init.ServerInfo.Version, init.ProtocolVersion)
+
+	var tools []mcpToolInfo
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Tools      []mcpToolInfo `json:"tools"`
+			NextCursor string        `json:"nextCursor"`
+		}
+		if err := c.request(ctx, "tools/list", params, &page); err != nil {
+			return nil, fmt.Errorf("tools/list: %w", err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// request calls method and decodes its result into result.
+func (c *mcpClient) request(ctx context.Context, method string, params, result any) error {
+	id := c.nextID.Add(1)
+	message, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	raw, err := c.conn.roundTrip(ctx, id, message)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *jsonrpcError   `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return fmt.Errorf("bad response from %s: %w", c.name, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", c.name, response.Error.Message, response.Error.Code)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+func (c *mcpClient) notify(ctx context.Context, method string) error {
+	message, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method})
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.roundTrip(ctx, 0, message)
+	return err
+}
+
+// callTool runs a tool and returns its text content. A result the server
+// flags as an error is returned as one.
+func (c *mcpClient) callTool(ctx context.Context, name string, args json.RawMessage) (string, error) {
+	var result struct {
+		Content []struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			Resource struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"resource"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := c.request(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &result); err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(result.Content))
+	for _, content := range result.Content {
+		switch {
+		case content.Type == "text":
+			parts = append(parts, content.Text)
+		case content.Type == "resource" && content.Resource.Text != "":
+			parts = append(parts, content.Resource.Text)
+		case content.Type == "resource":
+			parts = append(parts, "[resource "+content.Resource.URI+"]")
+		default:
+			parts = append(parts, "["+content.Type+" omitted]")
+		}
+	}
+	text := strings.Join(parts, "\n")
+	if result.IsError {
+		return "", errors.New(text)
+	}
+	return text, nil
+}
+
+func (c *mcpClient) Close() error {
+	return c.conn.Close()
+}
+
+// mcpStdioConn runs an MCP server as a child process speaking
+// newline-delimited JSON-RPC on its stdin and stdout.
+type mcpStdioConn struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[int64]chan []byte
+	exited  chan struct{} // closed once the server's output ends
+}
+
+func startMCPStdio(cfg MCPServerConfig) (*mcpStdioConn, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...) // #nosec G204 -- operator-configured server
+	for _, name := range mcpInheritedEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	for name, value := range cfg.Env {
+		cmd.Env = append(cmd.Env, name+"="+os.ExpandEnv(value))
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", cfg.Command, err)
+	}
+
+	c := &mcpStdioConn{name: cfg.Name, cmd: cmd, stdin: stdin, pending: make(map[int64]chan []byte), exited: make(chan struct{})}
+	go func() {
+		lines := bufio.NewScanner(stderr)
+		for lines.Scan() {
+			log.Printf("🔌 [%s] %s", cfg.Name, lines.Text())
+		}
+	}()
+	go c.readLoop(stdout)
+	return c, nil
+}
+
+// readLoop hands each response to the request waiting for it and answers
+// the server's pings. Other requests from the server are refused: Kit
+// offers no sampling or roots.
+func (c *mcpStdioConn) readLoop(stdout io.Reader) {
+	defer close(c.exited)
+	reader := bufio.NewReaderSize(stdout, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			c.dispatch(line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *mcpStdioConn) dispatch(line []byte) {
+	var message struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.Unmarshal(line, &message); err != nil {
+		log.Printf("⚠️  MCP server %s wrote something that isn't JSON-RPC: %.200s", c.name, line)
+		return
+	}
+	switch {
+	case message.Method != "" && message.ID != nil:
+		reply := jsonrpcMessage{JSONRPC: "2.0", ID: message.ID, Result: struct{}{}}
+		if message.Method != "ping" {
+			reply = jsonrpcMessage{JSONRPC: "2.0", ID: message.ID, Error: &jsonrpcError{jsonrpcMethodNotFound, "not supported by Kit"}}
+		}
+		_ = c.write(mustMarshal(reply))
+	case message.Method != "":
+		// A notification, such as a log message or list change
+	default:
+		var id int64
+		if json.Unmarshal(message.ID, &id) != nil {
+			return
+		}
+		c.mu.Lock()
+		waiting := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if waiting != nil {
+			waiting <- line
+		}
+	}
+}
+
+func (c *mcpStdioConn) write(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.stdin.Write(append(message, '\n'))
+	return err
+}
+
+func (c *mcpStdioConn) roundTrip(ctx context.Context, id int64, message []byte) ([]byte, error) {
+	var response chan []byte
+	if id != 0 {
+		response = make(chan []byte, 1)
+		c.mu.Lock()
+		c.pending[id] = response
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+		}()
+	}
+	if err := c.write(message); err != nil {
+		return nil, fmt.Errorf("MCP server %s: %w", c.name, err)
+	}
+	if id == 0 {
+		return nil, nil
+	}
+	select {
+	case raw := <-response:
+		return raw, nil
+	case <-c.exited:
+		return nil, fmt.Errorf("MCP server %s exited", c.name)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close ends the server by closing its input, as the stdio transport
+// specifies, and kills it if it doesn't exit promptly.
+func (c *mcpStdioConn) Close() error {
+	c.stdin.Close()
+	select {
+	case <-c.exited:
+	case <-time.After(5 * time.Second):
+		_ = c.cmd.Process.Kill()
+	}
+	_ = c.cmd.Wait()
+	return nil
+}
+
+// mcpHTTPConn posts JSON-RPC messages to a streamable HTTP MCP endpoint,
+// which may answer with JSON or a short SSE stream.
+type mcpHTTPConn struct {
+	name       string
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	sessionID string // Mcp-Session-Id, once the server assigns one
+}
+
+func newMCPHTTPConn(cfg MCPServerConfig) *mcpHTTPConn {
+	headers := make(map[string]string, len(cfg.Headers))
+	for name, value := range cfg.Headers {
+		headers[name] = os.ExpandEnv(value)
+	}
+	return &mcpHTTPConn{name: cfg.Name, url: os.ExpandEnv(cfg.URL), headers: headers, httpClient: &http.Client{}}
+}
+
+func (c *mcpHTTPConn) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	c.mu.Lock()
+	if c.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", c.sessionID)
+	}
+	c.mu.Unlock()
+	return req, nil
+}
+
+func (c *mcpHTTPConn) roundTrip(ctx context.Context, id int64, message []byte) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := c.httpClient.Do(req) // #nosec G107 -- operator-configured URL
+	if err != nil {
+		return nil, fmt.Errorf("MCP server %s: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+	if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		c.mu.Lock()
+		c.sessionID = sessionID
+		c.mu.Unlock()
+	}
+	body := io.LimitReader(resp.Body, maxMCPResponseBytes)
+	if resp.StatusCode >= 300 {
+		text, _ := io.ReadAll(io.LimitReader(body, 300))
+		return nil, fmt.Errorf("MCP server %s: HTTP %d: %s", c.name, resp.StatusCode, strings.TrimSpace(string(text)))
+	}
+	if id == 0 {
+		return nil, nil
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return readMCPEventStream(body, id)
+	}
+	return io.ReadAll(body)
+}
+
+// readMCPEventStream returns the first event carrying the response to id;
+// the server may send its own notifications first.
+func readMCPEventStream(r io.Reader, id int64) ([]byte, error) {
+	events := bufio.NewScanner(r)
+	events.Buffer(make([]byte, 64*1024), maxMCPResponseBytes)
+	var data []byte
+	matches := func() bool {
+		var message struct {
+			ID int64 `json:"id"`
+		}
+		return len(data) > 0 && json.Unmarshal(data, &message) == nil && message.ID == id
+	}
+	for events.Scan() {
+		line := events.Bytes()
+		if rest, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimSpace(rest)...)
+			continue
+		}
+		if len(line) > 0 {
+			continue // event name, ID or comment
+		}
+		if matches() {
+			return data, nil
+		}
+		data = nil
+	}
+	if err := events.Err(); err != nil {
+		return nil, err
+	}
+	if matches() {
+		return data, nil
+	}
+	return nil, errors.New("event stream ended without a response")
+}
+
+// Close ends the session on the server, if it started one.
+func (c *mcpHTTPConn) Close() error {
+	c.mu.Lock()
+	sessionID := c.sessionID
+	c.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := c.newRequest(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req) // #nosec G107 -- operator-configured URL
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// mcpToolNameInvalid matches what providers don't accept in tool names.
+var mcpToolNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// mcpToolName names a server's tool for providers: "server__tool", within
+// the characters and length every provider accepts.
+func mcpToolName(server, tool string) string {
+	name := mcpToolNameInvalid.ReplaceAllString(server+"__"+tool, "_")
+	if len(name) > maxMCPToolName {
+		name = name[:maxMCPToolName]
+	}
+	return name
+}
+
+// mcpAuditRecord is one line of the audit log.
+type mcpAuditRecord struct {
+	Time        time.Time       `json:"time"`
+	Server      string          `json:"server"`
+	Tool        string          `json:"tool"`
+	Platform    string          `json:"platform"`
+	UserID      string          `json:"user_id"`
+	ChannelID   string          `json:"channel_id"`
+	Arguments   json.RawMessage `json:"arguments"`
+	DurationMS  int64           `json:"duration_ms"`
+	Error       string          `json:"error,omitempty"`
+	ResultChars int             `json:"result_chars"`
+}
+
+// mcpAuditLog records every call of an external tool: who, where, with
+// which arguments and how it went. Results aren't kept, only their size.
+type mcpAuditLog struct {
+	mu   sync.Mutex
+	path string // JSON lines; "" to log only
+}
+
+// forgetUser replaces the user's ID in the log file with forgottenUserID
+// and returns how many records it changed. Lines it can't read are kept.
+func (l *mcpAuditLog) forgetUser(platform, userID string) (int, error) {
+	if l == nil || l.path == "" {
+		return 0, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var out bytes.Buffer
+	changed := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var rec mcpAuditRecord
+		if json.Unmarshal(line, &rec) == nil && rec.Platform == platform && rec.UserID == userID {
+			rec.UserID = forgottenUserID
+			if updated, err := json.Marshal(rec); err == nil {
+				line = append(updated, '\n')
+				changed++
+			}
+		}
+		out.Write(line)
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o600); err != nil {
+		return 0, err
+	}
+	return changed, os.Rename(tmp, l.path)
+}
+
+func (l *mcpAuditLog) record(rec mcpAuditRecord) {
+	status := "ok"
+	if rec.Error != "" {
+		status = "failed: " + rec.Error
+	}
+	log.Printf("🧾 MCP %s/%s for %s user %s in %q (%dms, %s): %.500s",
+		rec.Server, rec.Tool, rec.Platform, rec.UserID, rec.ChannelID, rec.DurationMS, status, rec.Arguments)
+	if l.path == "" {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- operator-configured path
+	if err != nil {
+		log.Printf("❌ Failed to write MCP audit log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("❌ Failed to write MCP audit log: %v", err)
+	}
+}
+
+// mcpTool wraps a server's tool for the registry: offered only where the
+// channel allowlist permits it, bounded by the server's timeout and audited.
+func mcpTool(client *mcpClient, info mcpToolInfo, server MCPServerConfig, config *MCPClientConfig, audit *mcpAuditLog) Tool {
+	schema := info.InputSchema
+	if schema == nil {
+		schema = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return Tool{
+		Name:        mcpToolName(server.Name, info.Name),
+		Description: info.Description,
+		InputSchema: schema,
+		Timeout:     server.timeout,
+		Authorize: func(tc ToolContext) bool {
+			return config.allows(tc.ChannelID, server.Name, info.Name)
+		},
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			if len(bytes.TrimSpace(args)) == 0 {
+				args = json.RawMessage("{}")
+			}
+			started := time.Now()
+			result, err := client.callTool(ctx, info.Name, args)
+			rec := mcpAuditRecord{
+				Time:        started.UTC(),
+				Server:      server.Name,
+				Tool:        info.Name,
+				Platform:    tc.Platform,
+				UserID:      tc.UserID,
+				ChannelID:   tc.ChannelID,
+				Arguments:   args,
+				DurationMS:  time.Since(started).Milliseconds(),
+				ResultChars: len(result),
+			}
+			if !json.Valid(rec.Arguments) {
+				rec.Arguments, _ = json.Marshal(string(args))
+			}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit.record(rec)
+			return result, err
+		},
+	}
+}
+
+// ConnectMCPServers connects to every configured server at once and
+// registers the tools they offer with svc, in configuration order. Servers
+// that fail to connect are logged and skipped, as are tools whose name is
+// already taken by a built-in tool or an earlier server's (names are cut to
+// maxMCPToolName characters, so long ones can clash). The returned clients
+// must be closed on shutdown.
+func ConnectMCPServers(ctx context.Context, svc *AIService, config *MCPClientConfig) []*mcpClient {
+	audit := &mcpAuditLog{path: config.AuditLog}
+	svc.mcpAudit = audit
+	connected := make([]*mcpClient, len(config.Servers))
+	offered := make([][]mcpToolInfo, len(config.Servers))
+	var wg sync.WaitGroup
+	for i, server := range config.Servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, mcpConnectTimeout)
+			defer cancel()
+			client, tools, err := connectMCPServer(ctx, server)
+			if err != nil {
+				log.Printf("❌ MCP server %s unavailable: %v", server.Name, err)
+				return
+			}
+			connected[i], offered[i] = client, tools
+		}()
+	}
+	wg.Wait()
+
+	var clients []*mcpClient
+	for i, server := range config.Servers {
+		client, tools := connected[i], offered[i]
+		if client == nil {
+			continue
+		}
+		registered := 0
+		for _, info := range tools {
+			if len(server.Tools) > 0 && !slices.Contains(server.Tools, info.Name) {
+				continue
+			}
+			tool := mcpTool(client, info, server, config, audit)
+			if !svc.tools.registerNew(tool) {
+				log.Printf("⚠️  MCP server %s: skipping tool %s, the name %s is already taken", server.Name, info.Name, tool.Name)
+				continue
+			}
+			registered++
+		}
+		log.Printf("🔧 MCP server %s: %d of %d tools registered", server.Name, registered, len(tools))
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// connectMCPServersFromEnv loads MCP_SERVERS_CONFIG, if set, and connects
+// to the servers it lists.
+func connectMCPServersFromEnv(ctx context.Context, svc *AIService) []*mcpClient {
+	path := os.Getenv("MCP_SERVERS_CONFIG")
+	if path == "" {
+		return nil
+	}
+	config, err := LoadMCPClientConfig(path)
+	if err != nil {
+		log.Printf("❌ Failed to load MCP servers: %v", err)
+		return nil
+	}
+	return ConnectMCPServers(ctx, svc, config)
+}
+
+// closeMCPClients disconnects from the MCP servers, stopping the ones Kit
+// started.
+func closeMCPClients(clients []*mcpClient) {
+	for _, client := range clients {
+		if err := client.Close(); err != nil {
+			log.Printf("⚠️  Failed to close MCP server %s: %v", client.name, err)
+		}
+	}
+}
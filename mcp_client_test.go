@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLocalMCPServer is the MCP server the client tests talk to: echo, add,
+// fail and a sleep that outlasts the tests' 500ms timeout.
+func newLocalMCPServer() *MCPServer {
+	svc := NewAIService(nil, nil, providerFunc{name: "echo"})
+	svc.RegisterTool(Tool{
+		Name:        "echo",
+		Description: "Repeats the text.",
+		Params:      []ToolParam{{Name: "text", Type: "string", Required: true}},
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var in struct{ Text string }
+			err := json.Unmarshal(args, &in)
+			return in.Text, err
+		},
+	})
+	svc.RegisterTool(Tool{
+		Name: "add",
+		Params: []ToolParam{
+			{Name: "a", Type: "number", Required: true},
+			{Name: "b", Type: "number", Required: true},
+		},
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var in struct{ A, B float64 }
+			if err := json.Unmarshal(args, &in); err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(mustMarshal(in.A + in.B))), nil
+		},
+	})
+	svc.RegisterTool(Tool{
+		Name: "fail",
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			return "", errors.New("out of marshmallows")
+		},
+	})
+	svc.RegisterTool(Tool{
+		Name:    "sleep",
+		Timeout: time.Minute,
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			select {
+			case <-time.After(2 * time.Second):
+				return "awake", nil
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		},
+	})
+	return NewMCPServer(svc, map[string]ToolContext{"local-token": {Platform: "mcp", UserID: "tests"}})
+}
+
+// TestMCPTestServerProcess is not a test: the stdio tests start the test
+// binary with KIT_MCP_TEST_SERVER=1 to run the local MCP server.
+func TestMCPTestServerProcess(t *testing.T) {
+	if os.Getenv("KIT_MCP_TEST_SERVER") != "1" {
+		t.Skip("helper process for the MCP client tests")
+	}
+	err := newLocalMCPServer().ServeStdio(context.Background(), ToolContext{Platform: "mcp", UserID: "local"}, os.Stdin, os.Stdout)
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// localStdioServer configures the test binary as a stdio MCP server.
+func localStdioServer(name string) MCPServerConfig {
+	return MCPServerConfig{
+		Name:    name,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestMCPTestServerProcess$"},
+		Env:     map[string]string{"KIT_MCP_TEST_SERVER": "1"},
+		Timeout: "500ms",
+	}
+}
+
+// loadMCPConfig writes config as JSON and loads it as an operator would.
+func loadMCPConfig(t *testing.T, config MCPClientConfig) *MCPClientConfig {
+	t.Helper()
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "mcp-servers.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMCPClientConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func toolNames(tools *ToolSet) string {
+	if tools == nil {
+		return ""
+	}
+	names := make([]string, 0, len(tools.Tools))
+	for _, tool := range tools.Tools {
+		names = append(names, tool.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMCPClientStdioAllowlistAndAudit(t *testing.T) {
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	local := localStdioServer("local")
+	local.Tools = []string{"echo", "add", "fail", "sleep"}
+	config := loadMCPConfig(t, MCPClientConfig{
+		Servers: []MCPServerConfig{local},
+		Channels: map[string][]string{
+			"ops":   {"local/*"},
+			"quiet": {},
+			"*":     {"local/echo"},
+		},
+		AuditLog: audit,
+	})
+	svc := NewAIService(nil, nil, providerFunc{name: "none"})
+	clients := ConnectMCPServers(context.Background(), svc, config)
+	defer closeMCPClients(clients)
+	if len(clients) != 1 {
+		t.Fatalf("connected to %d servers", len(clients))
+	}
+
+	ops := svc.toolSet(ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "ops"})
+	if got := toolNames(ops); got != "local__add,local__echo,local__fail,local__sleep" {
+		t.Fatalf("ops tools = %s", got)
+	}
+	if got := toolNames(svc.toolSet(ChatRequest{Platform: "slack", UserID: "u2", ChannelID: "general"})); got != "local__echo" {
+		t.Fatalf("default channel tools = %s", got)
+	}
+	if got := toolNames(svc.toolSet(ChatRequest{Platform: "slack", UserID: "u2", ChannelID: "quiet"})); got != "" {
+		t.Fatalf("quiet channel tools = %s", got)
+	}
+	if schema := ops.Tools[0].jsonSchema(); len(schema["required"].([]any)) != 2 {
+		t.Fatalf("discovered schema not kept: %v", schema)
+	}
+
+	ctx := context.Background()
+	if got := ops.Call(ctx, "local__add", json.RawMessage(`{"a":2,"b":40}`)); got != "42" {
+		t.Fatalf("add = %q", got)
+	}
+	if got := ops.Call(ctx, "local__fail", nil); got != "error: out of marshmallows" {
+		t.Fatalf("fail = %q", got)
+	}
+	started := time.Now()
+	if got := ops.Call(ctx, "local__sleep", nil); !strings.Contains(got, "deadline exceeded") || time.Since(started) > time.Second {
+		t.Fatalf("sleep = %q after %v", got, time.Since(started))
+	}
+	// The server is still usable after a call timed out
+	if got := ops.Call(ctx, "local__echo", json.RawMessage(`{"text":"still here"}`)); got != "still here" {
+		t.Fatalf("echo after timeout = %q", got)
+	}
+
+	data, err := os.ReadFile(audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 audit records:\n%s", data)
+	}
+	var first, failed mcpAuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Server != "local" || first.Tool != "add" || first.UserID != "u1" || first.ChannelID != "ops" || string(first.Arguments) != `{"a":2,"b":40}` || first.ResultChars != 2 {
+		t.Fatalf("audit record: %+v", first)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &failed); err != nil || failed.Error != "out of marshmallows" || string(failed.Arguments) != "{}" {
+		t.Fatalf("failed call audit record: %+v", failed)
+	}
+
+	// Forgetting the user anonymizes their calls
+	if report, err := svc.ForgetUser("discord", "u1"); err != nil || report.AuditRecords != 4 {
+		t.Fatalf("forget: %+v, %v", report, err)
+	}
+	data, _ = os.ReadFile(audit)
+	if strings.Contains(string(data), `"u1"`) || strings.Count(string(data), forgottenUserID) != 4 || !strings.Contains(string(data), `"server":"local","tool":"add"`) {
+		t.Fatalf("audit log not anonymized:\n%s", data)
+	}
+}
+
+func TestMCPClientHTTPServer(t *testing.T) {
+	server := httptest.NewServer(newLocalMCPServer().Handler())
+	defer server.Close()
+	t.Setenv("KIT_TEST_MCP_TOKEN", "local-token")
+	config := loadMCPConfig(t, MCPClientConfig{
+		Servers: []MCPServerConfig{
+			{Name: "remote", URL: server.URL + "/mcp", Headers: map[string]string{"Authorization": "Bearer ${KIT_TEST_MCP_TOKEN}"}, Tools: []string{"echo"}},
+			{Name: "locked", URL: server.URL + "/mcp"},
+		},
+		Channels: map[string][]string{"*": {"*"}},
+	})
+	svc := NewAIService(nil, nil, providerFunc{name: "none"})
+	clients := ConnectMCPServers(context.Background(), svc, config)
+	defer closeMCPClients(clients)
+
+	// "locked" has no token, so only remote's echo is registered
+	tools := svc.toolSet(ChatRequest{Platform: "discord", UserID: "u1"})
+	if len(clients) != 1 || toolNames(tools) != "remote__echo" {
+		t.Fatalf("%d clients, tools %s", len(clients), toolNames(tools))
+	}
+	if got := tools.Call(context.Background(), "remote__echo", json.RawMessage(`{"text":"over http"}`)); got != "over http" {
+		t.Fatalf("echo = %q", got)
+	}
+}
+
+func TestMCPClientSkipsClashingToolNames(t *testing.T) {
+	server := httptest.NewServer(newLocalMCPServer().Handler())
+	defer server.Close()
+	t.Setenv("KIT_TEST_MCP_TOKEN", "local-token")
+	auth := map[string]string{"Authorization": "Bearer ${KIT_TEST_MCP_TOKEN}"}
+	long := strings.Repeat("x", maxMCPToolName-2)
+	config := loadMCPConfig(t, MCPClientConfig{
+		Servers: []MCPServerConfig{
+			// Cut to 64 characters, echo and add are both "xxx…x__"
+			{Name: long, URL: server.URL + "/mcp", Headers: auth, Tools: []string{"echo", "add"}},
+			{Name: "remote", URL: server.URL + "/mcp", Headers: auth, Tools: []string{"echo", "add"}},
+		},
+		Channels: map[string][]string{"*": {"*"}},
+	})
+	svc := NewAIService(nil, nil, providerFunc{name: "none"})
+	svc.RegisterTool(Tool{Name: "remote__echo", Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+		return "built-in", nil
+	}})
+	clients := ConnectMCPServers(context.Background(), svc, config)
+	defer closeMCPClients(clients)
+
+	tools := svc.toolSet(ChatRequest{Platform: "discord", UserID: "u1"})
+	if got := toolNames(tools); got != "remote__add,remote__echo,"+long+"__" {
+		t.Fatalf("tools = %s", got)
+	}
+	if got := tools.Call(context.Background(), "remote__echo", json.RawMessage(`{"text":"hi"}`)); got != "built-in" {
+		t.Fatalf("MCP tool replaced a built-in: %q", got)
+	}
+	// The server lists add first, so it keeps the name
+	if got := tools.Call(context.Background(), long+"__", json.RawMessage(`{"a":1,"b":2}`)); got != "3" {
+		t.Fatalf("first of the clashing tools not kept: %q", got)
+	}
+}
+
+func TestRespondUsesMCPTools(t *testing.T) {
+	config := loadMCPConfig(t, MCPClientConfig{
+		Servers:  []MCPServerConfig{localStdioServer("local")},
+		Channels: map[string][]string{"*": {"local/add"}},
+	})
+	var offered string
+	calculator := providerFunc{name: "calculator", tools: func(ctx context.Context, message string, session *Session, tools *ToolSet) (Reply, error) {
+		offered = toolNames(tools)
+		return Reply{Text: "The total is " + tools.Call(ctx, "local__add", json.RawMessage(`{"a":19,"b":23}`))}, nil
+	}}
+	svc := NewAIService(nil, nil, calculator)
+	clients := ConnectMCPServers(context.Background(), svc, config)
+	defer closeMCPClients(clients)
+
+	reply := svc.Respond(context.Background(), ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "What is 19 + 23?"})
+	if reply != "The total is 42" || offered != "local__add" {
+		t.Fatalf("reply %q with tools %s", reply, offered)
+	}
+}
+
+func TestLoadMCPClientConfigValidation(t *testing.T) {
+	dir := t.TempDir()
+	for name, config := range map[string]string{
+		"no-name":   `{"servers":[{"command":"x"}]}`,
+		"slash":     `{"servers":[{"name":"a/b","command":"x"}]}`,
+		"duplicate": `{"servers":[{"name":"a","command":"x"},{"name":"a","url":"http://x"}]}`,
+		"both":      `{"servers":[{"name":"a","command":"x","url":"http://x"}]}`,
+		"neither":   `{"servers":[{"name":"a"}]}`,
+		"timeout":   `{"servers":[{"name":"a","command":"x","timeout":"soon"}]}`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMCPClientConfig(path); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	if got := mcpToolName("my.server", strings.Repeat("t", 80)); len(got) != maxMCPToolName || !strings.HasPrefix(got, "my_server__ttt") {
+		t.Fatalf("mcpToolName = %q", got)
+	}
+}
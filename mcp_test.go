@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestMCPServer serves an echo provider and a tool only discord:staff may
+// use.
+func newTestMCPServer(t *testing.T) *MCPServer {
+	t.Helper()
+	echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		return Reply{Text: "re: " + message}, nil
+	}}
+	svc := NewAIService(nil, nil, echo)
+	svc.RegisterTool(Tool{
+		Name:      "staff_only",
+		Authorize: func(tc ToolContext) bool { return tc.Platform == "discord" && tc.UserID == "staff" },
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			return `{"total":3}`, nil
+		},
+	})
+	useTestService(t, svc, "admin")
+	return NewMCPServer(svc, map[string]ToolContext{
+		"staff-token": {Platform: "discord", UserID: "staff"},
+		"guest-token": {Platform: "discord", UserID: "guest"},
+	})
+}
+
+// mcpCall sends one request and decodes the result into result.
+func mcpCall(t *testing.T, s *MCPServer, caller ToolContext, method, params string, result any) *jsonrpcError {
+	t.Helper()
+	raw := s.handle(context.Background(), caller, []byte(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":`+params+`}`))
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *jsonrpcError   `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		t.Fatalf("bad response %s: %v", raw, err)
+	}
+	if response.Error == nil && result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.Error
+}
+
+type mcpToolResult struct {
+	IsError bool `json:"isError"`
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+func TestMCPToolsFollowChatAuthorization(t *testing.T) {
+	s := newTestMCPServer(t)
+	staff, guest := ToolContext{Platform: "discord", UserID: "staff"}, ToolContext{Platform: "discord", UserID: "guest"}
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := mcpCall(t, s, guest, "initialize", `{"protocolVersion":"2024-11-05"}`, &init); err != nil || init.ProtocolVersion != "2024-11-05" {
+		t.Fatalf("initialize: %v %+v", err, init)
+	}
+	if raw := s.handle(context.Background(), guest, []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); raw != nil {
+		t.Fatalf("notification answered: %s", raw)
+	}
+
+	toolNames := func(caller ToolContext) string {
+		var list struct {
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		}
+		if err := mcpCall(t, s, caller, "tools/list", `{}`, &list); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, tool := range list.Tools {
+			names = append(names, tool.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	if got := toolNames(staff); got != "ask_kit,get_sessions,staff_only" {
+		t.Fatalf("staff tools = %s", got)
+	}
+	if got := toolNames(guest); got != "ask_kit,get_sessions" {
+		t.Fatalf("guest tools = %s", got)
+	}
+	if err := mcpCall(t, s, guest, "tools/call", `{"name":"staff_only"}`, nil); err == nil || err.Code != jsonrpcInvalidParams {
+		t.Fatalf("guest ran a staff tool: %+v", err)
+	}
+
+	var result mcpToolResult
+	if err := mcpCall(t, s, guest, "tools/call", `{"name":"ask_kit","arguments":{"message":"hello"}}`, &result); err != nil || result.IsError || result.Content[0].Text != "re: hello" {
+		t.Fatalf("ask_kit: %v %+v", err, result)
+	}
+	if err := mcpCall(t, s, guest, "tools/call", `{"name":"get_sessions","arguments":{"channel":"mcp"}}`, &result); err != nil || !strings.Contains(result.Content[0].Text, `"content":"re: hello"`) {
+		t.Fatalf("get_sessions: %v %+v", err, result)
+	}
+	// A named conversation never lands in a real channel
+	mcpCall(t, s, guest, "tools/call", `{"name":"ask_kit","arguments":{"message":"psst","channel":"123456789012345678"}}`, &result)
+	if err := mcpCall(t, s, guest, "tools/call", `{"name":"get_sessions","arguments":{"channel":"mcp:123456789012345678"}}`, &result); err != nil || !strings.Contains(result.Content[0].Text, "psst") {
+		t.Fatalf("named conversation: %v %+v", err, result)
+	}
+	if err := mcpCall(t, s, staff, "tools/call", `{"name":"get_sessions","arguments":{"user":"guest"}}`, &result); err != nil || !result.IsError {
+		t.Fatalf("non-admin read another user's sessions: %+v", result)
+	}
+	admin := ToolContext{Platform: "discord", UserID: "admin"}
+	if err := mcpCall(t, s, admin, "tools/call", `{"name":"get_sessions","arguments":{"user":"discord:guest"}}`, &result); err != nil || result.IsError || !strings.Contains(result.Content[0].Text, "hello") {
+		t.Fatalf("admin lookup: %+v", result)
+	}
+	if err := mcpCall(t, s, guest, "resources/list", `{}`, nil); err == nil || err.Code != jsonrpcMethodNotFound {
+		t.Fatalf("unknown method: %+v", err)
+	}
+}
+
+func TestMCPHTTPTransports(t *testing.T) {
+	server := httptest.NewServer(newTestMCPServer(t).Handler())
+	defer server.Close()
+	post := func(path, token, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := post("/mcp", "wrong", `{"jsonrpc":"2.0","id":1,"method":"ping"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unknown token: %d", resp.StatusCode)
+	}
+	resp := post("/mcp", "staff-token", `{"jsonrpc":"2.0","id":7,"method":"ping"}`)
+	var pong bytes.Buffer
+	pong.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || pong.String() != `{"jsonrpc":"2.0","id":7,"result":{}}` {
+		t.Fatalf("ping over POST: %d %s", resp.StatusCode, pong.String())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/sse", nil)
+	req.Header.Set("Authorization", "Bearer guest-token")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	events := bufio.NewReader(stream.Body)
+	nextData := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				return strings.TrimSpace(data)
+			}
+		}
+	}
+	endpoint := nextData()
+	if !strings.HasPrefix(endpoint, "/messages?sessionId=") {
+		t.Fatalf("endpoint event: %q", endpoint)
+	}
+	if resp := post(endpoint, "staff-token", `{"jsonrpc":"2.0","id":1,"method":"ping"}`); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("another token posted to the stream: %d", resp.StatusCode)
+	}
+	if resp := post(endpoint, "guest-token", `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"ask_kit","arguments":{"message":"hi"}}}`); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("message not accepted: %d", resp.StatusCode)
+	}
+	if message := nextData(); !strings.Contains(message, `"id":2`) || !strings.Contains(message, "re: hi") {
+		t.Fatalf("SSE response: %s", message)
+	}
+}
+
+func TestMCPSSEMessagesEndWithTheirStream(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	slow := providerFunc{name: "slow", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return Reply{}, ctx.Err()
+	}}
+	svc := NewAIService(nil, nil, slow)
+	useTestService(t, svc, "admin")
+	server := httptest.NewServer(NewMCPServer(svc, map[string]ToolContext{"token": {Platform: "discord", UserID: "u1"}}).Handler())
+	defer server.Close()
+
+	ctx, closeStream := context.WithCancel(context.Background())
+	defer closeStream()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/sse", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	events := bufio.NewReader(stream.Body)
+	endpoint, err := events.ReadString('\n')
+	for err == nil && !strings.HasPrefix(endpoint, "data: ") {
+		endpoint, err = events.ReadString('\n')
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post, _ := http.NewRequest(http.MethodPost, server.URL+strings.TrimSpace(strings.TrimPrefix(endpoint, "data: ")),
+		strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"ask_kit","arguments":{"message":"hi"}}}`))
+	post.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	<-started
+
+	// The client goes away while Kit is still answering
+	closeStream()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("answer kept running after its stream closed")
+	}
+}
+
+func TestMCPStdio(t *testing.T) {
+	s := newTestMCPServer(t)
+	input := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}` + "\n" +
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}` + "\n" +
+		`[{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"ask_kit","arguments":{"message":"hey"}}}]` + "\n")
+	var output bytes.Buffer
+	if err := s.ServeStdio(context.Background(), ToolContext{Platform: "mcp", UserID: "local"}, input, &output); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(output.String(), `"result":{}`) || !strings.Contains(output.String(), "re: hey") {
+		t.Fatalf("stdio output:\n%s", output.String())
+	}
+}
+
+func TestMCPCallersFromEnv(t *testing.T) {
+	t.Setenv("MCP_TOKENS", "abc=discord:123, def=slack:U1")
+	callers, err := mcpCallersFromEnv()
+	if err != nil || callers["abc"] != (ToolContext{Platform: "discord", UserID: "123"}) || callers["def"].Platform != "slack" {
+		t.Fatalf("callers = %+v, %v", callers, err)
+	}
+	t.Setenv("MCP_TOKENS", "abc=123")
+	if _, err := mcpCallersFromEnv(); err == nil {
+		t.Fatal("a caller without a platform was accepted")
+	}
+}
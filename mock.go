@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultMockReply is what the echo mock answers.
+const defaultMockReply = "🧪 Mock reply to: {{message}}"
+
+// MockProviderConfig declares a scripted provider for development and tests.
+// The first rule whose pattern matches the message decides the outcome;
+// otherwise Reply is used. "{{message}}" in a reply is replaced by the
+// message.
+type MockProviderConfig struct {
+	Name  string     `json:"name"`
+	Reply string     `json:"reply"`
+	Rules []MockRule `json:"rules"`
+}
+
+// MockRule scripts the answer to matching messages. A non-zero Status makes
+// the provider fail as if the API returned it, to exercise retries and the
+// fallback chain.
+type MockRule struct {
+	Match      string `json:"match"` // regular expression
+	Reply      string `json:"reply"`
+	Status     int    `json:"status"`
+	RetryAfter string `json:"retry_after"` // Go duration sent with Status
+	Delay      string `json:"delay"`       // Go duration to wait before answering
+
+	pattern    *regexp.Regexp
+	retryAfter time.Duration
+	delay      time.Duration
+}
+
+// compile checks the rule and parses its pattern and durations.
+func (r *MockRule) compile() error {
+	var err error
+	if r.pattern, err = regexp.Compile(r.Match); err != nil {
+		return fmt.Errorf("match %q: %w", r.Match, err)
+	}
+	for _, field := range []struct {
+		value string
+		into  *time.Duration
+	}{{r.RetryAfter, &r.retryAfter}, {r.Delay, &r.delay}} {
+		if field.value == "" {
+			continue
+		}
+		if *field.into, err = time.ParseDuration(field.value); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Match, err)
+		}
+	}
+	return nil
+}
+
+// LoadMockProviders reads a mock provider script; see
+// config/mock.example.json.
+func LoadMockProviders(path string) ([]MockProviderConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Providers []MockProviderConfig `json:"providers"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range file.Providers {
+		cfg := &file.Providers[i]
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("mock-%d", i+1)
+		}
+		for j := range cfg.Rules {
+			if err := cfg.Rules[j].compile(); err != nil {
+				return nil, fmt.Errorf("provider %q: %w", cfg.Name, err)
+			}
+		}
+	}
+	return file.Providers, nil
+}
+
+// newMockProvider builds a provider that answers from its script without any
+// network access. Replies stream a word at a time.
+func newMockProvider(cfg MockProviderConfig) Provider {
+	if cfg.Name == "" {
+		cfg.Name = "mock"
+	}
+	if cfg.Reply == "" {
+		cfg.Reply = defaultMockReply
+	}
+	answer := func(ctx context.Context, message string) (Reply, error) {
+		reply, rule := cfg.Reply, MockRule{}
+		for _, r := range cfg.Rules {
+			if r.pattern != nil && r.pattern.MatchString(message) {
+				reply, rule = r.Reply, r
+				break
+			}
+		}
+		if rule.delay > 0 {
+			timer := time.NewTimer(rule.delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return Reply{}, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if rule.Status != 0 && rule.Status != http.StatusOK {
+			return Reply{}, &ProviderError{Provider: cfg.Name, StatusCode: rule.Status, RetryAfter: rule.retryAfter, Message: rule.Reply}
+		}
+		return Reply{Text: strings.ReplaceAll(reply, "{{message}}", message), Usage: Usage{Model: "mock"}}, nil
+	}
+	return providerFunc{
+		name: cfg.Name,
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			return answer(ctx, message)
+		},
+		stream: func(ctx context.Context, message string, session *Session, onDelta func(string)) (Reply, error) {
+			reply, err := answer(ctx, message)
+			if err != nil {
+				return reply, err
+			}
+			for _, word := range strings.SplitAfter(reply.Text, " ") {
+				onDelta(word)
+			}
+			return reply, nil
+		},
+	}
+}
+
+// mockProvidersFromEnv reads MOCK_AI_RESPONSES: "true" adds an echo provider
+// named "mock", and a file path adds the providers it scripts.
+func mockProvidersFromEnv() []Provider {
+	setting := strings.TrimSpace(os.Getenv("MOCK_AI_RESPONSES"))
+	switch strings.ToLower(setting) {
+	case "", "false", "0":
+		return nil
+	case "true", "1", "echo":
+		log.Println("🧪 Mock AI provider enabled (echo)")
+		return []Provider{newMockProvider(MockProviderConfig{})}
+	}
+
+	configs, err := LoadMockProviders(setting)
+	if err != nil {
+		log.Printf("❌ Failed to load mock providers, using echo: %v", err)
+		return []Provider{newMockProvider(MockProviderConfig{})}
+	}
+	providers := make([]Provider, 0, len(configs))
+	for _, cfg := range configs {
+		providers = append(providers, newMockProvider(cfg))
+		log.Printf("🧪 Mock AI provider %s enabled (%d rules)", cfg.Name, len(cfg.Rules))
+	}
+	return providers
+}
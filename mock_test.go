@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMockProvidersDriveTheFallbackChain(t *testing.T) {
+	configs, err := LoadMockProviders("config/mock.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var providers []Provider
+	for _, cfg := range configs {
+		providers = append(providers, newMockProvider(cfg))
+	}
+	svc := NewAIService(nil, nil, providers...)
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}
+
+	req.Message = "hello"
+	if reply := svc.Respond(context.Background(), req); reply != "flaky says: hello" {
+		t.Fatalf("default reply %q", reply)
+	}
+
+	req.Message = "There's an outage. What should I pack?"
+	var updates []string
+	reply := svc.RespondStream(context.Background(), req, func(text string) { updates = append(updates, text) })
+	if !strings.HasPrefix(reply, "Bring a tent") || len(updates) < 2 || updates[len(updates)-1] != reply {
+		t.Fatalf("fallback reply %q streamed as %q", reply, updates)
+	}
+
+	// A scripted 429 opens flaky's breaker, so the next message skips it
+	req.Message = "rate limit me"
+	svc.Respond(context.Background(), req)
+	req.Message = "hello again"
+	if reply := svc.Respond(context.Background(), req); reply != "🧪 Mock reply to: hello again" {
+		t.Fatalf("expected the echo mock while flaky is rate limited, got %q", reply)
+	}
+	if status := svc.ProviderStatus()[0]; status.State != "open" {
+		t.Fatalf("flaky should be open, got %+v", status)
+	}
+}
+
+func TestLoadMockProvidersRejectsBadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mock.json")
+	for _, script := range []string{
+		`{"providers":[{"rules":[{"match":"("}]}]}`,
+		`{"providers":[{"rules":[{"match":"x","delay":"soon"}]}]}`,
+	} {
+		if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMockProviders(path); err == nil {
+			t.Fatalf("expected an error for %s", script)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -22,8 +24,12 @@ type CampMonitor struct {
 
 	lastCount int
 	haveCount bool
+
+	mu        sync.Mutex // guards the website fields, read by WebsiteStatus
 	siteUp    bool
 	haveSite  bool
+	siteCheck time.Time
+	siteState string
 }
 
 // NewCampMonitor creates a monitor. Returns nil when there is nothing to do.
@@ -72,16 +78,18 @@ func (m *CampMonitor) tick() {
 }
 
 func (m *CampMonitor) checkWebsite() {
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Get(m.camp.BaseURL()) // #nosec G704 -- validated operator-configured URL (see validateBaseURL)
-	up := err == nil && resp.StatusCode < 500
-	if resp != nil {
-		_ = resp.Body.Close()
-	}
+	up, detail := probeWebsite(context.Background(), m.camp.BaseURL())
 
-	if !m.haveSite {
-		m.haveSite = true
-		m.siteUp = up
+	m.mu.Lock()
+	first := !m.haveSite
+	changed := up != m.siteUp
+	m.haveSite = true
+	m.siteUp = up
+	m.siteCheck = time.Now()
+	m.siteState = detail
+	m.mu.Unlock()
+
+	if first {
 		if up {
 			m.post(m.statusChannel, fmt.Sprintf("🟢 **Website monitor active** - %s is up. I'll post here if it goes down.", m.camp.BaseURL()))
 		} else {
@@ -90,23 +98,45 @@ func (m *CampMonitor) checkWebsite() {
 		return
 	}
 
-	if up == m.siteUp {
+	if !changed {
 		return // no transition, stay quiet
 	}
-	m.siteUp = up
 	if up {
 		m.post(m.statusChannel, fmt.Sprintf("🟢 **Website recovered** - %s is back up.", m.camp.BaseURL()))
 	} else {
-		detail := "no response"
-		if err != nil {
-			detail = err.Error()
-		} else if resp != nil {
-			detail = resp.Status
-		}
 		m.post(m.statusChannel, fmt.Sprintf("🔴 **Website DOWN** - %s is not responding (%s). Check the Railway dashboard!", m.camp.BaseURL(), detail))
 	}
 }
 
+// WebsiteStatus returns the result of the most recent website check. checked
+// is zero when the monitor is not running or has not checked yet.
+func (m *CampMonitor) WebsiteStatus() (up bool, detail string, checked time.Time) {
+	if m == nil || m.statusChannel == "" {
+		return false, "", time.Time{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.siteUp, m.siteState, m.siteCheck
+}
+
+// probeWebsite fetches url and reports whether it is up (any status below
+// 500), with the HTTP status or error as detail.
+func probeWebsite(ctx context.Context, url string) (bool, string) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil) // #nosec G704 -- validated operator-configured URL (see validateBaseURL)
+	if err != nil {
+		return false, err.Error()
+	}
+	resp, err := http.DefaultClient.Do(req) // #nosec G704 -- validated operator-configured URL (see validateBaseURL)
+	if err != nil {
+		return false, err.Error()
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode < 500, resp.Status
+}
+
 func (m *CampMonitor) checkRegistrations() {
 	count, err := m.camp.RegistrationCount()
 	if err != nil {
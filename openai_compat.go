@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,11 +18,18 @@ import (
 // OpenAICompatClient talks to any OpenAI-compatible chat completions API
 // (Groq, Mistral, OpenRouter, Cerebras, Ollama, OpenAI itself, etc.)
 type OpenAICompatClient struct {
-	baseURL    string
-	apiKey     string
-	model      string
-	name       string
-	httpClient *http.Client
+	baseURL     string
+	apiKey      string
+	model       string
+	name        string
+	timeout     time.Duration
+	maxTokens   int
+	temperature float64
+	headers     map[string]string
+	vision      bool // the model accepts images
+	streamUsage bool // the endpoint accepts stream_options
+	httpClient  *http.Client
+	retry       retryPolicy
 }
 
 // NewOpenAICompatClient creates a client for an OpenAI-compatible endpoint.
@@ -35,102 +45,431 @@ func NewOpenAICompatClient(baseURL, apiKey, model, name string) *OpenAICompatCli
 	}
 
 	return &OpenAICompatClient{
-		baseURL:    validated,
-		apiKey:     apiKey,
-		model:      model,
-		name:       name,
-		httpClient: &http.Client{Timeout: 60 * time.Second},
+		baseURL:     validated,
+		apiKey:      apiKey,
+		model:       model,
+		name:        name,
+		timeout:     defaultCompatTimeout,
+		maxTokens:   1000,
+		temperature: 0.7,
+		httpClient:  &http.Client{Timeout: defaultCompatTimeout},
+		retry:       defaultRetryPolicy,
 	}
 }
 
+// NewOpenAICompatClientFromConfig creates a client for a declared endpoint,
+// applying its timeout, generation settings and extra headers.
+func NewOpenAICompatClientFromConfig(cfg CompatProviderConfig) *OpenAICompatClient {
+	timeout, err := cfg.timeout()
+	if err != nil {
+		return nil
+	}
+	client := NewOpenAICompatClient(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.Name)
+	if client == nil {
+		return nil
+	}
+	client.timeout = timeout
+	client.httpClient.Timeout = timeout
+	if cfg.MaxTokens > 0 {
+		client.maxTokens = cfg.MaxTokens
+	}
+	if cfg.Temperature != nil {
+		client.temperature = *cfg.Temperature
+	}
+	client.headers = cfg.Headers
+	client.vision = cfg.Vision
+	client.streamUsage = cfg.StreamUsage
+	return client
+}
+
 type oaChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role string `json:"role"`
+	// Content is a string, or []oaContentPart for a message with images.
+	Content    any          `json:"content"`
+	ToolCalls  []oaToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string       `json:"tool_call_id,omitempty"`
+}
+
+type oaContentPart struct {
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	ImageURL *oaImageURL `json:"image_url,omitempty"`
+}
+
+type oaImageURL struct {
+	URL string `json:"url"`
+}
+
+type oaToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type oaTool struct {
+	Type     string         `json:"type"`
+	Function oaToolFunction `json:"function"`
+}
+
+type oaToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
 }
 
 type oaChatRequest struct {
 	Model       string          `json:"model"`
 	Messages    []oaChatMessage `json:"messages"`
 	MaxTokens   int             `json:"max_tokens,omitempty"`
-	Temperature float64         `json:"temperature,omitempty"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	Stream      bool            `json:"stream,omitempty"`
+	// StreamOptions asks for a final chunk with the token usage, which
+	// streamed responses otherwise leave out.
+	StreamOptions *oaStreamOptions `json:"stream_options,omitempty"`
+	Tools         []oaTool         `json:"tools,omitempty"`
+	ToolChoice    string           `json:"tool_choice,omitempty"`
+}
+
+type oaStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type oaChatResponse struct {
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string       `json:"content"`
+			ToolCalls []oaToolCall `json:"tool_calls"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *oaUsage `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
+// oaUsage is the token accounting OpenAI-style APIs attach to a completion.
+type oaUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// usage converts the reported counts; a nil oaUsage reports none.
+func (u *oaUsage) usage(model string) Usage {
+	if u == nil {
+		return Usage{Model: model}
+	}
+	return Usage{Model: model, PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+}
+
+// oaMessages builds the chat messages array: system prompt, if any, prior
+// turns, then the new user message with its images as data URLs.
+func oaMessages(prompt Prompt) []oaChatMessage {
+	messages := make([]oaChatMessage, 0, len(prompt.History)+2)
+	if prompt.System != "" {
+		messages = append(messages, oaChatMessage{Role: "system", Content: prompt.System})
+	}
+	for _, turn := range prompt.History {
+		messages = append(messages, oaChatMessage{Role: turn.Role, Content: turn.Content})
+	}
+	if len(prompt.Images) == 0 {
+		return append(messages, oaChatMessage{Role: "user", Content: prompt.Message})
+	}
+	parts := []oaContentPart{{Type: "text", Text: prompt.Message}}
+	for _, image := range prompt.Images {
+		url := "data:" + image.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(image.Data)
+		parts = append(parts, oaContentPart{Type: "image_url", ImageURL: &oaImageURL{URL: url}})
+	}
+	return append(messages, oaChatMessage{Role: "user", Content: parts})
+}
+
 // GenerateResponse generates a response using the configured endpoint
-func (o *OpenAICompatClient) GenerateResponse(message string) (string, error) {
+func (o *OpenAICompatClient) GenerateResponse(ctx context.Context, prompt Prompt) (Reply, error) {
 	if o == nil {
-		return "", nil // Return empty to use fallback
+		return Reply{}, nil // Return empty to use fallback
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
 	defer cancel()
 
-	systemPrompt := `You are Kit, a helpful and friendly AI assistant integrated into Slack and Discord. Keep responses under 300 words and be professional but approachable.`
+	parsed, err := o.complete(ctx, o.payload(prompt, false))
+	if err != nil {
+		return Reply{}, err
+	}
 
-	payload := oaChatRequest{
-		Model: o.model,
-		Messages: []oaChatMessage{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   1000,
-		Temperature: 0.7,
+	reply := Reply{Usage: parsed.Usage.usage(o.model)}
+	if len(parsed.Choices) > 0 {
+		reply.Text = strings.TrimSpace(parsed.Choices[0].Message.Content)
 	}
+	return reply, nil
+}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
+// GenerateWithTools runs the model → tool → model loop: tool calls the model
+// asks for are run through tools.Call and their results sent back, until the
+// model answers in text or tools.MaxSteps rounds have been used.
+func (o *OpenAICompatClient) GenerateWithTools(ctx context.Context, prompt Prompt, tools *ToolSet) (Reply, error) {
+	if o == nil {
+		return Reply{}, nil // Return empty to use fallback
 	}
 
-	url := o.baseURL + "/chat/completions"
-	// baseURL is validated (scheme+host) in NewOpenAICompatClient; operator config, not user input.
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)) // #nosec G704 -- validated operator-configured URL
-	if err != nil {
-		return "", err
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	payload := o.payload(prompt, false)
+	for _, tool := range tools.Tools {
+		payload.Tools = append(payload.Tools, oaTool{
+			Type: "function",
+			Function: oaToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.jsonSchema(),
+			},
+		})
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if o.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+
+	usage := Usage{Model: o.model}
+	for step := 0; ; step++ {
+		if step >= tools.MaxSteps {
+			payload.ToolChoice = "none" // out of steps: make the model answer
+		}
+		parsed, err := o.complete(ctx, payload)
+		if err != nil {
+			return Reply{Usage: usage}, err
+		}
+		usage.add(parsed.Usage.usage(o.model))
+		if len(parsed.Choices) == 0 {
+			return Reply{Usage: usage}, nil
+		}
+
+		reply := parsed.Choices[0].Message
+		if len(reply.ToolCalls) == 0 || payload.ToolChoice == "none" {
+			return Reply{Text: strings.TrimSpace(reply.Content), Usage: usage}, nil
+		}
+		payload.Messages = append(payload.Messages, oaChatMessage{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls})
+		for _, call := range reply.ToolCalls {
+			args := call.Function.Arguments
+			if strings.TrimSpace(args) == "" {
+				args = "{}"
+			}
+			payload.Messages = append(payload.Messages, oaChatMessage{
+				Role:       "tool",
+				ToolCallID: call.ID,
+				Content:    tools.Call(ctx, call.Function.Name, json.RawMessage(args)),
+			})
+		}
 	}
+}
 
-	resp, err := o.httpClient.Do(req) // #nosec G704 -- request URL built from validated operator config
+// complete sends a non-streaming request and decodes the response.
+func (o *OpenAICompatClient) complete(ctx context.Context, payload oaChatRequest) (*oaChatResponse, error) {
+	resp, err := o.send(ctx, payload)
 	if err != nil {
-		log.Printf("❌ OpenAI-compatible API error: %v", err)
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("❌ OpenAI-compatible API returned status %d", resp.StatusCode) // #nosec G706 -- StatusCode is an int
-		return "", fmt.Errorf("%s api status %d", o.name, resp.StatusCode)
+		return nil, err
 	}
 
 	var parsed oaChatResponse
 	if err := json.Unmarshal(respBody, &parsed); err != nil {
-		return "", err
+		return nil, err
 	}
 	if parsed.Error != nil {
-		return "", fmt.Errorf("%s api: %s", o.name, parsed.Error.Message)
+		return nil, fmt.Errorf("%s api: %s", o.name, parsed.Error.Message)
+	}
+	return &parsed, nil
+}
+
+// GenerateStream is like GenerateResponse but requests a server-sent event
+// stream, calling onDelta with each fragment of text as it arrives.
+func (o *OpenAICompatClient) GenerateStream(ctx context.Context, prompt Prompt, onDelta func(string)) (Reply, error) {
+	if o == nil {
+		return Reply{}, nil // Return empty to use fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	resp, err := o.send(ctx, o.payload(prompt, true))
+	if err != nil {
+		return Reply{}, err
+	}
+	defer resp.Body.Close()
+
+	text, usage, err := readChatStream(resp.Body, onDelta)
+	if err != nil {
+		return Reply{}, fmt.Errorf("%s api stream: %w", o.name, err)
+	}
+	return Reply{Text: strings.TrimSpace(text), Usage: usage.usage(o.model)}, nil
+}
+
+// payload builds a chat completions request. Images are only sent to
+// endpoints configured as vision-capable.
+func (o *OpenAICompatClient) payload(prompt Prompt, stream bool) oaChatRequest {
+	if !o.vision {
+		prompt = prompt.textOnly()
 	}
+	temperature := o.temperature
+	payload := oaChatRequest{
+		Model:       o.model,
+		Messages:    oaMessages(prompt),
+		MaxTokens:   o.maxTokens,
+		Temperature: &temperature,
+		Stream:      stream,
+	}
+	if stream && o.streamUsage {
+		payload.StreamOptions = &oaStreamOptions{IncludeUsage: true}
+	}
+	return payload
+}
 
-	if len(parsed.Choices) > 0 && parsed.Choices[0].Message.Content != "" {
-		return strings.TrimSpace(parsed.Choices[0].Message.Content), nil
+// send posts a chat completions request and returns the response once it
+// has a 200 status. The caller must close the response body.
+func (o *OpenAICompatClient) send(ctx context.Context, payload oaChatRequest) (*http.Response, error) {
+	return o.post(ctx, "/chat/completions", payload)
+}
+
+// post sends payload as JSON to path under the API root and returns the
+// response once it has a 200 status. The caller must close the response body.
+func (o *OpenAICompatClient) post(ctx context.Context, path string, payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
 	}
 
-	return "", nil
+	url := o.baseURL + path
+	return o.retry.do(ctx, o.name, func() (*http.Response, error) {
+		// baseURL is validated (scheme+host) in NewOpenAICompatClient; operator config, not user input.
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)) // #nosec G704 -- validated operator-configured URL
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if o.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+o.apiKey)
+		}
+		for name, value := range o.headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := o.httpClient.Do(req) // #nosec G704 -- request URL built from validated operator config
+		if err != nil {
+			log.Printf("❌ %s API error: %v", o.name, err)
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			log.Printf("❌ %s API returned status %d", o.name, resp.StatusCode) // #nosec G706 -- operator-configured name; StatusCode is an int
+			return nil, newProviderError(o.name, resp, errBody)
+		}
+		return resp, nil
+	})
+}
+
+// oaStreamChunk is one server-sent event from a streaming chat completion.
+type oaStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *oaUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// errStreamTruncated means a stream ended without "data: [DONE]", so the
+// text received is likely a partial reply.
+var errStreamTruncated = errors.New("stream ended before [DONE]")
+
+// readChatStream consumes an OpenAI-style SSE body ("data: {...}" lines
+// terminated by "data: [DONE]"), forwarding each content delta to onDelta
+// and returning the full text. Servers that report usage on a stream send it
+// in the final chunk; usage is nil otherwise. A body that ends before
+// [DONE] returns errStreamTruncated along with the text so far.
+func readChatStream(body io.Reader, onDelta func(string)) (string, *oaUsage, error) {
+	var text strings.Builder
+	var usage *oaUsage
+	done := false
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue // comments, event names and keep-alives
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk oaStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return text.String(), usage, err
+		}
+		if chunk.Error != nil {
+			return text.String(), usage, fmt.Errorf("%s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			text.WriteString(choice.Delta.Content)
+			if onDelta != nil {
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return text.String(), usage, err
+	}
+	if !done {
+		return text.String(), usage, errStreamTruncated
+	}
+	return text.String(), usage, nil
+}
+
+// compatEmbedder computes embeddings with an OpenAI-compatible /embeddings
+// endpoint, for response cache similarity matching.
+type compatEmbedder struct {
+	client *OpenAICompatClient
+	model  string
+}
+
+// Embedder returns an Embedder that uses the given embedding model.
+func (o *OpenAICompatClient) Embedder(model string) Embedder {
+	return &compatEmbedder{client: o, model: model}
+}
+
+func (e *compatEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	resp, err := e.client.post(ctx, "/embeddings", map[string]any{"model": e.model, "input": text})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, fmt.Errorf("%s returned no embedding", e.client.name)
+	}
+	return result.Data[0].Embedding, nil
 }
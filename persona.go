@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultBotName = "Kit"
+	// defaultPersona is the built-in persona used unless PERSONA_DEFAULT
+	// names another.
+	defaultPersona = "kit"
+)
+
+// defaultPersonaPrompt is the built-in Kit persona. A kit.md file in
+// PERSONAS_DIR replaces it.
+const defaultPersonaPrompt = `You are {{.BotName}}, a helpful and friendly AI assistant integrated into {{.Platform}}. Today is {{.Date}}.
+
+Your personality:
+- Professional but approachable
+- Concise but thorough when needed
+- Helpful and solution-oriented
+- Use emojis sparingly but appropriately
+- Keep responses under 300 words for readability in {{.Platform}}
+
+Always be ready to:
+- Answer questions clearly
+- Help with problem-solving
+- Provide explanations and guidance
+- Assist with work-related tasks
+- Maintain a positive, collaborative tone`
+
+// Persona is a named system prompt template. Templates may use
+// {{.BotName}}, {{.Platform}}, {{.Channel}} and {{.Date}}.
+type Persona struct {
+	Name        string
+	Description string
+	template    *template.Template
+}
+
+// personaData is what persona templates are rendered with.
+type personaData struct {
+	BotName  string
+	Platform string // "Slack" or "Discord"
+	Channel  string // channel ID
+	Date     string // e.g. "Monday, June 2, 2025"
+}
+
+// NewPersona parses a persona template. A leading markdown heading
+// ("# Friendly camp helper") becomes the description and is not part of
+// the prompt.
+func NewPersona(name, text string) (*Persona, error) {
+	persona := &Persona{Name: name}
+	if heading, rest, ok := strings.Cut(text, "\n"); ok && strings.HasPrefix(heading, "# ") {
+		persona.Description = strings.TrimSpace(strings.TrimPrefix(heading, "# "))
+		text = rest
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("persona %q: %w", name, err)
+	}
+	persona.template = tmpl
+	return persona, nil
+}
+
+// PersonaSet holds the available personas and decides which one answers in
+// each channel: a runtime switch from !persona, else the channel's
+// configured persona, else the default.
+type PersonaSet struct {
+	mu          sync.RWMutex
+	botName     string
+	personas    map[string]*Persona
+	defaultName string
+	channels    map[string]string // configured per-channel personas
+	overrides   map[string]string // runtime switches by channel
+	now         func() time.Time
+}
+
+// NewPersonaSet returns a set holding only the built-in Kit persona.
+func NewPersonaSet(botName string) *PersonaSet {
+	if botName == "" {
+		botName = defaultBotName
+	}
+	kit, err := NewPersona(defaultPersona, defaultPersonaPrompt)
+	if err != nil {
+		panic(err) // the built-in template is constant
+	}
+	return &PersonaSet{
+		botName:     botName,
+		personas:    map[string]*Persona{defaultPersona: kit},
+		defaultName: defaultPersona,
+		channels:    make(map[string]string),
+		overrides:   make(map[string]string),
+		now:         time.Now,
+	}
+}
+
+// LoadDir adds a persona for each .md or .txt file in dir, named after the
+// file; a file may replace a built-in persona.
+func (p *PersonaSet) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".md" && ext != ".txt") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name())) // #nosec G304 -- operator-configured directory
+		if err != nil {
+			return err
+		}
+		persona, err := NewPersona(strings.ToLower(strings.TrimSuffix(entry.Name(), ext)), string(data))
+		if err != nil {
+			return err
+		}
+		p.personas[persona.Name] = persona
+	}
+	return nil
+}
+
+// SetDefault chooses the persona for channels without their own.
+func (p *PersonaSet) SetDefault(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name = strings.ToLower(name)
+	if p.personas[name] == nil {
+		return fmt.Errorf("unknown persona %q", name)
+	}
+	p.defaultName = name
+	return nil
+}
+
+// AssignChannel configures the persona for a channel.
+func (p *PersonaSet) AssignChannel(channelID, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name = strings.ToLower(name)
+	if p.personas[name] == nil {
+		return fmt.Errorf("unknown persona %q", name)
+	}
+	p.channels[channelID] = name
+	return nil
+}
+
+// Switch changes a channel's persona until restart; an empty name returns
+// the channel to its configured persona.
+func (p *PersonaSet) Switch(channelID, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name = strings.ToLower(name)
+	if name == "" {
+		delete(p.overrides, channelID)
+		return nil
+	}
+	if p.personas[name] == nil {
+		return fmt.Errorf("unknown persona %q", name)
+	}
+	p.overrides[channelID] = name
+	return nil
+}
+
+// forChannel returns the persona that answers in the channel.
+func (p *PersonaSet) forChannel(channelID string) *Persona {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	name, ok := p.overrides[channelID]
+	if !ok {
+		name, ok = p.channels[channelID]
+	}
+	if !ok {
+		name = p.defaultName
+	}
+	return p.personas[name]
+}
+
+// Names lists the available personas alphabetically.
+func (p *PersonaSet) Names() []string {
+	var names []string
+	for _, persona := range p.list() {
+		names = append(names, persona.Name)
+	}
+	return names
+}
+
+func (p *PersonaSet) list() []*Persona {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	personas := make([]*Persona, 0, len(p.personas))
+	for _, persona := range p.personas {
+		personas = append(personas, persona)
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].Name < personas[j].Name })
+	return personas
+}
+
+// systemPrompt renders the persona for a request and returns its name
+// along with the prompt.
+func (p *PersonaSet) systemPrompt(req ChatRequest) (string, string) {
+	persona := p.forChannel(req.ChannelID)
+	data := personaData{
+		BotName:  p.botName,
+		Platform: platformTitle(req.Platform),
+		Channel:  req.ChannelID,
+		Date:     p.now().Format("Monday, January 2, 2006"),
+	}
+	var b strings.Builder
+	if err := persona.template.Execute(&b, data); err != nil {
+		log.Printf("⚠️  Persona %s failed to render: %v", persona.Name, err)
+		return persona.Name, ""
+	}
+	return persona.Name, b.String()
+}
+
+func platformTitle(platform string) string {
+	switch platform {
+	case "slack":
+		return "Slack"
+	case "discord":
+		return "Discord"
+	default:
+		return platform
+	}
+}
+
+// personasFromEnv builds the persona set from BOT_NAME, PERSONAS_DIR,
+// PERSONA_DEFAULT and PERSONA_CHANNELS (comma-separated channel=persona
+// pairs). Problems are logged and the rest of the configuration applied.
+func personasFromEnv() *PersonaSet {
+	personas := NewPersonaSet(os.Getenv("BOT_NAME"))
+	if dir := os.Getenv("PERSONAS_DIR"); dir != "" {
+		if err := personas.LoadDir(dir); err != nil {
+			log.Printf("❌ Failed to load personas: %v", err)
+		}
+	}
+	if name := os.Getenv("PERSONA_DEFAULT"); name != "" {
+		if err := personas.SetDefault(name); err != nil {
+			log.Printf("⚠️  PERSONA_DEFAULT: %v", err)
+		}
+	}
+	for _, pair := range splitList(os.Getenv("PERSONA_CHANNELS")) {
+		channelID, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Printf("⚠️  PERSONA_CHANNELS: expected channel=persona, got %q", pair)
+			continue
+		}
+		if err := personas.AssignChannel(strings.TrimSpace(channelID), strings.TrimSpace(name)); err != nil {
+			log.Printf("⚠️  PERSONA_CHANNELS: %v", err)
+		}
+	}
+	log.Printf("🎭 Personas available: %s", strings.Join(personas.Names(), ", "))
+	return personas
+}
+
+// handlePersonaCommand implements !persona and /kit persona: show the
+// channel's persona and the alternatives, or let admins switch it.
+func handlePersonaCommand(args, userID, channelID string) string {
+	if globalAIService == nil {
+		return "🎭 Personas need an AI provider to be configured."
+	}
+	personas := globalAIService.personas
+	fields := strings.Fields(args)
+	if len(fields) == 0 || strings.EqualFold(fields[0], "list") {
+		current := personas.forChannel(channelID)
+		var b strings.Builder
+		fmt.Fprintf(&b, "🎭 **Persona in this channel:** %s\n\n**Available:**\n", current.Name)
+		for _, persona := range personas.list() {
+			line := "• `" + persona.Name + "`"
+			if persona.Description != "" {
+				line += " - " + persona.Description
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("\nAdmins: `persona set <name>` or `persona reset`")
+		return b.String()
+	}
+
+	if !isAdmin(userID) {
+		return "🔒 Only Kit admins can switch personas."
+	}
+	switch strings.ToLower(fields[0]) {
+	case "set", "use":
+		if len(fields) < 2 {
+			return "❓ **Usage:** `persona set <name>`"
+		}
+		if err := personas.Switch(channelID, fields[1]); err != nil {
+			return fmt.Sprintf("❓ No persona called `%s`. Try `persona list`.", fields[1])
+		}
+		log.Printf("🎭 User %s switched channel %s to persona %s", userID, channelID, fields[1])
+		return fmt.Sprintf("🎭 Switched this channel to the **%s** persona.", strings.ToLower(fields[1]))
+	case "reset":
+		_ = personas.Switch(channelID, "")
+		return fmt.Sprintf("🎭 This channel is back to the **%s** persona.", personas.forChannel(channelID).Name)
+	default:
+		return "❓ **Usage:** `persona`, `persona set <name>` or `persona reset`"
+	}
+}
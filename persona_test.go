@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersonaSetPicksChannelPersona(t *testing.T) {
+	dir := t.TempDir()
+	helper := "# Camp helper\nYou are {{.BotName}}, the camp helper on {{.Platform}} in {{.Channel}}."
+	if err := os.WriteFile(filepath.Join(dir, "camp-helper.md"), []byte(helper), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	personas := NewPersonaSet("Kit")
+	if err := personas.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	personas.now = func() time.Time { return time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC) }
+	if err := personas.AssignChannel("C-camp", "camp-helper"); err != nil {
+		t.Fatal(err)
+	}
+
+	name, prompt := personas.systemPrompt(ChatRequest{Platform: "discord", ChannelID: "C-general"})
+	if name != "kit" || !strings.Contains(prompt, "integrated into Discord") || !strings.Contains(prompt, "Monday, June 2, 2025") {
+		t.Fatalf("default persona: %s %q", name, prompt)
+	}
+	name, prompt = personas.systemPrompt(ChatRequest{Platform: "slack", ChannelID: "C-camp"})
+	if name != "camp-helper" || prompt != "You are Kit, the camp helper on Slack in C-camp." {
+		t.Fatalf("channel persona: %s %q", name, prompt)
+	}
+
+	// Runtime switches win over configuration until reset
+	if err := personas.Switch("C-camp", "kit"); err != nil {
+		t.Fatal(err)
+	}
+	if p := personas.forChannel("C-camp"); p.Name != "kit" {
+		t.Fatalf("switch ignored, got %s", p.Name)
+	}
+	personas.Switch("C-camp", "")
+	if p := personas.forChannel("C-camp"); p.Name != "camp-helper" {
+		t.Fatalf("reset should restore the configured persona, got %s", p.Name)
+	}
+	if err := personas.Switch("C-camp", "pirate"); err == nil {
+		t.Fatal("expected an error for an unknown persona")
+	}
+}
+
+func TestRespondSendsPersonaToProvider(t *testing.T) {
+	var system string
+	echo := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			system = session.SystemPrompt
+			return Reply{Text: "hi"}, nil
+		},
+	}
+	svc := NewAIService(nil, nil, echo)
+	svc.Respond(context.Background(), ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "hello"})
+	if !strings.Contains(system, "You are Kit") || !strings.Contains(system, "Discord") {
+		t.Fatalf("provider got system prompt %q", system)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// rateWindow is the period the per-minute request limits refill over.
+const rateWindow = time.Minute
+
+// RateLimits configures request throttling in front of AIService. Bucket
+// limits are requests per minute with an equal burst; quotas are per user per
+// UTC day. Zero disables a limit.
+type RateLimits struct {
+	PerUser       int
+	PerChannel    int
+	PerWorkspace  int
+	DailyRequests int
+	DailyTokens   int // prompt and reply tokens
+	// Exempt lists Slack or Discord user IDs that are never limited.
+	Exempt []string
+}
+
+// rateLimitsFromEnv reads RATE_LIMIT_PER_USER, RATE_LIMIT_PER_CHANNEL,
+// RATE_LIMIT_PER_WORKSPACE, DAILY_REQUEST_QUOTA and DAILY_TOKEN_QUOTA.
+// Users in RATE_LIMIT_EXEMPT_USERS or ADMIN_USER_IDS are exempt.
+func rateLimitsFromEnv() RateLimits {
+	intEnv := func(name string) int {
+		n, _ := strconv.Atoi(os.Getenv(name))
+		return max(n, 0)
+	}
+	limits := RateLimits{
+		PerUser:       intEnv("RATE_LIMIT_PER_USER"),
+		PerChannel:    intEnv("RATE_LIMIT_PER_CHANNEL"),
+		PerWorkspace:  intEnv("RATE_LIMIT_PER_WORKSPACE"),
+		DailyRequests: intEnv("DAILY_REQUEST_QUOTA"),
+		DailyTokens:   intEnv("DAILY_TOKEN_QUOTA"),
+	}
+	limits.Exempt = append(splitList(os.Getenv("RATE_LIMIT_EXEMPT_USERS")), splitList(os.Getenv("ADMIN_USER_IDS"))...)
+	return limits
+}
+
+func (l RateLimits) enabled() bool {
+	return l.PerUser > 0 || l.PerChannel > 0 || l.PerWorkspace > 0 || l.DailyRequests > 0 || l.DailyTokens > 0
+}
+
+// RateStore keeps limiter state. Every SessionStore backend implements it so
+// counters survive restarts wherever conversations do.
+type RateStore interface {
+	// TakeToken removes one token from the bucket at key, which holds up to
+	// capacity tokens and refills completely over per. When the bucket is
+	// empty it returns false and how long until a token is available.
+	TakeToken(key string, capacity int, per time.Duration, now time.Time) (bool, time.Duration)
+	// ReturnToken puts back a token taken for a request that another limit
+	// then refused.
+	ReturnToken(key string, capacity int)
+	// AddCounter adds delta to the counter at key, which is dropped at
+	// expires, and returns the new value.
+	AddCounter(key string, delta int, expires time.Time) int
+	// Counter returns the current value of the counter at key.
+	Counter(key string) int
+}
+
+// tokenBucket is the in-process bucket state used by the local stores.
+type tokenBucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+	Full    time.Time `json:"full"` // when the bucket will be full again
+}
+
+// take refills the bucket for the time since its last update and removes a
+// token if one is available.
+func (b *tokenBucket) take(capacity int, per time.Duration, now time.Time) (bool, time.Duration) {
+	rate := float64(capacity) / float64(per) // tokens per nanosecond
+	if b.Updated.IsZero() {
+		b.Tokens = float64(capacity)
+	} else if elapsed := now.Sub(b.Updated); elapsed > 0 {
+		b.Tokens = math.Min(float64(capacity), b.Tokens+float64(elapsed)*rate)
+	}
+	b.Updated = now
+
+	allowed := b.Tokens >= 1
+	if allowed {
+		b.Tokens--
+	}
+	b.Full = now.Add(time.Duration((float64(capacity) - b.Tokens) / rate))
+	if allowed {
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) / rate)
+}
+
+// putBack returns a token taken by take.
+func (b *tokenBucket) putBack(capacity int) {
+	b.Tokens = math.Min(float64(capacity), b.Tokens+1)
+}
+
+// counter is a value that expires, used for daily quotas.
+type counter struct {
+	Value   int       `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// RateLimiter applies RateLimits to chat requests.
+type RateLimiter struct {
+	limits RateLimits
+	store  RateStore
+	now    func() time.Time
+}
+
+// NewRateLimiter returns a limiter that keeps its state in store.
+func NewRateLimiter(limits RateLimits, store RateStore) *RateLimiter {
+	return &RateLimiter{limits: limits, store: store, now: time.Now}
+}
+
+func (l *RateLimiter) exempt(userID string) bool {
+	for _, id := range l.limits.Exempt {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaKey names a per-user daily counter; quotas reset at midnight UTC.
+func quotaKey(req ChatRequest, kind string, now time.Time) string {
+	return fmt.Sprintf("quota:%s:%s:%s:%s", kind, req.Platform, req.UserID, now.UTC().Format("2006-01-02"))
+}
+
+func nextMidnight(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// RateLimitError is how RespondResult reports a request a limit refused.
+// Reply is the friendly message chat users get instead of an answer.
+type RateLimitError struct {
+	Reply      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "rate limited: " + e.Reply
+}
+
+// text is the reply for the user, or "" when nothing was refused.
+func (e *RateLimitError) text() string {
+	if e == nil {
+		return ""
+	}
+	return e.Reply
+}
+
+// allow checks and consumes the request's limits. It returns nil when the
+// request may proceed. A refused request uses up nothing: tokens taken
+// before another limit refused it are returned, and it only counts toward
+// the daily quota once every bucket has let it through.
+func (l *RateLimiter) allow(req ChatRequest) *RateLimitError {
+	if l == nil || l.exempt(req.UserID) {
+		return nil
+	}
+	now := l.now()
+
+	if l.limits.DailyTokens > 0 && l.store.Counter(quotaKey(req, "tokens", now)) >= l.limits.DailyTokens {
+		log.Printf("🚦 Daily token quota reached for %s user %s", req.Platform, req.UserID)
+		return &RateLimitError{
+			Reply:      "📅 You've used today's AI allowance. It resets at midnight UTC — see you then!",
+			RetryAfter: nextMidnight(now).Sub(now),
+		}
+	}
+	requestQuota := quotaKey(req, "requests", now)
+	if l.limits.DailyRequests > 0 && l.store.Counter(requestQuota) >= l.limits.DailyRequests {
+		return l.dailyRequestsReached(req, now)
+	}
+
+	buckets := []struct {
+		scope string
+		id    string
+		limit int
+	}{
+		{"user", req.UserID, l.limits.PerUser},
+		{"channel", req.ChannelID, l.limits.PerChannel},
+		{"workspace", req.WorkspaceID, l.limits.PerWorkspace},
+	}
+	type takenToken struct {
+		key   string
+		limit int
+	}
+	var taken []takenToken
+	refund := func() {
+		for _, t := range taken {
+			l.store.ReturnToken(t.key, t.limit)
+		}
+	}
+	for _, b := range buckets {
+		if b.limit <= 0 || b.id == "" {
+			continue
+		}
+		key := fmt.Sprintf("rate:%s:%s:%s", b.scope, req.Platform, b.id)
+		if ok, wait := l.store.TakeToken(key, b.limit, rateWindow, now); !ok {
+			refund()
+			log.Printf("🚦 Rate limited %s user %s (%s limit)", req.Platform, req.UserID, b.scope)
+			wait = max(wait.Round(time.Second), time.Second)
+			reply := fmt.Sprintf("🐢 Lots of people are asking me things right now. Try again in %s.", wait)
+			if b.scope == "user" {
+				reply = fmt.Sprintf("🐢 Whoa, slow down a little! Try again in %s.", wait)
+			}
+			return &RateLimitError{Reply: reply, RetryAfter: wait}
+		}
+		taken = append(taken, takenToken{key, b.limit})
+	}
+
+	// Another request may have used the last one since the check above
+	if l.limits.DailyRequests > 0 && l.store.AddCounter(requestQuota, 1, nextMidnight(now)) > l.limits.DailyRequests {
+		l.store.AddCounter(requestQuota, -1, nextMidnight(now))
+		refund()
+		return l.dailyRequestsReached(req, now)
+	}
+	return nil
+}
+
+func (l *RateLimiter) dailyRequestsReached(req ChatRequest, now time.Time) *RateLimitError {
+	log.Printf("🚦 Daily request quota reached for %s user %s", req.Platform, req.UserID)
+	return &RateLimitError{
+		Reply:      fmt.Sprintf("📅 You've reached today's limit of %d AI requests. It resets at midnight UTC — see you then!", l.limits.DailyRequests),
+		RetryAfter: nextMidnight(now).Sub(now),
+	}
+}
+
+// recordTokens adds a completed request's token use, as reported by the
+// provider or estimated, to the user's daily total.
+func (l *RateLimiter) recordTokens(req ChatRequest, tokens int) {
+	if l == nil || l.limits.DailyTokens <= 0 || l.exempt(req.UserID) {
+		return
+	}
+	now := l.now()
+	l.store.AddCounter(quotaKey(req, "tokens", now), tokens, nextMidnight(now))
+}
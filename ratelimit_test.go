@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBucketsAndExemptions(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	store := NewInMemorySessionStore(DefaultSessionRetention())
+	limiter := NewRateLimiter(RateLimits{PerUser: 2, PerChannel: 3, Exempt: []string{"admin"}}, store)
+	limiter.now = func() time.Time { return now }
+
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}
+	for i := 0; i < 2; i++ {
+		if reply := limiter.allow(req).text(); reply != "" {
+			t.Fatalf("request %d limited: %s", i, reply)
+		}
+	}
+	if reply := limiter.allow(req).text(); !strings.Contains(reply, "slow down") {
+		t.Fatalf("third request should be limited, got %q", reply)
+	}
+
+	// Another user shares the channel bucket, which has one token left
+	other := req
+	other.UserID = "u2"
+	if reply := limiter.allow(other).text(); reply != "" {
+		t.Fatalf("u2 limited early: %s", reply)
+	}
+	if reply := limiter.allow(other).text(); reply == "" {
+		t.Fatal("channel limit should apply to u2")
+	}
+
+	admin := req
+	admin.UserID = "admin"
+	for i := 0; i < 10; i++ {
+		if reply := limiter.allow(admin).text(); reply != "" {
+			t.Fatalf("exempt user limited: %s", reply)
+		}
+	}
+
+	// Half a minute refills one of u1's two tokens
+	now = now.Add(30 * time.Second)
+	if reply := limiter.allow(req).text(); reply != "" {
+		t.Fatalf("bucket should have refilled: %s", reply)
+	}
+}
+
+func TestRateLimiterDailyQuotas(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	store := NewInMemorySessionStore(DefaultSessionRetention())
+	store.now = func() time.Time { return now }
+	limiter := NewRateLimiter(RateLimits{DailyRequests: 2, DailyTokens: 100}, store)
+	limiter.now = store.now
+
+	req := ChatRequest{Platform: "slack", UserID: "u1", ChannelID: "D1"}
+	limiter.allow(req).text()
+	limiter.allow(req).text()
+	if limited := limiter.allow(req); !strings.Contains(limited.text(), "limit of 2") || limited.RetryAfter != time.Hour {
+		t.Fatalf("request quota not enforced: %+v", limited)
+	}
+
+	// Quotas reset at midnight UTC
+	now = now.Add(2 * time.Hour)
+	if reply := limiter.allow(req).text(); reply != "" {
+		t.Fatalf("quota should reset the next day: %s", reply)
+	}
+	limiter.recordTokens(req, 150)
+	if reply := limiter.allow(req).text(); !strings.Contains(reply, "allowance") {
+		t.Fatalf("token quota not enforced: %q", reply)
+	}
+}
+
+func TestRateLimiterRefusalUsesNoDailyQuota(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewInMemorySessionStore(DefaultSessionRetention())
+	store.now = func() time.Time { return now }
+	limiter := NewRateLimiter(RateLimits{PerUser: 1, DailyRequests: 2}, store)
+	limiter.now = store.now
+
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}
+	if reply := limiter.allow(req).text(); reply != "" {
+		t.Fatalf("first request limited: %s", reply)
+	}
+	for i := 0; i < 3; i++ {
+		if reply := limiter.allow(req).text(); !strings.Contains(reply, "slow down") {
+			t.Fatalf("burst should be slowed down, got %q", reply)
+		}
+	}
+	// The slowed-down requests didn't count toward the two a day
+	now = now.Add(time.Minute)
+	if reply := limiter.allow(req).text(); reply != "" {
+		t.Fatalf("second request of the day limited: %s", reply)
+	}
+	now = now.Add(time.Minute)
+	if reply := limiter.allow(req).text(); !strings.Contains(reply, "limit of 2") {
+		t.Fatalf("third request of the day should hit the quota, got %q", reply)
+	}
+	// ...and neither did the user's bucket pay for a quota refusal
+	if reply := limiter.allow(req).text(); !strings.Contains(reply, "limit of 2") {
+		t.Fatalf("quota refusal took a token: %q", reply)
+	}
+}
+
+func TestRateLimiterReturnsTokensOnRefusal(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t, 10, time.Hour)
+	for _, store := range []RateStore{NewInMemorySessionStore(DefaultSessionRetention()), redisStore} {
+		now := time.Unix(1_700_000_000, 0)
+		limiter := NewRateLimiter(RateLimits{PerUser: 2, PerChannel: 1}, store)
+		limiter.now = func() time.Time { return now }
+
+		busy := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "busy"}
+		if reply := limiter.allow(busy).text(); reply != "" {
+			t.Fatalf("%T: first request limited: %s", store, reply)
+		}
+		if reply := limiter.allow(busy).text(); !strings.Contains(reply, "Lots of people") {
+			t.Fatalf("%T: channel limit should apply, got %q", store, reply)
+		}
+		// The channel refusal gave u1's token back, so one is left
+		if reply := limiter.allow(ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "quiet"}).text(); reply != "" {
+			t.Fatalf("%T: channel refusal used the user's token: %s", store, reply)
+		}
+		if reply := limiter.allow(ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "other"}).text(); !strings.Contains(reply, "slow down") {
+			t.Fatalf("%T: user bucket should be empty now, got %q", store, reply)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// rosterRefreshInterval is how often camper names are reloaded from Camp
+// Power-Up for redaction.
+const rosterRefreshInterval = 30 * time.Minute
+
+// Built-in detectors. Phone candidates are checked for a plausible digit
+// count so dates and small numbers are left alone.
+var (
+	emailPattern   = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`)
+	phonePattern   = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{2,4}\)[\s.-]?)?\d[\d\s.-]{6,}\d`)
+	addressPattern = regexp.MustCompile(`\b\d{1,5}\s+(?:[A-Z][A-Za-z]*\.?\s+){1,3}(?i:street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|court|ct|way|place|pl|terrace|circle|cir|parkway|pkwy|highway|hwy)\b\.?(?:,?\s+(?i:apt|apartment|unit|suite|#)\.?\s*\w+)?`)
+)
+
+// defaultRedactionDetectors are the detectors used when the config doesn't
+// list any. "roster" matches the full names of registered campers.
+var defaultRedactionDetectors = []string{"email", "phone", "address", "roster"}
+
+// RedactionConfig is the optional PII_REDACTION_CONFIG file; see
+// config/redaction.example.json.
+type RedactionConfig struct {
+	Detectors []string `json:"detectors"`
+	// Restore puts the original values back into replies; default true.
+	Restore  *bool              `json:"restore"`
+	Patterns []RedactionPattern `json:"patterns"`
+	// Names are always masked, in addition to the camp roster.
+	Names []string `json:"names"`
+}
+
+// RedactionPattern is a custom detector. Name becomes the placeholder
+// label, e.g. "student_id" gives [STUDENT_ID_1a2b].
+type RedactionPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// LoadRedactionConfig reads a redaction config file.
+func LoadRedactionConfig(path string) (RedactionConfig, error) {
+	var cfg RedactionConfig
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+type redactionRule struct {
+	kind    string // placeholder label, e.g. "EMAIL"
+	pattern *regexp.Regexp
+	valid   func(match string) bool // optional extra check
+	// wholeWords rejects matches inside a longer word. Unlike \b it treats
+	// accented letters as part of words.
+	wholeWords bool
+}
+
+// Redactor masks personal information in messages before they are sent to
+// any provider. Each value is replaced by a placeholder derived from it, so
+// the same email gets the same placeholder in every turn and stored history
+// stays masked. The originals are kept only for the current request, to
+// restore them in the reply.
+type Redactor struct {
+	mu      sync.RWMutex
+	rules   []redactionRule
+	roster  bool           // mask camper names
+	names   []string       // configured names
+	nameRE  *regexp.Regexp // configured and roster names
+	restore bool
+}
+
+// NewRedactor builds a redactor from the config. Unknown detectors and bad
+// patterns are errors.
+func NewRedactor(cfg RedactionConfig) (*Redactor, error) {
+	r := &Redactor{restore: cfg.Restore == nil || *cfg.Restore, names: cfg.Names}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern.Name, err)
+		}
+		kind := strings.ToUpper(strings.Map(func(c rune) rune {
+			if unicode.IsLetter(c) || unicode.IsDigit(c) {
+				return c
+			}
+			return '_'
+		}, pattern.Name))
+		if kind == "" {
+			kind = "REDACTED"
+		}
+		r.rules = append(r.rules, redactionRule{kind: kind, pattern: re})
+	}
+
+	detectors := cfg.Detectors
+	if len(detectors) == 0 {
+		detectors = defaultRedactionDetectors
+	}
+	for _, detector := range detectors {
+		switch strings.ToLower(detector) {
+		case "email":
+			r.rules = append(r.rules, redactionRule{kind: "EMAIL", pattern: emailPattern})
+		case "phone":
+			r.rules = append(r.rules, redactionRule{kind: "PHONE", pattern: phonePattern, valid: plausiblePhone})
+		case "address":
+			r.rules = append(r.rules, redactionRule{kind: "ADDRESS", pattern: addressPattern})
+		case "roster":
+			r.roster = true
+		default:
+			return nil, fmt.Errorf("unknown detector %q", detector)
+		}
+	}
+	r.SetRosterNames(nil)
+	return r, nil
+}
+
+// plausiblePhone accepts 9 to 15 digits, the range of national and E.164
+// numbers, and rejects ranges of years like 2024-2025.
+func plausiblePhone(match string) bool {
+	digits := 0
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15
+}
+
+// SetRosterNames replaces the camper names to mask. Only full names
+// ("first last") are matched; first names alone are too often ordinary words.
+func (r *Redactor) SetRosterNames(roster []string) {
+	names := append([]string(nil), r.names...)
+	if r.roster {
+		names = append(names, roster...)
+	}
+	var quoted []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		// Any run of whitespace between the parts matches
+		quoted = append(quoted, strings.ReplaceAll(regexp.QuoteMeta(name), " ", `\s+`))
+	}
+	// Longest first so "Ann Marie Smith" wins over "Ann Marie"
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+
+	var nameRE *regexp.Regexp
+	if len(quoted) > 0 {
+		nameRE = regexp.MustCompile(`(?i)(?:` + strings.Join(quoted, "|") + `)`)
+	}
+	r.mu.Lock()
+	r.nameRE = nameRE
+	r.mu.Unlock()
+}
+
+// watchRoster keeps the camper names current. Registration data stays in
+// memory and is only used to recognize names.
+func (r *Redactor) watchRoster(camp *CampClient, interval time.Duration) {
+	if !r.roster || camp == nil {
+		return
+	}
+	for ; ; time.Sleep(interval) {
+		names, err := camp.CamperNames()
+		if err != nil {
+			log.Printf("⚠️  Failed to load camp roster for redaction: %v", err)
+			continue
+		}
+		r.SetRosterNames(names)
+		log.Printf("🕶️  Redacting %d camper names", len(names))
+	}
+}
+
+// redaction is the placeholder mapping for one request.
+type redaction struct {
+	redactor *Redactor
+	req      ChatRequest
+	original map[string]string // placeholder → value
+}
+
+// begin starts redacting a request. A nil Redactor returns a nil redaction,
+// which passes text through unchanged.
+func (r *Redactor) begin(req ChatRequest) *redaction {
+	if r == nil {
+		return nil
+	}
+	return &redaction{redactor: r, req: req, original: make(map[string]string)}
+}
+
+// redact masks everything the detectors find in text.
+func (d *redaction) redact(text string) string {
+	if d == nil || text == "" {
+		return text
+	}
+	d.redactor.mu.RLock()
+	nameRE := d.redactor.nameRE
+	d.redactor.mu.RUnlock()
+
+	for _, rule := range d.redactor.rules {
+		text = d.replace(text, rule)
+	}
+	if nameRE != nil {
+		text = d.replace(text, redactionRule{kind: "NAME", pattern: nameRE, wholeWords: true})
+	}
+	return text
+}
+
+func (d *redaction) replace(text string, rule redactionRule) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range rule.pattern.FindAllStringIndex(text, -1) {
+		match := text[loc[0]:loc[1]]
+		if rule.valid != nil && !rule.valid(match) {
+			continue
+		}
+		if rule.wholeWords && !(isWordBoundary(text, loc[0], -1) && isWordBoundary(text, loc[1], 1)) {
+			continue
+		}
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(d.placeholder(rule.kind, match))
+		last = loc[1]
+	}
+	if b.Len() == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// isWordBoundary reports whether the rune before (dir -1) or at (dir 1)
+// offset i is not part of a word.
+func isWordBoundary(text string, i, dir int) bool {
+	var r rune
+	if dir < 0 {
+		if i == 0 {
+			return true
+		}
+		r, _ = utf8.DecodeLastRuneInString(text[:i])
+	} else {
+		if i == len(text) {
+			return true
+		}
+		r, _ = utf8.DecodeRuneInString(text[i:])
+	}
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
+
+// placeholder returns the stable placeholder for value, logging each value
+// the first time it is masked in the request. The value itself is never
+// logged.
+func (d *redaction) placeholder(kind, value string) string {
+	sum := sha256.Sum256([]byte(kind + "\x00" + strings.ToLower(value)))
+	digest := hex.EncodeToString(sum[:])
+	for n := 4; ; n += 4 {
+		placeholder := fmt.Sprintf("[%s_%s]", kind, digest[:n])
+		existing, seen := d.original[placeholder]
+		if !seen {
+			d.original[placeholder] = value
+			log.Printf("🕶️  Redacted %s as %s for %s user %s", strings.ToLower(kind), placeholder, d.req.Platform, d.req.UserID)
+			return placeholder
+		}
+		if strings.EqualFold(existing, value) || n >= len(digest) {
+			return placeholder
+		}
+	}
+}
+
+// attachments returns copies of the attachments with document text masked.
+// Images are passed as they are.
+func (d *redaction) attachments(attachments []Attachment) []Attachment {
+	if d == nil || len(attachments) == 0 {
+		return attachments
+	}
+	masked := make([]Attachment, len(attachments))
+	for i, a := range attachments {
+		a.Text = d.redact(a.Text)
+		masked[i] = a
+	}
+	return masked
+}
+
+// restore puts this request's original values back into a reply, when
+// restoring is enabled. Placeholders from earlier turns stay masked.
+func (d *redaction) restore(text string) string {
+	if d == nil || !d.redactor.restore || len(d.original) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(d.original))
+	for placeholder, value := range d.original {
+		pairs = append(pairs, placeholder, value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// redactorFromEnv builds the redactor when PII_REDACTION=true or
+// PII_REDACTION_CONFIG is set, and returns nil otherwise.
+func redactorFromEnv() *Redactor {
+	path := os.Getenv("PII_REDACTION_CONFIG")
+	if path == "" && !strings.EqualFold(os.Getenv("PII_REDACTION"), "true") {
+		return nil
+	}
+	var cfg RedactionConfig
+	if path != "" {
+		var err error
+		if cfg, err = LoadRedactionConfig(path); err != nil {
+			log.Printf("❌ Failed to load redaction config, using the defaults: %v", err)
+			cfg = RedactionConfig{}
+		}
+	}
+	redactor, err := NewRedactor(cfg)
+	if err != nil {
+		log.Printf("❌ Invalid redaction config, using the defaults: %v", err)
+		redactor, _ = NewRedactor(RedactionConfig{})
+	}
+	return redactor
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var placeholderPattern = regexp.MustCompile(`\[[A-Z_]+_[0-9a-f]{4,}\]`)
+
+func TestRedactorMasksBuiltInDetectors(t *testing.T) {
+	redactor, err := NewRedactor(RedactionConfig{Names: []string{"José Álvarez"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor.SetRosterNames([]string{"Maya  Chen"})
+	d := redactor.begin(ChatRequest{Platform: "discord", UserID: "u1"})
+
+	message := "Email jo.parent+camp@example.org or call (555) 123-4567 / +44 20 7946 0958. " +
+		"We live at 42 Maple Grove Lane, Apt 3. Is maya chen signed up? José Álvarez too. " +
+		"Camp runs 2024-2025, June 10, room 12; Josée Álvarezz and user <@123456789012345678> are fine."
+	masked := d.redact(message)
+
+	for _, secret := range []string{"jo.parent", "123-4567", "7946", "Maple Grove", "maya chen", "José Álvarez "} {
+		if strings.Contains(masked, secret) {
+			t.Fatalf("%q not masked:\n%s", secret, masked)
+		}
+	}
+	for _, kept := range []string{"2024-2025", "June 10, room 12", "Josée Álvarezz", "<@123456789012345678>"} {
+		if !strings.Contains(masked, kept) {
+			t.Fatalf("%q should be left alone:\n%s", kept, masked)
+		}
+	}
+	if n := len(placeholderPattern.FindAllString(masked, -1)); n != 6 {
+		t.Fatalf("expected 6 placeholders, got %d:\n%s", n, masked)
+	}
+
+	// Placeholders are stable across requests so history stays consistent
+	again := redactor.begin(ChatRequest{}).redact("write to JO.PARENT+camp@example.org")
+	if !strings.Contains(masked, strings.TrimPrefix(again, "write to ")) {
+		t.Fatalf("placeholder changed between requests: %q", again)
+	}
+	if restored := d.restore(masked); restored != message {
+		t.Fatalf("restore mismatch:\n%s", restored)
+	}
+}
+
+func TestRedactorCustomPatternsAndOptions(t *testing.T) {
+	restore := false
+	redactor, err := NewRedactor(RedactionConfig{
+		Detectors: []string{"email"},
+		Restore:   &restore,
+		Patterns:  []RedactionPattern{{Name: "student id", Pattern: `\bS\d{7}\b`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := redactor.begin(ChatRequest{})
+	masked := d.redact("S1234567 can be reached at 555-123-4567")
+	if !strings.HasPrefix(masked, "[STUDENT_ID_") || !strings.Contains(masked, "555-123-4567") {
+		t.Fatalf("unexpected redaction %q", masked)
+	}
+	if d.restore(masked) != masked {
+		t.Fatal("restore should be off")
+	}
+
+	if _, err := NewRedactor(RedactionConfig{Detectors: []string{"ssn"}}); err == nil {
+		t.Fatal("expected an error for an unknown detector")
+	}
+	if _, err := NewRedactor(RedactionConfig{Patterns: []RedactionPattern{{Name: "bad", Pattern: "("}}}); err == nil {
+		t.Fatal("expected an error for a bad pattern")
+	}
+}
+
+func TestRespondRedactsBeforeProviders(t *testing.T) {
+	var sent []string
+	provider := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			sent = append(sent, message)
+			for _, msg := range session.History(10) {
+				sent = append(sent, msg.Content)
+			}
+			return Reply{Text: "I'll email " + placeholderPattern.FindString(message) + " today."}, nil
+		},
+	}
+	redactor, err := NewRedactor(RedactionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewAIService(nil, nil, provider)
+	svc.SetRedactor(redactor)
+	req := ChatRequest{
+		Platform: "slack", UserID: "u1", ChannelID: "c1",
+		Message:     "Please contact sam@example.com",
+		Attachments: []Attachment{{Name: "form.txt", MIMEType: "text/plain", Text: "Phone: 555 867 5309"}},
+	}
+
+	var streamed string
+	reply := svc.RespondStream(context.Background(), req, func(text string) { streamed = text })
+	if reply != "I'll email sam@example.com today." {
+		t.Fatalf("reply not restored: %q", reply)
+	}
+	if streamed != "" && streamed != reply {
+		t.Fatalf("streamed text not restored: %q", streamed)
+	}
+
+	svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", ChannelID: "c1", Message: "thanks"})
+	for _, text := range sent {
+		if strings.Contains(text, "sam@example.com") || strings.Contains(text, "867") {
+			t.Fatalf("PII reached the provider: %q", text)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds each store operation; SessionStore calls have no context.
+const redisTimeout = 2 * time.Second
+
+// takeTokenScript refills and takes from a token bucket atomically. ARGV is
+// capacity, refill rate in tokens per millisecond, now in milliseconds and
+// the key TTL in milliseconds. Returns {allowed, remaining tokens}.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, tostring(tokens)}
+`)
+
+// returnTokenScript puts one token back in a bucket written by
+// takeTokenScript, up to its capacity (ARGV[1]).
+var returnTokenScript = redis.NewScript(`
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens then
+	redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(tonumber(ARGV[1]), tokens + 1)))
+end
+return 0
+`)
+
+// appendScript appends a message to a session only while its metadata still
+// carries the session's ID, so a request in flight when the session was
+// forgotten can't recreate it. KEYS are the metadata and message keys; ARGV
+// is the ID, the message, MaxMessages, updated_at and the TTL in
+// milliseconds. Returns 1 when the message was stored.
+var appendScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+	return 0
+end
+redis.call('RPUSH', KEYS[2], ARGV[2])
+redis.call('LTRIM', KEYS[2], -tonumber(ARGV[3]), -1)
+redis.call('HSET', KEYS[1], 'updated_at', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+redis.call('PEXPIRE', KEYS[2], ARGV[5])
+return 1
+`)
+
+// setFieldsScript sets metadata fields (ARGV after the session ID) only
+// while the session still exists, for the same reason as appendScript.
+var setFieldsScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+return 1
+`)
+
+// RedisSessionStore persists sessions in Redis so conversations survive
+// restarts. Each session is a hash of metadata plus a list of JSON-encoded
+// messages, trimmed to MaxMessages entries, and both keys expire after
+// IdleTTL of inactivity. A sorted set indexes sessions by last use so the
+// least recently used are deleted once there are more than MaxSessions.
+// Every update is a MULTI/EXEC transaction or a script, so concurrent
+// requests from several processes never interleave partial writes.
+type RedisSessionStore struct {
+	client    *redis.Client
+	prefix    string
+	index     string
+	retention SessionRetention
+}
+
+// NewRedisSessionStore connects to the Redis server at redisURL
+// (redis://[:password@]host:port/db) and verifies the connection.
+func NewRedisSessionStore(redisURL string, retention SessionRetention) (*RedisSessionStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+
+	return &RedisSessionStore{
+		client:    client,
+		prefix:    "kit:session:",
+		index:     "kit:sessions",
+		retention: retention.withDefaults(),
+	}, nil
+}
+
+// Retention returns the limits the store keeps sessions to.
+func (s *RedisSessionStore) Retention() SessionRetention {
+	return s.retention
+}
+
+func (s *RedisSessionStore) keys(platform, userID, channelID string) (string, string) {
+	key := s.prefix + sessionKey(platform, userID, channelID)
+	return key, key + ":messages"
+}
+
+// touch queues marking the session as used in the index and dropping index
+// entries whose keys have already expired.
+func (s *RedisSessionStore) touch(ctx context.Context, pipe redis.Pipeliner, metaKey string, now time.Time) {
+	pipe.ZAdd(ctx, s.index, redis.Z{Score: float64(now.UnixNano()), Member: metaKey})
+	cutoff := now.Add(-s.retention.IdleTTL).UnixNano()
+	pipe.ZRemRangeByScore(ctx, s.index, "-inf", fmt.Sprintf("(%d", cutoff))
+}
+
+// evictExcess deletes the least recently used sessions beyond MaxSessions.
+func (s *RedisSessionStore) evictExcess(ctx context.Context) {
+	excess := s.client.ZCard(ctx, s.index).Val() - int64(s.retention.MaxSessions)
+	if excess <= 0 {
+		return
+	}
+	victims, err := s.client.ZPopMin(ctx, s.index, excess).Result()
+	if err != nil {
+		return
+	}
+	keys := make([]string, 0, 2*len(victims))
+	for _, z := range victims {
+		if key, ok := z.Member.(string); ok {
+			keys = append(keys, key, key+":messages")
+		}
+	}
+	if len(keys) > 0 {
+		s.client.Del(ctx, keys...)
+		log.Printf("🧹 Evicted %d least recently used Redis sessions", len(victims))
+	}
+}
+
+// GetOrCreate loads the session and its recent history from Redis, creating
+// it when missing. If Redis is unreachable it returns an empty, unsaved
+// session so the conversation can still continue without history.
+func (s *RedisSessionStore) GetOrCreate(platform, userID, channelID string) *Session {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := time.Now()
+	session := &Session{
+		ID:        fmt.Sprintf("%s-%s-%d", platform, userID, now.UnixNano()),
+		Platform:  platform,
+		UserID:    userID,
+		ChannelID: channelID,
+		UpdatedAt: now,
+	}
+
+	metaKey, messagesKey := s.keys(platform, userID, channelID)
+	var idCmd *redis.StringCmd
+	var summaryCmd *redis.SliceCmd
+	var messagesCmd *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, metaKey, "id", session.ID)
+		pipe.HSet(ctx, metaKey,
+			"platform", platform,
+			"user_id", userID,
+			"channel_id", channelID,
+			"updated_at", now.Unix(),
+		)
+		idCmd = pipe.HGet(ctx, metaKey, "id")
+		summaryCmd = pipe.HMGet(ctx, metaKey, "summary", "summarized_through", "provider")
+		messagesCmd = pipe.LRange(ctx, messagesKey, int64(-s.retention.MaxMessages), -1)
+		pipe.Expire(ctx, metaKey, s.retention.IdleTTL)
+		pipe.Expire(ctx, messagesKey, s.retention.IdleTTL)
+		s.touch(ctx, pipe, metaKey, now)
+		return nil
+	})
+	if err != nil {
+		log.Printf("⚠️  Redis session load failed, continuing without history: %v", err)
+		return session
+	}
+	s.evictExcess(ctx)
+
+	session.ID = idCmd.Val()
+	if fields := summaryCmd.Val(); len(fields) == 3 {
+		if summary, ok := fields[0].(string); ok {
+			session.Summary = summary
+		}
+		if through, ok := fields[1].(string); ok {
+			if nanos, err := strconv.ParseInt(through, 10, 64); err == nil {
+				session.SummarizedThrough = time.Unix(0, nanos)
+			}
+		}
+		if provider, ok := fields[2].(string); ok {
+			session.Provider = provider
+		}
+	}
+	for _, raw := range messagesCmd.Val() {
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			continue // skip entries written by an incompatible version
+		}
+		session.Messages = append(session.Messages, msg)
+	}
+	return session
+}
+
+// Append records a message in Redis and on the in-process session,
+// trimming both to MaxMessages.
+func (s *RedisSessionStore) Append(session *Session, role, content string) {
+	if session == nil || strings.TrimSpace(content) == "" {
+		return
+	}
+
+	msg := ChatMessage{Role: role, Content: strings.TrimSpace(content), Timestamp: time.Now()}
+	session.mu.Lock()
+	session.Messages = s.retention.trim(append(session.Messages, msg))
+	session.UpdatedAt = msg.Timestamp
+	id, platform, userID, channelID := session.ID, session.Platform, session.UserID, session.ChannelID
+	session.mu.Unlock()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	metaKey, messagesKey := s.keys(platform, userID, channelID)
+	stored, err := appendScript.Run(ctx, s.client, []string{metaKey, messagesKey},
+		id, data, s.retention.MaxMessages, msg.Timestamp.Unix(), s.retention.IdleTTL.Milliseconds()).Int()
+	if err != nil {
+		log.Printf("⚠️  Redis session append failed: %v", err)
+		return
+	}
+	if stored == 0 {
+		return // forgotten or expired meanwhile
+	}
+	pipe := s.client.Pipeline()
+	s.touch(ctx, pipe, metaKey, msg.Timestamp)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("⚠️  Redis session index update failed: %v", err)
+	}
+}
+
+// SetSummary stores the rolling summary on the session and in Redis.
+func (s *RedisSessionStore) SetSummary(session *Session, summary string, through time.Time) {
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	session.Summary = strings.TrimSpace(summary)
+	session.SummarizedThrough = through
+	summary = session.Summary
+	id, platform, userID, channelID := session.ID, session.Platform, session.UserID, session.ChannelID
+	session.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	metaKey, _ := s.keys(platform, userID, channelID)
+	err := setFieldsScript.Run(ctx, s.client, []string{metaKey}, id, "summary", summary, "summarized_through", through.UnixNano()).Err()
+	if err != nil {
+		log.Printf("⚠️  Redis session summary update failed: %v", err)
+	}
+}
+
+// SetProvider stores the pinned provider on the session and in Redis.
+func (s *RedisSessionStore) SetProvider(session *Session, provider string) {
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	session.Provider = provider
+	id, platform, userID, channelID := session.ID, session.Platform, session.UserID, session.ChannelID
+	session.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	metaKey, _ := s.keys(platform, userID, channelID)
+	if err := setFieldsScript.Run(ctx, s.client, []string{metaKey}, id, "provider", provider).Err(); err != nil {
+		log.Printf("⚠️  Redis session provider update failed: %v", err)
+	}
+}
+
+// TakeToken runs the token bucket in Redis so every process shares it. If
+// Redis is unreachable the request is allowed rather than blocking chat.
+func (s *RedisSessionStore) TakeToken(key string, capacity int, per time.Duration, now time.Time) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	rate := float64(capacity) / float64(per.Milliseconds())
+	res, err := takeTokenScript.Run(ctx, s.client, []string{"kit:" + key},
+		capacity, strconv.FormatFloat(rate, 'f', -1, 64), now.UnixMilli(), per.Milliseconds()).Slice()
+	if err != nil || len(res) != 2 {
+		log.Printf("⚠️  Redis rate limit check failed: %v", err)
+		return true, 0
+	}
+	if allowed, _ := res[0].(int64); allowed == 1 {
+		return true, 0
+	}
+	remaining, _ := strconv.ParseFloat(fmt.Sprint(res[1]), 64)
+	return false, time.Duration((1-remaining)/rate) * time.Millisecond
+}
+
+// ReturnToken puts a token back in the bucket.
+func (s *RedisSessionStore) ReturnToken(key string, capacity int) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := returnTokenScript.Run(ctx, s.client, []string{"kit:" + key}, capacity).Err(); err != nil {
+		log.Printf("⚠️  Redis rate limit refund failed: %v", err)
+	}
+}
+
+// AddCounter increments a counter that expires at expires.
+func (s *RedisSessionStore) AddCounter(key string, delta int, expires time.Time) int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, "kit:"+key, int64(delta))
+		pipe.ExpireAt(ctx, "kit:"+key, expires)
+		return nil
+	})
+	if err != nil {
+		log.Printf("⚠️  Redis counter update failed: %v", err)
+		return 0
+	}
+	return int(incr.Val())
+}
+
+// Counter returns the counter's value, or 0 if it is missing or Redis is
+// unreachable.
+func (s *RedisSessionStore) Counter(key string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	n, _ := s.client.Get(ctx, "kit:"+key).Int()
+	return n
+}
+
+// userDataTimeout bounds the export and forget commands, which scan the
+// keyspace for the user's sessions.
+const userDataTimeout = 10 * time.Second
+
+// userMetaKeys finds the metadata keys of every session the user has.
+func (s *RedisSessionStore) userMetaKeys(ctx context.Context, platform, userID string) ([]string, error) {
+	base := s.prefix + sessionKey(platform, userID, "")
+	escaped := redisGlobEscaper.Replace(base)
+	var keys []string
+	for _, pattern := range []string{escaped, escaped + ":*"} {
+		iter := s.client.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			if !strings.HasSuffix(key, ":messages") {
+				keys = append(keys, key)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// redisGlobEscaper quotes the characters SCAN MATCH treats as wildcards.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// UserSessions loads every session the user has from Redis.
+func (s *RedisSessionStore) UserSessions(platform, userID string) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), userDataTimeout)
+	defer cancel()
+
+	keys, err := s.userMetaKeys(ctx, platform, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(keys))
+	for _, metaKey := range keys {
+		meta, err := s.client.HGetAll(ctx, metaKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := s.client.LRange(ctx, metaKey+":messages", 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		session := &Session{ID: meta["id"], Platform: platform, UserID: userID, ChannelID: meta["channel_id"], Summary: meta["summary"], Provider: meta["provider"]}
+		if updated, err := strconv.ParseInt(meta["updated_at"], 10, 64); err == nil {
+			session.UpdatedAt = time.Unix(updated, 0)
+		}
+		if through, err := strconv.ParseInt(meta["summarized_through"], 10, 64); err == nil {
+			session.SummarizedThrough = time.Unix(0, through)
+		}
+		for _, data := range raw {
+			var msg ChatMessage
+			if json.Unmarshal([]byte(data), &msg) == nil {
+				session.Messages = append(session.Messages, msg)
+			}
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].UpdatedAt.Before(sessions[j].UpdatedAt) })
+	return sessions, nil
+}
+
+// ForgetUser deletes every session the user has and drops them from the
+// index.
+func (s *RedisSessionStore) ForgetUser(platform, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), userDataTimeout)
+	defer cancel()
+
+	keys, err := s.userMetaKeys(ctx, platform, userID)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, metaKey := range keys {
+			pipe.Del(ctx, metaKey, metaKey+":messages")
+			pipe.ZRem(ctx, s.index, metaKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// ForgetSession deletes the user's session in channelID and drops it from
+// the index.
+func (s *RedisSessionStore) ForgetSession(platform, userID, channelID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	metaKey, messagesKey := s.keys(platform, userID, channelID)
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, metaKey, messagesKey)
+		pipe.ZRem(ctx, s.index, metaKey)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted.Val() > 0, nil
+}
+
+// Close releases the Redis connection pool.
+func (s *RedisSessionStore) Close() error {
+	return s.client.Close()
+}
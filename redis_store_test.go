@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisStore(t *testing.T, maxHistory int, ttl time.Duration) (*RedisSessionStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	retention := SessionRetention{MaxMessages: maxHistory, IdleTTL: ttl}
+	store, err := NewRedisSessionStore("redis://"+mr.Addr(), retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, mr
+}
+
+func TestRedisSessionStorePersistsAndTrims(t *testing.T) {
+	store, _ := newTestRedisStore(t, 4, time.Hour)
+
+	session := store.GetOrCreate("discord", "u1", "c1")
+	for i := 0; i < 6; i++ {
+		store.Append(session, "user", fmt.Sprintf("message %d", i))
+	}
+
+	// A fresh load (as after a restart) sees the same ID and trimmed history
+	reloaded := store.GetOrCreate("discord", "u1", "c1")
+	if reloaded.ID != session.ID {
+		t.Fatalf("session ID changed: %q != %q", reloaded.ID, session.ID)
+	}
+	if len(reloaded.Messages) != 4 || reloaded.Messages[0].Content != "message 2" {
+		t.Fatalf("history not trimmed to last 4: %+v", reloaded.Messages)
+	}
+	if len(session.Messages) != 4 {
+		t.Fatalf("in-process session not trimmed: %d messages", len(session.Messages))
+	}
+}
+
+func TestRedisSessionStoreExpires(t *testing.T) {
+	store, mr := newTestRedisStore(t, 10, time.Hour)
+
+	session := store.GetOrCreate("slack", "u1", "D1")
+	store.Append(session, "user", "hello")
+	mr.FastForward(2 * time.Hour)
+
+	if got := store.GetOrCreate("slack", "u1", "D1"); len(got.Messages) != 0 || got.ID == session.ID {
+		t.Fatalf("expired session was not recreated: %+v", got)
+	}
+}
+
+func TestRedisSessionStoreConcurrentAppends(t *testing.T) {
+	store, _ := newTestRedisStore(t, 100, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := store.GetOrCreate("discord", "u1", "c1")
+			store.Append(session, "user", fmt.Sprintf("m%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(store.GetOrCreate("discord", "u1", "c1").Messages); got != 20 {
+		t.Fatalf("expected 20 messages after concurrent appends, got %d", got)
+	}
+}
+
+func TestRedisSessionStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store, mr := newTestRedisStore(t, 10, time.Hour)
+	store.retention.MaxSessions = 2
+
+	for _, user := range []string{"u1", "u2", "u3"} {
+		store.Append(store.GetOrCreate("discord", user, "c1"), "user", "hi")
+	}
+
+	if mr.Exists("kit:session:discord:u1:c1") {
+		t.Fatal("least recently used session should have been evicted")
+	}
+	if !mr.Exists("kit:session:discord:u3:c1") {
+		t.Fatal("newest session should be kept")
+	}
+}
+
+func TestRedisSessionStoreRateState(t *testing.T) {
+	store, _ := newTestRedisStore(t, 10, time.Hour)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := store.TakeToken("rate:user:discord:u1", 2, time.Minute, now); !ok {
+			t.Fatalf("token %d refused", i)
+		}
+	}
+	ok, wait := store.TakeToken("rate:user:discord:u1", 2, time.Minute, now)
+	if ok || wait <= 0 || wait > 30*time.Second {
+		t.Fatalf("empty bucket: ok=%v wait=%s", ok, wait)
+	}
+
+	store.AddCounter("quota:requests:x", 2, now.Add(time.Hour))
+	if got := store.AddCounter("quota:requests:x", 3, now.Add(time.Hour)); got != 5 || store.Counter("quota:requests:x") != 5 {
+		t.Fatalf("counter = %d, want 5", got)
+	}
+}
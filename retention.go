@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultSessionTTL is how long an idle conversation is kept.
+	defaultSessionTTL = 7 * 24 * time.Hour
+	// defaultMaxHistory matches MAX_CONVERSATION_HISTORY in .env.example.
+	defaultMaxHistory = 10
+	// defaultMaxSessions caps how many conversations a store keeps at once.
+	defaultMaxSessions = 5000
+	// sweepInterval is how often the local stores look for idle sessions.
+	sweepInterval = time.Minute
+)
+
+// SessionRetention bounds what a SessionStore keeps. Every backend applies
+// it the same way: each session holds at most MaxMessages messages, sessions
+// idle for longer than IdleTTL are dropped, and once there are more than
+// MaxSessions sessions the least recently used ones are evicted.
+type SessionRetention struct {
+	MaxMessages int
+	IdleTTL     time.Duration
+	MaxSessions int
+}
+
+// DefaultSessionRetention returns the limits used when nothing is configured.
+func DefaultSessionRetention() SessionRetention {
+	return SessionRetention{
+		MaxMessages: defaultMaxHistory,
+		IdleTTL:     defaultSessionTTL,
+		MaxSessions: defaultMaxSessions,
+	}
+}
+
+// sessionRetentionFromEnv reads MAX_CONVERSATION_HISTORY, SESSION_TTL and
+// MAX_SESSIONS, falling back to the defaults for unset or invalid values.
+func sessionRetentionFromEnv() SessionRetention {
+	r := DefaultSessionRetention()
+	if n, err := strconv.Atoi(os.Getenv("MAX_CONVERSATION_HISTORY")); err == nil && n > 0 {
+		r.MaxMessages = n
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && ttl > 0 {
+		r.IdleTTL = ttl
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_SESSIONS")); err == nil && n > 0 {
+		r.MaxSessions = n
+	}
+	return r
+}
+
+// withDefaults fills zero or negative limits with the defaults.
+func (r SessionRetention) withDefaults() SessionRetention {
+	d := DefaultSessionRetention()
+	if r.MaxMessages <= 0 {
+		r.MaxMessages = d.MaxMessages
+	}
+	if r.IdleTTL <= 0 {
+		r.IdleTTL = d.IdleTTL
+	}
+	if r.MaxSessions <= 0 {
+		r.MaxSessions = d.MaxSessions
+	}
+	return r
+}
+
+// trim drops the oldest messages beyond MaxMessages. AIService summarizes
+// messages before a reply would push them out (see summarizeBeforeTrim).
+func (r SessionRetention) trim(messages []ChatMessage) []ChatMessage {
+	if len(messages) <= r.MaxMessages {
+		return messages
+	}
+	return append([]ChatMessage(nil), messages[len(messages)-r.MaxMessages:]...)
+}
+
+// RetentionStore is a SessionStore that reports the limits it keeps
+// sessions to, so messages can be summarized before they are trimmed.
+type RetentionStore interface {
+	Retention() SessionRetention
+}
+
+// evictions returns the keys to drop from sessions: every session idle
+// since before now-IdleTTL, then the least recently used until at most
+// MaxSessions remain. Callers must hold the store lock.
+func (r SessionRetention) evictions(sessions map[string]*Session, now time.Time) []string {
+	type entry struct {
+		key     string
+		updated time.Time
+	}
+	cutoff := now.Add(-r.IdleTTL)
+	var evict []string
+	live := make([]entry, 0, len(sessions))
+	for key, session := range sessions {
+		session.mu.Lock()
+		updated := session.UpdatedAt
+		session.mu.Unlock()
+		if updated.Before(cutoff) {
+			evict = append(evict, key)
+			continue
+		}
+		live = append(live, entry{key, updated})
+	}
+
+	if excess := len(live) - r.MaxSessions; excess > 0 {
+		sort.Slice(live, func(i, j int) bool { return live[i].updated.Before(live[j].updated) })
+		for _, e := range live[:excess] {
+			evict = append(evict, e.key)
+		}
+	}
+	return evict
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// retryPolicy retries provider HTTP calls that were rate limited or hit a
+// server error, with jittered exponential backoff. A Retry-After from the
+// provider replaces the backoff. Waits that would not fit before the request
+// deadline, or are longer than maxWait, are not taken: the call fails so the
+// next provider in the chain can answer instead.
+type retryPolicy struct {
+	attempts int           // total tries, including the first
+	base     time.Duration // first backoff, doubled on each retry
+	maxWait  time.Duration // longest single wait worth taking
+}
+
+var defaultRetryPolicy = retryPolicy{attempts: 3, base: 500 * time.Millisecond, maxWait: 10 * time.Second}
+
+// backoff returns the wait before retry number n (1-based): a random
+// duration between half and all of base·2ⁿ⁻¹.
+func (p retryPolicy) backoff(n int) time.Duration {
+	wait := p.base << (n - 1)
+	if wait <= 0 || wait > p.maxWait {
+		wait = p.maxWait
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// do calls send until it succeeds, fails with an error that isn't worth
+// retrying, or the attempts run out. send must build a fresh request each
+// time it is called.
+func (p retryPolicy) do(ctx context.Context, provider string, send func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := send()
+		if err == nil {
+			return resp, nil
+		}
+		class := classifyError(err)
+		if !class.retryable() || attempt >= p.attempts {
+			return nil, err
+		}
+
+		wait := p.backoff(attempt)
+		if _, retryAfter := providerStatusCode(err); retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > p.maxWait {
+			log.Printf("⏭️  %s %s, not waiting %s to retry", provider, class, wait.Round(time.Second))
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			log.Printf("⏭️  %s %s, no time left to retry", provider, class)
+			return nil, err
+		}
+
+		log.Printf("🔁 %s %s, retrying in %s (attempt %d of %d)", provider, class, wait.Round(10*time.Millisecond), attempt+1, p.attempts)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
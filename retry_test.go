@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyServer answers with the given statuses in turn, then 200. Errors
+// carry *retryAfter as their Retry-After header.
+func flakyServer(t *testing.T, calls *int, retryAfter *string, statuses ...int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if *calls <= len(statuses) {
+			w.Header().Set("Retry-After", *retryAfter)
+			w.WriteHeader(statuses[*calls-1])
+			w.Write([]byte(`{"error":{"message":"slow down","type":"rate_limit_exceeded"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi"}}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCompatClientRetriesTransientErrors(t *testing.T) {
+	var calls int
+	var retryAfter string
+	client := NewOpenAICompatClient(flakyServer(t, &calls, &retryAfter, 503, 429).URL, "key", "m", "groq")
+	client.retry = retryPolicy{attempts: 3, base: time.Millisecond, maxWait: time.Second}
+
+	reply, err := client.GenerateResponse(context.Background(), Prompt{Message: "hello"})
+	if err != nil || reply.Text != "hi" || calls != 3 {
+		t.Fatalf("reply %q, err %v after %d calls", reply.Text, err, calls)
+	}
+
+	calls = 0
+	client.retry.attempts = 2
+	_, err = client.GenerateResponse(context.Background(), Prompt{Message: "hello"})
+	if classifyError(err) != errorRateLimited || calls != 2 {
+		t.Fatalf("expected to give up rate limited after 2 calls, got %v after %d", err, calls)
+	}
+	if !strings.Contains(err.Error(), "rate_limit_exceeded: slow down") {
+		t.Fatalf("error body not kept: %v", err)
+	}
+}
+
+func TestCompatClientDoesNotWaitPastLimits(t *testing.T) {
+	var calls int
+	var retryAfter string
+	server := flakyServer(t, &calls, &retryAfter, 400, 429, 429)
+	client := NewOpenAICompatClient(server.URL, "key", "m", "groq")
+	client.retry = retryPolicy{attempts: 3, base: time.Millisecond, maxWait: 5 * time.Second}
+
+	// A bad request is not worth repeating
+	if _, err := client.GenerateResponse(context.Background(), Prompt{Message: "hello"}); classifyError(err) != errorBadRequest || calls != 1 {
+		t.Fatalf("bad request retried: %v after %d calls", err, calls)
+	}
+
+	// Retry-After longer than the longest wait: move on to the next provider
+	retryAfter = "30"
+	if _, err := client.GenerateResponse(context.Background(), Prompt{Message: "hello"}); err == nil || calls != 2 {
+		t.Fatalf("waited for a long Retry-After: %v after %d calls", err, calls)
+	}
+
+	// Retry-After past the request deadline
+	retryAfter = "1"
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.GenerateResponse(ctx, Prompt{Message: "hello"}); err == nil || calls != 3 || time.Since(start) > 150*time.Millisecond {
+		t.Fatalf("retried past the deadline: %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryBackoffIsJitteredAndCapped(t *testing.T) {
+	policy := retryPolicy{attempts: 5, base: 100 * time.Millisecond, maxWait: 300 * time.Millisecond}
+	for n, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 6: 300 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if wait := policy.backoff(n); wait < limit/2 || wait > limit {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", n, wait, limit/2, limit)
+			}
+		}
+	}
+}
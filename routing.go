@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// RoutingPolicy picks the provider chain for each ChatRequest. Routes are
+// checked in order and the first match wins; requests matching no route use
+// Default, or every registered provider in registration order when Default
+// is empty. Policies are loaded from a JSON file (AI_ROUTING_CONFIG); see
+// config/routing.example.json.
+type RoutingPolicy struct {
+	Default []string `json:"default"`
+	Routes  []Route  `json:"routes"`
+}
+
+// Route sends matching requests to Providers, in order. Unless Exclusive is
+// set, the rest of the default chain is tried afterwards as a fallback.
+type Route struct {
+	Name      string     `json:"name"`
+	Match     RouteMatch `json:"match"`
+	Providers []string   `json:"providers"`
+	Exclusive bool       `json:"exclusive"`
+}
+
+// RouteMatch lists the conditions a request must meet. Every condition that
+// is set must hold; list conditions match when any entry matches.
+type RouteMatch struct {
+	Platforms     []string `json:"platforms"`
+	Channels      []string `json:"channels"`
+	Users         []string `json:"users"`
+	DirectMessage *bool    `json:"direct_message"`
+	MinLength     int      `json:"min_length"`
+	MaxLength     int      `json:"max_length"`
+	Keywords      []string `json:"keywords"`
+	Pattern       string   `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// LoadRoutingPolicy reads and validates a routing policy file.
+func LoadRoutingPolicy(path string) (*RoutingPolicy, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return nil, err
+	}
+
+	var policy RoutingPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range policy.Routes {
+		route := &policy.Routes[i]
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i+1)
+		}
+		if len(route.Providers) == 0 {
+			return nil, fmt.Errorf("route %q has no providers", route.Name)
+		}
+		if route.Match.Pattern != "" {
+			route.Match.pattern, err = regexp.Compile(route.Match.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: bad pattern: %w", route.Name, err)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// ProviderNames returns every provider name the policy refers to.
+func (p *RoutingPolicy) ProviderNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(list []string) {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	add(p.Default)
+	for _, route := range p.Routes {
+		add(route.Providers)
+	}
+	return names
+}
+
+// resolve returns the matching route name and the provider names to try in
+// order. registered is the full registration order, used when the policy has
+// no explicit default chain.
+func (p *RoutingPolicy) resolve(req ChatRequest, registered []string) (string, []string) {
+	defaults := p.Default
+	if len(defaults) == 0 {
+		defaults = registered
+	}
+
+	for _, route := range p.Routes {
+		if !route.Match.matches(req) {
+			continue
+		}
+		if route.Exclusive {
+			return route.Name, route.Providers
+		}
+		chain := append([]string{}, route.Providers...)
+		for _, name := range defaults {
+			if !slices.Contains(chain, name) {
+				chain = append(chain, name)
+			}
+		}
+		return route.Name, chain
+	}
+	return "default", defaults
+}
+
+func (m RouteMatch) matches(req ChatRequest) bool {
+	if len(m.Platforms) > 0 && !containsFold(m.Platforms, req.Platform) {
+		return false
+	}
+	if len(m.Channels) > 0 && !slices.Contains(m.Channels, req.ChannelID) {
+		return false
+	}
+	if len(m.Users) > 0 && !slices.Contains(m.Users, req.UserID) {
+		return false
+	}
+	if m.DirectMessage != nil && *m.DirectMessage != req.DirectMessage {
+		return false
+	}
+
+	length := utf8.RuneCountInString(req.Message)
+	if m.MinLength > 0 && length < m.MinLength {
+		return false
+	}
+	if m.MaxLength > 0 && length > m.MaxLength {
+		return false
+	}
+	if len(m.Keywords) > 0 {
+		lower := strings.ToLower(req.Message)
+		found := false
+		for _, kw := range m.Keywords {
+			if strings.Contains(lower, strings.ToLower(kw)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.pattern != nil && !m.pattern.MatchString(req.Message) {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoutingPolicyResolve(t *testing.T) {
+	policy, err := LoadRoutingPolicy("config/routing.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := []string{"groq", "gemini", "claude", "ollama"}
+
+	tests := []struct {
+		name      string
+		req       ChatRequest
+		wantRoute string
+		wantChain []string
+	}{
+		{
+			name:      "engineering channel prefers claude then falls back",
+			req:       ChatRequest{Platform: "slack", ChannelID: "C0123ENGINEERING", Message: "Can you review this design doc for the new deployment pipeline please?"},
+			wantRoute: "engineering",
+			wantChain: []string{"claude", "groq", "gemini"},
+		},
+		{
+			name:      "direct messages go to ollama first",
+			req:       ChatRequest{Platform: "discord", DirectMessage: true, Message: "hi"},
+			wantRoute: "direct-messages",
+			wantChain: []string{"ollama", "groq", "gemini", "claude"},
+		},
+		{
+			name:      "short question is exclusive",
+			req:       ChatRequest{Platform: "discord", ChannelID: "123", Message: "what is kit?"},
+			wantRoute: "short-questions",
+			wantChain: []string{"groq"},
+		},
+		{
+			name:      "everything else uses the default chain",
+			req:       ChatRequest{Platform: "discord", ChannelID: "123", Message: "Explain in detail how the camp registration process works for returning campers and their parents."},
+			wantRoute: "default",
+			wantChain: []string{"groq", "gemini", "claude"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, chain := policy.resolve(tt.req, registered)
+			if route != tt.wantRoute || !reflect.DeepEqual(chain, tt.wantChain) {
+				t.Fatalf("resolve() = %q %v, want %q %v", route, chain, tt.wantRoute, tt.wantChain)
+			}
+		})
+	}
+}
@@ -2,55 +2,175 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
-// ChatRequest is the shared boundary used by platform adapters and future MCP clients.
+// ChatRequest is the shared boundary used by platform adapters, the MCP server
+// and the HTTP chat API.
 type ChatRequest struct {
 	Platform  string
 	UserID    string
 	ChannelID string
-	Message   string
+	// WorkspaceID is the Discord guild or Slack team the message came from.
+	WorkspaceID   string
+	Message       string
+	DirectMessage bool
+	// HasCampRole is set by the Discord adapter when the user holds the
+	// role that grants camp data access (CAMP_ALLOWED_ROLE).
+	HasCampRole bool
+	// Attachments are the files sent with the message, already downloaded
+	// and checked against the attachment limits.
+	Attachments []Attachment
+	// LoadAttachments, when set, downloads files sent with the message.
+	// It is only called once the rate limiter has let the request through,
+	// so a refused request costs no downloads.
+	LoadAttachments func(ctx context.Context) []Attachment
 }
 
+// defaultRequestTimeout bounds a request when AI_REQUEST_TIMEOUT is not set.
+const defaultRequestTimeout = 60 * time.Second
+
+// maxPromptHistory caps how many prior messages are replayed to a provider.
+const maxPromptHistory = 20
+
 // Session stores lightweight conversation state for a user/platform/channel.
 type Session struct {
-	ID        string
-	Platform  string
-	UserID    string
-	ChannelID string
-	Messages  []ChatMessage
-	UpdatedAt time.Time
+	ID        string        `json:"id"`
+	Platform  string        `json:"platform"`
+	UserID    string        `json:"user_id"`
+	ChannelID string        `json:"channel_id"`
+	Messages  []ChatMessage `json:"messages"`
+	UpdatedAt time.Time     `json:"updated_at"`
+
+	// Summary condenses the messages up to SummarizedThrough that no longer
+	// fit the prompt budget; see context_window.go.
+	Summary           string    `json:"summary,omitempty"`
+	SummarizedThrough time.Time `json:"summarized_through"`
+
+	// Provider is the backend the user pinned with !model use; it answers
+	// first while healthy, ahead of the normal chain.
+	Provider string `json:"provider,omitempty"`
+
+	// SystemPrompt is the persona rendered for the current request and
+	// Images the pictures attached to it. They are only set on the
+	// per-request view passed to providers and never stored.
+	SystemPrompt string       `json:"-"`
+	Images       []Attachment `json:"-"`
+
+	mu          sync.Mutex
+	summarizing bool // a summary refresh is in flight
+	// forgotten is set when the session is deleted, so requests and
+	// summaries still in flight can't write it back.
+	forgotten bool
+}
+
+// Prompt is everything a client sends to its API for one reply.
+type Prompt struct {
+	System  string
+	History []ChatMessage // earlier turns, oldest first
+	Message string
+	Images  []Attachment
+}
+
+// prompt builds the Prompt for message from a per-request view.
+func (s *Session) prompt(message string) Prompt {
+	return Prompt{
+		System:  s.SystemPrompt,
+		History: s.History(maxPromptHistory),
+		Message: message,
+		Images:  s.Images,
+	}
+}
+
+// textOnly adapts the prompt for a model that can't see images: they are
+// dropped and the model is told so it can say what it missed.
+func (p Prompt) textOnly() Prompt {
+	if len(p.Images) == 0 {
+		return p
+	}
+	p.Images = nil
+	p.Message += "\n\n[You can't view images, so the attached images were not shown to you. Let the user know if they matter.]"
+	return p
+}
+
+// History returns a copy of the most recent messages, at most limit of them.
+// The result always starts with a user turn so providers that require
+// alternating roles (Anthropic) accept it unchanged.
+func (s *Session) History(limit int) []ChatMessage {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := s.Messages
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	for len(messages) > 0 && messages[0].Role != "user" {
+		messages = messages[1:]
+	}
+	history := make([]ChatMessage, len(messages))
+	copy(history, messages)
+	return history
 }
 
 // ChatMessage stores a single message in the session history.
 type ChatMessage struct {
-	Role      string
-	Content   string
-	Timestamp time.Time
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
-// SessionStore persists chat sessions for adapters and future MCP integrations.
+// SessionStore persists chat sessions for the adapters, the MCP server and the
+// chat API.
 type SessionStore interface {
 	GetOrCreate(platform, userID, channelID string) *Session
 	Append(session *Session, role, content string)
+	// SetSummary replaces the session's rolling summary, which covers every
+	// message up to and including through.
+	SetSummary(session *Session, summary string, through time.Time)
+	// SetProvider pins the provider that answers the session first, or
+	// unpins it when provider is "".
+	SetProvider(session *Session, provider string)
 }
 
-// InMemorySessionStore keeps session state in memory for the current process.
+// InMemorySessionStore keeps session state in memory for the current process,
+// bounded by its SessionRetention.
 type InMemorySessionStore struct {
-	mu       sync.Mutex
-	sessions map[string]*Session
+	mu        sync.Mutex
+	sessions  map[string]*Session
+	buckets   map[string]*tokenBucket
+	counters  map[string]*counter
+	retention SessionRetention
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewInMemorySessionStore creates a store; zero retention fields use the defaults.
+func NewInMemorySessionStore(retention SessionRetention) *InMemorySessionStore {
+	return &InMemorySessionStore{
+		sessions:  make(map[string]*Session),
+		buckets:   make(map[string]*tokenBucket),
+		counters:  make(map[string]*counter),
+		retention: retention.withDefaults(),
+		now:       time.Now,
+	}
 }
 
-func NewInMemorySessionStore() *InMemorySessionStore {
-	return &InMemorySessionStore{sessions: make(map[string]*Session)}
+// Retention returns the limits the store keeps sessions to.
+func (s *InMemorySessionStore) Retention() SessionRetention {
+	return s.retention
 }
 
-func (s *InMemorySessionStore) key(platform, userID, channelID string) string {
+// sessionKey identifies a conversation; every SessionStore backend uses it.
+func sessionKey(platform, userID, channelID string) string {
 	if channelID == "" {
 		return fmt.Sprintf("%s:%s", platform, userID)
 	}
@@ -61,20 +181,30 @@ func (s *InMemorySessionStore) GetOrCreate(platform, userID, channelID string) *
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	key := s.key(platform, userID, channelID)
+	now := s.now()
+	key := sessionKey(platform, userID, channelID)
 	if session, ok := s.sessions[key]; ok {
-		session.UpdatedAt = time.Now()
-		return session
+		session.mu.Lock()
+		idle := session.UpdatedAt.Before(now.Add(-s.retention.IdleTTL))
+		if !idle {
+			session.UpdatedAt = now
+		}
+		session.mu.Unlock()
+		if !idle {
+			return session
+		}
+		delete(s.sessions, key)
 	}
 
 	session := &Session{
-		ID:        fmt.Sprintf("%s-%s-%d", platform, userID, time.Now().UnixNano()),
+		ID:        fmt.Sprintf("%s-%s-%d", platform, userID, now.UnixNano()),
 		Platform:  platform,
 		UserID:    userID,
 		ChannelID: channelID,
-		UpdatedAt: time.Now(),
+		UpdatedAt: now,
 	}
 	s.sessions[key] = session
+	s.sweepLocked(now)
 	return session
 }
 
@@ -83,57 +213,286 @@ func (s *InMemorySessionStore) Append(session *Session, role, content string) {
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	now := s.now()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.forgotten {
+		return
+	}
 	if session.Messages == nil {
 		session.Messages = make([]ChatMessage, 0, 8)
 	}
-	session.Messages = append(session.Messages, ChatMessage{
+	session.Messages = s.retention.trim(append(session.Messages, ChatMessage{
 		Role:      role,
 		Content:   strings.TrimSpace(content),
-		Timestamp: time.Now(),
-	})
-	session.UpdatedAt = time.Now()
+		Timestamp: now,
+	}))
+	session.UpdatedAt = now
+}
+
+func (s *InMemorySessionStore) SetSummary(session *Session, summary string, through time.Time) {
+	if session == nil {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.forgotten {
+		return
+	}
+	session.Summary = strings.TrimSpace(summary)
+	session.SummarizedThrough = through
+}
+
+func (s *InMemorySessionStore) SetProvider(session *Session, provider string) {
+	if session == nil {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.forgotten {
+		return
+	}
+	session.Provider = provider
+}
+
+func (s *InMemorySessionStore) TakeToken(key string, capacity int, per time.Duration, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		s.buckets[key] = b
+	}
+	return b.take(capacity, per, now)
+}
+
+func (s *InMemorySessionStore) ReturnToken(key string, capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.buckets[key]; ok {
+		b.putBack(capacity)
+	}
+}
+
+func (s *InMemorySessionStore) AddCounter(key string, delta int, expires time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok || s.now().After(c.Expires) {
+		c = &counter{}
+		s.counters[key] = c
+	}
+	c.Value += delta
+	c.Expires = expires
+	return c.Value
+}
+
+func (s *InMemorySessionStore) Counter(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[key]; ok && !s.now().After(c.Expires) {
+		return c.Value
+	}
+	return 0
+}
+
+// UserSessions returns copies of the user's sessions, oldest first.
+func (s *InMemorySessionStore) UserSessions(platform, userID string) ([]*Session, error) {
+	s.mu.Lock()
+	var sessions []*Session
+	for _, session := range s.sessions {
+		if session.Platform == platform && session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.Unlock()
+
+	copies := make([]*Session, 0, len(sessions))
+	for _, session := range sessions {
+		copies = append(copies, session.snapshot())
+	}
+	sort.Slice(copies, func(i, j int) bool { return copies[i].UpdatedAt.Before(copies[j].UpdatedAt) })
+	return copies, nil
+}
+
+// ForgetUser deletes the user's sessions.
+func (s *InMemorySessionStore) ForgetUser(platform, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for key, session := range s.sessions {
+		if session.Platform == platform && session.UserID == userID {
+			session.forget()
+			delete(s.sessions, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// ForgetSession deletes the user's session in channelID.
+func (s *InMemorySessionStore) ForgetSession(platform, userID, channelID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sessionKey(platform, userID, channelID)
+	session, ok := s.sessions[key]
+	if ok {
+		session.forget()
+		delete(s.sessions, key)
+	}
+	return ok, nil
+}
+
+// Len reports how many sessions the store currently holds.
+func (s *InMemorySessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// sweepLocked applies idle expiry and the global session cap. The full scan
+// runs at most once per sweepInterval unless the cap has been exceeded.
+// Callers must hold s.mu.
+func (s *InMemorySessionStore) sweepLocked(now time.Time) {
+	if len(s.sessions) <= s.retention.MaxSessions && now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.After(b.Full) {
+			delete(s.buckets, key) // a full bucket is the same as none
+		}
+	}
+	for key, c := range s.counters {
+		if now.After(c.Expires) {
+			delete(s.counters, key)
+		}
+	}
+	evicted := s.retention.evictions(s.sessions, now)
+	for _, key := range evicted {
+		delete(s.sessions, key)
+	}
+	if len(evicted) > 0 {
+		log.Printf("🧹 Evicted %d idle or excess sessions (%d remain)", len(evicted), len(s.sessions))
+	}
 }
 
 // Provider is the shared contract for AI backends.
 type Provider interface {
 	Name() string
-	Generate(ctx context.Context, message string, session *Session) (string, error)
+	Generate(ctx context.Context, message string, session *Session) (Reply, error)
+}
+
+// StreamingProvider is an optional extension for backends that can emit a
+// reply incrementally. onDelta receives each new fragment of text; the
+// returned Reply holds the complete text.
+type StreamingProvider interface {
+	Provider
+	GenerateStream(ctx context.Context, message string, session *Session, onDelta func(string)) (Reply, error)
+}
+
+// ToolProvider is an optional extension for backends that support function
+// calling. The provider runs the model → tool → model loop in its own wire
+// format, calling tools.Call for each tool the model requests, for at most
+// tools.MaxSteps rounds.
+type ToolProvider interface {
+	Provider
+	GenerateWithTools(ctx context.Context, message string, session *Session, tools *ToolSet) (Reply, error)
+}
+
+// accountProvider is an optional extension for backends that can say which
+// account they bill against, so that once one provider finds the account out
+// of quota or its key rejected, the others sharing it are skipped.
+type accountProvider interface {
+	Provider
+	Account() string
 }
 
 // providerFunc adapts a concrete provider to the shared interface.
 type providerFunc struct {
-	name string
-	fn   func(context.Context, string, *Session) (string, error)
+	name    string
+	account string // endpoint and key; empty when unknown
+	fn      func(context.Context, string, *Session) (Reply, error)
+	stream  func(context.Context, string, *Session, func(string)) (Reply, error)
+	tools   func(context.Context, string, *Session, *ToolSet) (Reply, error)
 }
 
 func (p providerFunc) Name() string {
 	return p.name
 }
 
-func (p providerFunc) Generate(ctx context.Context, message string, session *Session) (string, error) {
+func (p providerFunc) Account() string {
+	return p.account
+}
+
+func (p providerFunc) Generate(ctx context.Context, message string, session *Session) (Reply, error) {
 	if p.fn == nil {
-		return "", nil
+		return Reply{}, nil
 	}
 	return p.fn(ctx, message, session)
 }
 
+// GenerateStream streams when the backend supports it and otherwise delivers
+// the whole reply as a single delta.
+func (p providerFunc) GenerateStream(ctx context.Context, message string, session *Session, onDelta func(string)) (Reply, error) {
+	if p.stream != nil {
+		return p.stream(ctx, message, session, onDelta)
+	}
+	reply, err := p.Generate(ctx, message, session)
+	if err == nil && reply.Text != "" && onDelta != nil {
+		onDelta(reply.Text)
+	}
+	return reply, err
+}
+
+// GenerateWithTools runs the tool loop when the backend supports function
+// calling and otherwise answers without tools.
+func (p providerFunc) GenerateWithTools(ctx context.Context, message string, session *Session, tools *ToolSet) (Reply, error) {
+	if p.tools != nil {
+		return p.tools(ctx, message, session, tools)
+	}
+	return p.Generate(ctx, message, session)
+}
+
 // AIService owns shared provider routing and the lightweight session store.
 type AIService struct {
-	providers []Provider
-	store     SessionStore
-	fallback  func(string) string
+	providers    []Provider
+	store        SessionStore
+	fallback     func(string) string
+	health       *healthTracker
+	routing      *RoutingPolicy
+	tools        *ToolRegistry
+	toolMaxSteps int
+	// contextTokens is the prompt budget; see context_window.go.
+	contextTokens int
+	background    sync.WaitGroup // in-flight summary refreshes
+	limiter       *RateLimiter
+	cache         *ResponseCache
+	usage         *UsageTracker
+	personas      *PersonaSet
+	knowledge     *KnowledgeBase
+	redactor      *Redactor
+	// mcpAudit is the external tool call log, anonymized by ForgetUser.
+	mcpAudit *mcpAuditLog
+	// requestTimeout bounds each request across the whole fallback chain.
+	requestTimeout time.Duration
 }
 
 func NewAIService(store SessionStore, fallback func(string) string, providers ...Provider) *AIService {
 	if store == nil {
-		store = NewInMemorySessionStore()
+		store = NewInMemorySessionStore(DefaultSessionRetention())
 	}
 	return &AIService{
-		providers: providers,
-		store:     store,
-		fallback:  fallback,
+		providers:      providers,
+		store:          store,
+		fallback:       fallback,
+		health:         newHealthTracker(),
+		tools:          NewToolRegistry(),
+		toolMaxSteps:   defaultToolMaxSteps,
+		contextTokens:  defaultContextTokens,
+		usage:          NewUsageTracker(PriceTable{}),
+		personas:       NewPersonaSet(defaultBotName),
+		requestTimeout: defaultRequestTimeout,
 	}
 }
 
@@ -145,37 +504,363 @@ func (a *AIService) Register(provider Provider) {
 }
 
 func (a *AIService) Respond(ctx context.Context, req ChatRequest) string {
+	return a.RespondStream(ctx, req, nil)
+}
+
+// RespondStream is like Respond but calls onUpdate with the reply text
+// accumulated so far as a streaming provider produces it. When a provider
+// fails part-way, the next provider starts over and onUpdate sees the new
+// text from the beginning. A nil onUpdate disables streaming.
+func (a *AIService) RespondStream(ctx context.Context, req ChatRequest, onUpdate func(string)) string {
+	reply, _ := a.respond(ctx, req, onUpdate)
+	return reply
+}
+
+// errNoAnswer is returned by RespondResult when every provider failed or
+// the request ran out of time.
+var errNoAnswer = errors.New("no provider answered")
+
+// RespondResult is Respond for callers that must tell refusals and failures
+// from answers, such as the HTTP API. The error is a *RateLimitError when a
+// limit refused the request, errNoAnswer when no provider answered, or the
+// context's error when the request was canceled; the reply is then what chat
+// users would see instead, if anything.
+func (a *AIService) RespondResult(ctx context.Context, req ChatRequest) (string, error) {
+	return a.respond(ctx, req, nil)
+}
+
+func (a *AIService) respond(ctx context.Context, req ChatRequest, onUpdate func(string)) (string, error) {
 	message := strings.TrimSpace(req.Message)
-	if message == "" {
-		return ""
+	if message == "" && len(req.Attachments) == 0 && req.LoadAttachments == nil {
+		return "", nil
+	}
+	if limited := a.limiter.allow(req); limited != nil {
+		return limited.Reply, limited
+	}
+	// One deadline covers the whole request: downloads, embeddings, every
+	// provider in the fallback chain and any tool calls.
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
+	defer cancel()
+	if req.LoadAttachments != nil {
+		req.Attachments = append(req.Attachments, req.LoadAttachments(ctx)...)
+	}
+	// Personal information is masked before anything reaches a provider,
+	// embedder or the stored history; replies get the originals back.
+	redactions := a.redactor.begin(req)
+	question := redactions.redact(message)
+	message = withAttachments(question, redactions.attachments(req.Attachments))
+	images := imageAttachments(req.Attachments)
+	if show := onUpdate; show != nil && redactions != nil {
+		onUpdate = func(text string) { show(redactions.restore(text)) }
 	}
 
 	session := a.store.GetOrCreate(req.Platform, req.UserID, req.ChannelID)
-	for _, provider := range a.providers {
-		response, err := provider.Generate(ctx, message, session)
-		if err == nil && strings.TrimSpace(response) != "" {
+	persona, systemPrompt := a.personas.systemPrompt(req)
+	routeName, chain := a.route(req)
+	cacheable := len(req.Attachments) == 0 && a.cache.usableFor(req, session)
+	scope := cacheScope(persona, routeName, req.Platform)
+	var promptVector []float32
+	if cacheable {
+		cached, vector, ok := a.cache.lookup(ctx, scope, message)
+		if ok {
+			log.Printf("🗃️  Cache hit for %s user %s", req.Platform, req.UserID)
+			a.summarizeBeforeTrim(ctx, req, a.withPinned(session, chain), session, 2)
+			a.store.Append(session, "user", message)
+			a.store.Append(session, "assistant", cached)
+			if onUpdate != nil {
+				onUpdate(cached)
+			}
+			return redactions.restore(cached), nil
+		}
+		promptVector = vector
+	}
+
+	// Relevant documentation goes in the system prompt so it isn't kept in
+	// the history; the search uses the question without attached files.
+	systemPrompt += a.knowledge.promptContext(ctx, question)
+
+	chain = a.withPinned(session, chain)
+	log.Printf("🧭 Route %q for %s user %s: %s", routeName, req.Platform, req.UserID, providerNames(chain))
+	tools := a.toolSet(req)
+	// Accounts found out of quota or with a rejected key in this request
+	deadAccounts := make(map[string]errorClass)
+	for _, provider := range chain {
+		if ctx.Err() != nil {
+			break // out of time; the remaining providers would fail at once
+		}
+		account := providerAccount(provider)
+		if class, dead := deadAccounts[account]; dead {
+			log.Printf("⏭️  Skipping %s provider (account %s)", provider.Name(), class)
+			continue
+		}
+		if !a.health.allow(provider.Name()) {
+			log.Printf("⏭️  Skipping %s provider (circuit open)", provider.Name())
+			continue
+		}
+		view, overflow := a.fitContext(provider.Name(), session, message)
+		view.SystemPrompt = systemPrompt
+		view.Images = images
+		reply, err := a.generate(ctx, provider, message, view, tools, onUpdate)
+		a.health.record(provider.Name(), err)
+		if err == nil {
+			a.usage.record(req, reply.Usage)
+		}
+		if response := reply.Text; err == nil && strings.TrimSpace(response) != "" {
+			// Record both turns together so the history always alternates.
+			a.summarizeBeforeTrim(ctx, req, chain, session, 2)
+			a.store.Append(session, "user", message)
 			a.store.Append(session, "assistant", response)
-			return response
+			a.refreshSummary(req, chain, session, overflow)
+			a.limiter.recordTokens(req, reply.Usage.PromptTokens+reply.Usage.CompletionTokens)
+			// Replies built from tool results may be user-specific or stale,
+			// and replies to a prompt with history may depend on it
+			if cacheable && len(view.Messages) == 0 && (tools == nil || tools.calls == 0) {
+				a.cache.store(scope, userOwner(req.Platform, req.UserID), message, response, promptVector)
+			}
+			return redactions.restore(response), nil
 		}
 		if err != nil {
-			log.Printf("⚠️  %s provider failed: %v", provider.Name(), err)
+			class := classifyError(err)
+			log.Printf("⚠️  %s provider failed (%s): %v", provider.Name(), class, err)
+			if account != "" && (class == errorQuota || class == errorAuth) {
+				deadAccounts[account] = class
+			}
 		}
 	}
 
+	switch err := ctx.Err(); {
+	case errors.Is(err, context.Canceled):
+		// Nobody is waiting: the adapter is shutting down or the user
+		// deleted their message
+		log.Printf("🛑 Request for %s user %s canceled", req.Platform, req.UserID)
+		return "", err
+	case err != nil:
+		log.Printf("⏱️  Request for %s user %s ran out of time (%s)", req.Platform, req.UserID, a.requestTimeout)
+	}
 	if a.fallback != nil {
-		return a.fallback(message)
+		return a.fallback(message), errNoAnswer
+	}
+	return "", errNoAnswer
+}
+
+// SetRequestTimeout sets how long a request may take in total, across every
+// provider tried. Zero or negative keeps the current timeout.
+func (a *AIService) SetRequestTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		a.requestTimeout = timeout
+	}
+}
+
+// SetRateLimiter throttles requests before they reach any provider. A nil
+// limiter disables rate limiting.
+func (a *AIService) SetRateLimiter(limiter *RateLimiter) {
+	a.limiter = limiter
+}
+
+// SetResponseCache puts a cache in front of the providers. A nil cache
+// disables caching.
+func (a *AIService) SetResponseCache(cache *ResponseCache) {
+	a.cache = cache
+}
+
+// SetPersonas replaces the personas that provide the system prompt.
+func (a *AIService) SetPersonas(personas *PersonaSet) {
+	if personas != nil {
+		a.personas = personas
+	}
+}
+
+// SetKnowledgeBase adds relevant passages from local documents to prompts.
+// A nil knowledge base disables retrieval.
+func (a *AIService) SetKnowledgeBase(kb *KnowledgeBase) {
+	a.knowledge = kb
+}
+
+// SetRedactor masks personal information in requests before they reach any
+// provider. A nil redactor disables redaction.
+func (a *AIService) SetRedactor(redactor *Redactor) {
+	a.redactor = redactor
+}
+
+// SetPriceTable sets the prices used to cost provider usage from now on.
+func (a *AIService) SetPriceTable(prices PriceTable) {
+	a.usage.mu.Lock()
+	defer a.usage.mu.Unlock()
+	a.usage.prices = prices
+}
+
+// Usage returns the usage ledger.
+func (a *AIService) Usage() *UsageTracker {
+	return a.usage
+}
+
+// CacheStats reports response cache activity, or nil when caching is off.
+func (a *AIService) CacheStats() *CacheStats {
+	if a.cache == nil {
+		return nil
+	}
+	stats := a.cache.Stats()
+	return &stats
+}
+
+// RegisterTool makes a tool available to providers that support function calling.
+func (a *AIService) RegisterTool(tool Tool) {
+	a.tools.Register(tool)
+}
+
+// SetToolMaxSteps limits how many model → tool rounds a single request may use.
+func (a *AIService) SetToolMaxSteps(steps int) {
+	if steps > 0 {
+		a.toolMaxSteps = steps
+	}
+}
+
+// toolSet returns the tools the requesting user may use, or nil when there are none.
+func (a *AIService) toolSet(req ChatRequest) *ToolSet {
+	tc := ToolContext{
+		Platform:    req.Platform,
+		UserID:      req.UserID,
+		ChannelID:   req.ChannelID,
+		HasCampRole: req.HasCampRole,
+	}
+	tools := a.tools.forContext(tc)
+	if len(tools) == 0 {
+		return nil
+	}
+	return &ToolSet{Tools: tools, MaxSteps: a.toolMaxSteps, context: tc}
+}
+
+// SetRoutingPolicy installs a policy that picks the provider chain per
+// request. Provider names the policy mentions but that are not registered
+// are logged and skipped at request time.
+func (a *AIService) SetRoutingPolicy(policy *RoutingPolicy) {
+	if policy != nil {
+		for _, name := range policy.ProviderNames() {
+			if a.provider(name) == nil {
+				log.Printf("⚠️  Routing policy refers to unknown provider %q", name)
+			}
+		}
+	}
+	a.routing = policy
+}
+
+// route picks the provider chain for a request.
+func (a *AIService) route(req ChatRequest) (string, []Provider) {
+	if a.routing == nil {
+		return "default", a.providers
+	}
+
+	registered := make([]string, 0, len(a.providers))
+	for _, provider := range a.providers {
+		registered = append(registered, provider.Name())
+	}
+	name, names := a.routing.resolve(req, registered)
+	chain := make([]Provider, 0, len(names))
+	for _, providerName := range names {
+		if provider := a.provider(providerName); provider != nil {
+			chain = append(chain, provider)
+		}
+	}
+	return name, chain
+}
+
+func (a *AIService) provider(name string) Provider {
+	for _, provider := range a.providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// ProviderStatus reports the health of every registered provider in
+// routing order.
+func (a *AIService) ProviderStatus() []ProviderStatus {
+	names := make([]string, 0, len(a.providers))
+	for _, provider := range a.providers {
+		names = append(names, provider.Name())
+	}
+	return a.health.snapshot(names)
+}
+
+// providerAccount returns the account a provider bills against, or "".
+func providerAccount(provider Provider) string {
+	if p, ok := provider.(accountProvider); ok {
+		return p.Account()
 	}
 	return ""
 }
 
+func providerNames(providers []Provider) string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, provider.Name())
+	}
+	if len(names) == 0 {
+		return "(none)"
+	}
+	return strings.Join(names, " → ")
+}
+
+// generate calls a single provider. When tools are available and the provider
+// supports function calling it runs the tool loop and delivers the final
+// reply as one update; otherwise it streams when onUpdate is set and the
+// provider supports it. The reply's usage is completed with the provider
+// name and latency, and estimated when the provider reported none.
+func (a *AIService) generate(ctx context.Context, provider Provider, message string, session *Session, tools *ToolSet, onUpdate func(string)) (Reply, error) {
+	start := time.Now()
+	var reply Reply
+	var err error
+	streamer, canStream := provider.(StreamingProvider)
+	if toolProvider, ok := provider.(ToolProvider); ok && tools != nil {
+		reply, err = toolProvider.GenerateWithTools(ctx, message, session, tools)
+		if err == nil && reply.Text != "" && onUpdate != nil {
+			onUpdate(reply.Text)
+		}
+	} else if onUpdate == nil || !canStream {
+		reply, err = provider.Generate(ctx, message, session)
+	} else {
+		var text strings.Builder
+		reply, err = streamer.GenerateStream(ctx, message, session, func(delta string) {
+			text.WriteString(delta)
+			onUpdate(text.String())
+		})
+	}
+
+	reply.Usage.Provider = provider.Name()
+	reply.Usage.Latency = time.Since(start)
+	if err == nil && reply.Usage.PromptTokens == 0 && reply.Usage.CompletionTokens == 0 {
+		prompt := estimateTokens(provider.Name(), message)
+		for _, msg := range session.History(maxPromptHistory) {
+			prompt += estimateTokens(provider.Name(), msg.Content)
+		}
+		reply.Usage.PromptTokens = prompt
+		reply.Usage.CompletionTokens = estimateTokens(provider.Name(), reply.Text)
+		reply.Usage.Estimated = true
+	}
+	return reply, err
+}
+
 func newGeminiProvider(client *GeminiClient) Provider {
 	return providerFunc{
 		name: "gemini",
-		fn: func(ctx context.Context, message string, session *Session) (string, error) {
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
 			if client == nil {
-				return "", nil
+				return Reply{}, nil
 			}
-			return client.GenerateResponse(message)
+			return client.GenerateResponse(ctx, session.prompt(message))
+		},
+		stream: func(ctx context.Context, message string, session *Session, onDelta func(string)) (Reply, error) {
+			if client == nil {
+				return Reply{}, nil
+			}
+			return client.GenerateStream(ctx, session.prompt(message), onDelta)
+		},
+		tools: func(ctx context.Context, message string, session *Session, tools *ToolSet) (Reply, error) {
+			if client == nil {
+				return Reply{}, nil
+			}
+			return client.GenerateWithTools(ctx, session.prompt(message), tools)
 		},
 	}
 }
@@ -183,11 +868,23 @@ func newGeminiProvider(client *GeminiClient) Provider {
 func newClaudeProvider(client *ClaudeClient) Provider {
 	return providerFunc{
 		name: "claude",
-		fn: func(ctx context.Context, message string, session *Session) (string, error) {
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if client == nil {
+				return Reply{}, nil
+			}
+			return client.GenerateResponse(ctx, session.prompt(message))
+		},
+		stream: func(ctx context.Context, message string, session *Session, onDelta func(string)) (Reply, error) {
+			if client == nil {
+				return Reply{}, nil
+			}
+			return client.GenerateStream(ctx, session.prompt(message), onDelta)
+		},
+		tools: func(ctx context.Context, message string, session *Session, tools *ToolSet) (Reply, error) {
 			if client == nil {
-				return "", nil
+				return Reply{}, nil
 			}
-			return client.GenerateResponse(message)
+			return client.GenerateWithTools(ctx, session.prompt(message), tools)
 		},
 	}
 }
@@ -195,27 +892,40 @@ func newClaudeProvider(client *ClaudeClient) Provider {
 func newGitHubModelsProvider(client *GitHubModelsClient) Provider {
 	return providerFunc{
 		name: "github-models",
-		fn: func(ctx context.Context, message string, session *Session) (string, error) {
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
 			if client == nil {
-				return "", nil
+				return Reply{}, nil
 			}
-			return client.GenerateResponse(message)
+			return client.GenerateResponse(ctx, session.prompt(message))
 		},
 	}
 }
 
 func newOpenAICompatProvider(client *OpenAICompatClient) Provider {
-	name := "openai-compat"
+	name, account := "openai-compat", ""
 	if client != nil {
-		name = client.name
+		name, account = client.name, client.baseURL+" "+client.apiKey
 	}
 	return providerFunc{
-		name: name,
-		fn: func(ctx context.Context, message string, session *Session) (string, error) {
+		name:    name,
+		account: account,
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if client == nil {
+				return Reply{}, nil
+			}
+			return client.GenerateResponse(ctx, session.prompt(message))
+		},
+		stream: func(ctx context.Context, message string, session *Session, onDelta func(string)) (Reply, error) {
+			if client == nil {
+				return Reply{}, nil
+			}
+			return client.GenerateStream(ctx, session.prompt(message), onDelta)
+		},
+		tools: func(ctx context.Context, message string, session *Session, tools *ToolSet) (Reply, error) {
 			if client == nil {
-				return "", nil
+				return Reply{}, nil
 			}
-			return client.GenerateResponse(message)
+			return client.GenerateWithTools(ctx, session.prompt(message), tools)
 		},
 	}
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+func TestRespondRecordsBothTurns(t *testing.T) {
+	store := NewInMemorySessionStore(DefaultSessionRetention())
+	var seen [][]ChatMessage
+	echo := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			seen = append(seen, session.History(maxPromptHistory))
+			return Reply{Text: "re: " + message}, nil
+		},
+	}
+	svc := NewAIService(store, nil, echo)
+
+	req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "first"}
+	svc.Respond(context.Background(), req)
+	req.Message = "what about the second one?"
+	svc.Respond(context.Background(), req)
+
+	if len(seen[0]) != 0 {
+		t.Fatalf("first call should have no history, got %v", seen[0])
+	}
+	if len(seen[1]) != 2 || seen[1][0].Role != "user" || seen[1][1].Content != "re: first" {
+		t.Fatalf("second call history = %+v", seen[1])
+	}
+	session := store.GetOrCreate("discord", "u1", "c1")
+	if got := len(session.Messages); got != 4 {
+		t.Fatalf("session has %d messages, want 4", got)
+	}
+}
+
+func TestSessionHistoryStartsWithUser(t *testing.T) {
+	session := &Session{Messages: []ChatMessage{
+		{Role: "user", Content: "a"},
+		{Role: "assistant", Content: "b"},
+		{Role: "user", Content: "c"},
+		{Role: "assistant", Content: "d"},
+	}}
+	history := session.History(3)
+	if len(history) != 2 || history[0].Content != "c" {
+		t.Fatalf("History(3) = %+v, want [c d]", history)
+	}
+}
+
+func TestInMemorySessionStoreRetention(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	store := NewInMemorySessionStore(SessionRetention{MaxMessages: 3, IdleTTL: time.Hour, MaxSessions: 2})
+	store.now = func() time.Time { return now }
+
+	session := store.GetOrCreate("discord", "u1", "c1")
+	for i := 0; i < 5; i++ {
+		store.Append(session, "user", fmt.Sprintf("m%d", i))
+	}
+	if len(session.Messages) != 3 || session.Messages[0].Content != "m2" {
+		t.Fatalf("messages not trimmed to the last 3: %+v", session.Messages)
+	}
+
+	// Past the cap the least recently used session goes
+	now = now.Add(time.Minute)
+	store.GetOrCreate("discord", "u2", "c1")
+	now = now.Add(time.Minute)
+	store.GetOrCreate("discord", "u3", "c1")
+	if store.Len() != 2 {
+		t.Fatalf("store holds %d sessions, want 2", store.Len())
+	}
+	if got := store.GetOrCreate("discord", "u1", "c1"); got == session {
+		t.Fatal("least recently used session should have been evicted")
+	}
+
+	// Idle sessions start over
+	now = now.Add(2 * time.Hour)
+	if got := store.GetOrCreate("discord", "u3", "c1"); len(got.Messages) != 0 || store.Len() != 1 {
+		t.Fatalf("idle sessions should expire, store has %d", store.Len())
+	}
+}
+
+// blockingProvider waits for its context to end, like a hung API call.
+func blockingProvider(name string, calls *int) providerFunc {
+	return providerFunc{
+		name: name,
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			*calls++
+			<-ctx.Done()
+			return Reply{}, ctx.Err()
+		},
+	}
+}
+
+func TestRespondDeadlineCoversFallbackChain(t *testing.T) {
+	var calls int
+	svc := NewAIService(nil, func(string) string { return "fallback" }, blockingProvider("slow", &calls), blockingProvider("slower", &calls))
+	svc.SetRequestTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	reply := svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", Message: "hello"})
+	if reply != "fallback" || calls != 1 {
+		t.Fatalf("reply %q after %d provider calls", reply, calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("deadline not applied across the chain: %s", elapsed)
+	}
+}
+
+func TestRespondCanceledByCaller(t *testing.T) {
+	var calls int
+	svc := NewAIService(nil, func(string) string { return "fallback" }, blockingProvider("slow", &calls))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if reply := svc.Respond(ctx, ChatRequest{Platform: "slack", UserID: "u1", Message: "hello"}); reply != "" {
+		t.Fatalf("canceled request should not be answered, got %q", reply)
+	}
+	if status := svc.ProviderStatus()[0]; status.ConsecutiveFailures != 0 {
+		t.Fatalf("cancellation counted against the provider: %+v", status)
+	}
+}
+
+func TestHungProviderLeavesTimeForFallback(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	claude := &ClaudeClient{
+		client:  anthropic.NewClient(option.WithAPIKey("test"), option.WithBaseURL(hung.URL), option.WithMaxRetries(0)),
+		model:   "claude-test",
+		timeout: 50 * time.Millisecond,
+	}
+	echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		return Reply{Text: "re: " + message}, nil
+	}}
+	svc := NewAIService(nil, nil, newClaudeProvider(claude), echo)
+	svc.SetRequestTimeout(5 * time.Second)
+
+	start := time.Now()
+	if reply := svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", Message: "hello"}); reply != "re: hello" {
+		t.Fatalf("fallback did not answer: %q", reply)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("hung provider held the request for %s", elapsed)
+	}
+}
+
+func TestRespondSkipsProvidersOnRejectedAccount(t *testing.T) {
+	var called []string
+	provider := func(name, account string, err error) providerFunc {
+		return providerFunc{
+			name:    name,
+			account: account,
+			fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+				called = append(called, name)
+				return Reply{Text: "from " + name}, err
+			},
+		}
+	}
+	svc := NewAIService(nil, nil,
+		provider("groq-large", "groq", &ProviderError{Provider: "groq-large", StatusCode: 401}),
+		provider("groq-small", "groq", nil),
+		provider("mistral", "mistral", nil),
+	)
+
+	reply := svc.Respond(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", Message: "hello"})
+	if reply != "from mistral" || fmt.Sprint(called) != "[groq-large mistral]" {
+		t.Fatalf("reply %q after calling %v", reply, called)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Platform limits for live-edited streaming replies. Slack's chat.update is
+// a Tier 3 method (~50 calls/min) and Discord allows 5 edits per 5 seconds
+// per channel, so edits are spaced a little wider than either limit.
+const (
+	slackStreamLimit      = 3900
+	slackEditInterval     = 1500 * time.Millisecond
+	discordStreamLimit    = 1900
+	discordEditInterval   = 1200 * time.Millisecond
+	streamingPlaceholder  = "💭 _Thinking..._"
+	streamingCursorSuffix = " ▌"
+)
+
+// messageStreamer renders a growing reply as one or more chat messages,
+// posting a placeholder first and then editing it in place. Text beyond
+// limit rolls over into additional messages, split the same way
+// splitMessage splits long replies.
+type messageStreamer struct {
+	post     func(text string) (id string, err error)
+	edit     func(id, text string) error
+	limit    int
+	interval time.Duration
+
+	mu       sync.Mutex
+	ids      []string
+	rendered []string
+	lastEdit time.Time
+	failed   bool
+}
+
+func newMessageStreamer(post func(string) (string, error), edit func(string, string) error, limit int, interval time.Duration) *messageStreamer {
+	return &messageStreamer{post: post, edit: edit, limit: limit, interval: interval}
+}
+
+// Start posts the placeholder message that later updates will replace.
+func (s *messageStreamer) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.render([]string{streamingPlaceholder})
+	s.lastEdit = time.Now()
+}
+
+// Update shows the partial reply, dropping updates that arrive sooner than
+// the edit interval allows. The final text is always written by Finish.
+func (s *messageStreamer) Update(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed || time.Since(s.lastEdit) < s.interval || strings.TrimSpace(text) == "" {
+		return
+	}
+	s.render(splitMessage(text+streamingCursorSuffix, s.limit))
+	s.lastEdit = time.Now()
+}
+
+// Finish writes the complete reply, editing or posting whatever messages
+// are still out of date.
+func (s *messageStreamer) Finish(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.TrimSpace(text) == "" {
+		text = "🤔 I couldn't come up with a response. Please try again."
+	}
+	s.failed = false
+	s.render(splitMessage(text, s.limit))
+}
+
+// Settle ends a reply generated under ctx: normally with Finish, with the
+// shutdown notice if Kit is stopping, or by deleting the posted messages if
+// the user deleted the message being answered.
+func (s *messageStreamer) Settle(ctx context.Context, text string, remove func(id string) error) {
+	if !messageDeleted(ctx) {
+		s.Finish(settledReply(ctx, text))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.ids {
+		if err := remove(id); err != nil {
+			log.Printf("⚠️  Failed to delete canceled reply: %v", err)
+		}
+	}
+	s.ids, s.rendered = nil, nil
+}
+
+// render brings the posted messages in line with chunks, editing existing
+// messages and posting new ones as the text grows.
+func (s *messageStreamer) render(chunks []string) {
+	for i, chunk := range chunks {
+		if i < len(s.ids) {
+			if s.rendered[i] == chunk {
+				continue
+			}
+			if err := s.edit(s.ids[i], chunk); err != nil {
+				log.Printf("⚠️  Failed to edit streamed message: %v", err)
+				s.failed = true
+				return
+			}
+			s.rendered[i] = chunk
+			continue
+		}
+
+		id, err := s.post(chunk)
+		if err != nil {
+			log.Printf("❌ Failed to post streamed message: %v", err)
+			s.failed = true
+			return
+		}
+		s.ids = append(s.ids, id)
+		s.rendered = append(s.rendered, chunk)
+	}
+}
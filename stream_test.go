@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMessageStreamerRollsOver(t *testing.T) {
+	var posted []string
+	edits := map[string]string{}
+	streamer := newMessageStreamer(
+		func(text string) (string, error) {
+			id := fmt.Sprintf("m%d", len(posted))
+			posted = append(posted, text)
+			edits[id] = text
+			return id, nil
+		},
+		func(id, text string) error {
+			edits[id] = text
+			return nil
+		},
+		100, 0,
+	)
+
+	streamer.Start()
+	if len(posted) != 1 || posted[0] != streamingPlaceholder {
+		t.Fatalf("placeholder not posted: %v", posted)
+	}
+
+	line := strings.Repeat("x", 60)
+	streamer.Update(line)
+	final := line + "\n" + line + "\n" + line
+	streamer.Finish(final)
+
+	if len(posted) != 3 {
+		t.Fatalf("expected rollover into 3 messages, got %d", len(posted))
+	}
+	for i := 0; i < 3; i++ {
+		if got := edits[fmt.Sprintf("m%d", i)]; got != line {
+			t.Errorf("message %d = %q, want %q", i, got, line)
+		}
+	}
+}
+
+func TestMessageStreamerThrottlesEdits(t *testing.T) {
+	editCount := 0
+	streamer := newMessageStreamer(
+		func(text string) (string, error) { return "m0", nil },
+		func(id, text string) error { editCount++; return nil },
+		1900, discordEditInterval,
+	)
+	streamer.Start()
+	for i := 0; i < 20; i++ {
+		streamer.Update(strings.Repeat("a", i+1))
+	}
+	if editCount != 0 {
+		t.Fatalf("updates within the interval should be dropped, got %d edits", editCount)
+	}
+	streamer.Finish("done")
+	if editCount != 1 {
+		t.Fatalf("Finish should always edit, got %d edits", editCount)
+	}
+}
+
+func TestReadChatStream(t *testing.T) {
+	body := strings.Join([]string{
+		`: keep-alive`,
+		`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+		`data: {"choices":[{"delta":{"content":"Hel"}}]}`,
+		``,
+		`data: {"choices":[{"delta":{"content":"lo"}}]}`,
+		`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2}}`,
+		`data: [DONE]`,
+	}, "\n")
+
+	var deltas []string
+	text, usage, err := readChatStream(strings.NewReader(body), func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hello" || len(deltas) != 2 {
+		t.Fatalf("got %q from %v", text, deltas)
+	}
+	if usage == nil || usage.PromptTokens != 12 || usage.CompletionTokens != 2 {
+		t.Fatalf("usage = %+v", usage)
+	}
+
+	// A stream cut off before [DONE] is a failure, not a short reply
+	truncated := strings.Join([]string{
+		`data: {"choices":[{"delta":{"content":"Hel"}}]}`,
+		`data: {"choices":[{"delta":{"content":"lo, the camp st"}}]}`,
+	}, "\n")
+	text, _, err = readChatStream(strings.NewReader(truncated), nil)
+	if !errors.Is(err, errStreamTruncated) || classifyError(fmt.Errorf("groq api stream: %w", err)) != errorServer {
+		t.Fatalf("truncated stream: %q, %v", text, err)
+	}
+}
+
+func TestCompatClientStreamsWithUsage(t *testing.T) {
+	var got oaChatRequest
+	strict := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = oaChatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if strict && got.StreamOptions != nil {
+			http.Error(w, `{"error":{"message":"Unrecognized request argument supplied: stream_options"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hi there\"}}]}\n\n")
+		if got.StreamOptions != nil && got.StreamOptions.IncludeUsage {
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":9,\"completion_tokens\":2}}\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	// Endpoints that opt in report the usage of streamed replies
+	client := NewOpenAICompatClientFromConfig(CompatProviderConfig{Name: "local", BaseURL: server.URL, Model: "m", StreamUsage: true})
+	reply, err := client.GenerateStream(context.Background(), Prompt{Message: "hello"}, func(string) {})
+	if err != nil || reply.Text != "Hi there" {
+		t.Fatalf("reply %q, err %v", reply.Text, err)
+	}
+	if !got.Stream || reply.Usage.PromptTokens != 9 || reply.Usage.CompletionTokens != 2 {
+		t.Fatalf("usage not requested: %+v, usage %+v", got, reply.Usage)
+	}
+
+	// Others don't get stream_options, and their usage is estimated
+	strict = true
+	svc := NewAIService(nil, nil, newOpenAICompatProvider(NewOpenAICompatClient(server.URL, "key", "m", "local")))
+	var streamed string
+	if reply := svc.RespondStream(context.Background(), ChatRequest{Platform: "slack", UserID: "u1", Message: "hello"}, func(text string) { streamed = text }); reply != "Hi there" || streamed != "Hi there" {
+		t.Fatalf("reply %q, streamed %q", reply, streamed)
+	}
+	if records := svc.Usage().Records(0); len(records) != 1 || records[0].EstimatedTokens == 0 {
+		t.Fatalf("usage not estimated: %+v", records)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// defaultToolMaxSteps bounds the model → tool → model loop when
+// AI_TOOL_MAX_STEPS is not set.
+const defaultToolMaxSteps = 4
+
+// toolTimeout bounds a single tool invocation.
+const toolTimeout = 20 * time.Second
+
+// ToolParam describes one argument of a tool. Tools take a flat JSON object,
+// which every function-calling backend we support can express.
+type ToolParam struct {
+	Name        string
+	Type        string // string, integer, number or boolean
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// ToolContext identifies who a tool call is made for, so tools can apply
+// the same authorization rules as the chat adapters.
+type ToolContext struct {
+	Platform    string
+	UserID      string
+	ChannelID   string
+	HasCampRole bool
+}
+
+// Tool is a function the model may call while answering a ChatRequest.
+type Tool struct {
+	Name        string
+	Description string
+	Params      []ToolParam
+	// Authorize reports whether the tool may be offered to and run for the
+	// requesting user. A nil Authorize allows everyone.
+	Authorize func(ToolContext) bool
+	Run       func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error)
+	// InputSchema is the JSON Schema of the arguments, used instead of
+	// Params for tools whose arguments are nested, such as those discovered
+	// on MCP servers.
+	InputSchema map[string]any
+	// Timeout bounds one call; toolTimeout when zero.
+	Timeout time.Duration
+}
+
+// timeout is how long one call of the tool may take.
+func (t Tool) timeout() time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return toolTimeout
+}
+
+// ToolRegistry holds the tools AIService can expose to providers.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]Tool)}
+}
+
+// Register adds or replaces a tool.
+func (r *ToolRegistry) Register(tool Tool) {
+	if tool.Name == "" || tool.Run == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[tool.Name] = tool
+}
+
+// registerNew adds a tool unless one by that name is already registered,
+// reporting whether it was added.
+func (r *ToolRegistry) registerNew(tool Tool) bool {
+	if tool.Name == "" || tool.Run == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, taken := r.tools[tool.Name]; taken {
+		return false
+	}
+	r.tools[tool.Name] = tool
+	return true
+}
+
+// forContext returns the tools the requesting user is allowed to use,
+// sorted by name so prompts are stable between requests.
+func (r *ToolRegistry) forContext(tc ToolContext) []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(r.tools))
+	for _, tool := range r.tools {
+		if tool.Authorize == nil || tool.Authorize(tc) {
+			tools = append(tools, tool)
+		}
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// ToolSet is what a ToolProvider receives for one request: the tools it may
+// offer the model, the step limit, and Call to run a requested tool.
+type ToolSet struct {
+	Tools    []Tool
+	MaxSteps int
+	context  ToolContext
+	calls    int // tools run so far for this request
+}
+
+// Call runs the named tool and returns its result as text for the model.
+// Errors and authorization failures are returned as text too, so the model
+// can explain them rather than the whole request failing.
+func (ts *ToolSet) Call(ctx context.Context, name string, args json.RawMessage) string {
+	var tool *Tool
+	for i := range ts.Tools {
+		if ts.Tools[i].Name == name {
+			tool = &ts.Tools[i]
+			break
+		}
+	}
+	if tool == nil {
+		log.Printf("⚠️  Model requested unknown or unauthorized tool %q", name)
+		return fmt.Sprintf("error: tool %q is not available", name)
+	}
+	if tool.Authorize != nil && !tool.Authorize(ts.context) {
+		log.Printf("🔒 Tool %s denied for %s user %s", name, ts.context.Platform, ts.context.UserID)
+		return "error: not authorized to use this tool"
+	}
+
+	log.Printf("🔧 Tool %s called for %s user %s", name, ts.context.Platform, ts.context.UserID)
+	ts.calls++
+	ctx, cancel := context.WithTimeout(ctx, tool.timeout())
+	defer cancel()
+	result, err := tool.Run(ctx, ts.context, args)
+	if err != nil {
+		log.Printf("⚠️  Tool %s failed: %v", name, err)
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+// jsonSchema renders the tool's parameters as a JSON Schema object, the
+// format used by OpenAI-compatible and Anthropic tool definitions.
+func (t Tool) jsonSchema() map[string]any {
+	if t.InputSchema != nil {
+		return t.InputSchema
+	}
+	properties, required := t.schemaProperties()
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (t Tool) schemaProperties() (map[string]any, []string) {
+	if t.InputSchema != nil {
+		properties, _ := t.InputSchema["properties"].(map[string]any)
+		return properties, schemaStrings(t.InputSchema["required"])
+	}
+	properties := make(map[string]any, len(t.Params))
+	var required []string
+	for _, p := range t.Params {
+		prop := map[string]any{"type": p.Type}
+		if p.Description != "" {
+			prop["description"] = p.Description
+		}
+		if len(p.Enum) > 0 {
+			prop["enum"] = p.Enum
+		}
+		properties[p.Name] = prop
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	return properties, required
+}
+
+// geminiDeclaration renders the tool as a Gemini FunctionDeclaration.
+func (t Tool) geminiDeclaration() *genai.FunctionDeclaration {
+	schema := &genai.Schema{Type: genai.TypeObject, Properties: make(map[string]*genai.Schema, len(t.Params))}
+	for _, p := range t.Params {
+		prop := &genai.Schema{Description: p.Description, Enum: p.Enum}
+		switch p.Type {
+		case "integer":
+			prop.Type = genai.TypeInteger
+		case "number":
+			prop.Type = genai.TypeNumber
+		case "boolean":
+			prop.Type = genai.TypeBoolean
+		default:
+			prop.Type = genai.TypeString
+		}
+		schema.Properties[p.Name] = prop
+		if p.Required {
+			schema.Required = append(schema.Required, p.Name)
+		}
+	}
+	decl := &genai.FunctionDeclaration{Name: t.Name, Description: t.Description}
+	switch {
+	case t.InputSchema != nil:
+		if properties, _ := t.InputSchema["properties"].(map[string]any); len(properties) > 0 {
+			decl.Parameters = geminiSchema(t.InputSchema)
+		}
+	case len(t.Params) > 0:
+		decl.Parameters = schema
+	}
+	return decl
+}
+
+// geminiSchema converts a JSON Schema to Gemini's subset of it. Keywords
+// Gemini lacks, such as oneOf or patterns, are dropped; an untyped value
+// becomes a string.
+func geminiSchema(schema map[string]any) *genai.Schema {
+	converted := &genai.Schema{Description: stringValue(schema["description"])}
+	schemaType := schema["type"]
+	if types, ok := schemaType.([]any); ok && len(types) > 0 {
+		schemaType = types[0] // e.g. ["string", "null"]
+	}
+	switch schemaType {
+	case "object":
+		converted.Type = genai.TypeObject
+		properties, _ := schema["properties"].(map[string]any)
+		converted.Properties = make(map[string]*genai.Schema, len(properties))
+		for name, property := range properties {
+			if property, ok := property.(map[string]any); ok {
+				converted.Properties[name] = geminiSchema(property)
+			}
+		}
+		converted.Required = schemaStrings(schema["required"])
+	case "array":
+		converted.Type = genai.TypeArray
+		items, _ := schema["items"].(map[string]any)
+		converted.Items = geminiSchema(items)
+	case "integer":
+		converted.Type = genai.TypeInteger
+	case "number":
+		converted.Type = genai.TypeNumber
+	case "boolean":
+		converted.Type = genai.TypeBoolean
+	default:
+		converted.Type = genai.TypeString
+		converted.Enum = schemaStrings(schema["enum"])
+	}
+	return converted
+}
+
+// schemaStrings reads a JSON array of strings, such as "required".
+func schemaStrings(value any) []string {
+	items, _ := value.([]any)
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	if len(strs) == 0 {
+		return nil
+	}
+	return strs
+}
+
+func stringValue(value any) string {
+	s, _ := value.(string)
+	return s
+}
+
+// campStatsTool exposes aggregate registration counts. It never returns
+// names or other per-camper fields, and is limited to users who may query
+// camp data at all. Camp access is granted by Discord user ID or role, so
+// the tool is only offered on Discord.
+func campStatsTool(camp *CampClient) Tool {
+	return Tool{
+		Name:        "camp_registration_stats",
+		Description: "Aggregate Camp Power-Up registration numbers: total, returning, new and paid campers, and capacity. Contains no personal details.",
+		Authorize: func(tc ToolContext) bool {
+			return tc.Platform == "discord" && camp.isAuthorized(tc.UserID, tc.HasCampRole)
+		},
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			regs, err := camp.fetchRegistrations(ctx)
+			if err != nil {
+				return "", fmt.Errorf("camp registration system unreachable")
+			}
+			stats := summarizeCampRegistrations(regs)
+			result := map[string]int{
+				"total":          stats.total,
+				"returning":      stats.returning,
+				"new":            stats.total - stats.returning,
+				"paid":           stats.paid,
+				"with_allergies": stats.allergies,
+				"own_switch":     stats.ownSwitch,
+			}
+			if camp.capacity > 0 { // 0 means unlimited
+				result["capacity"] = camp.capacity
+				result["spots_remaining"] = max(camp.capacity-stats.total, 0)
+			}
+			data, err := json.Marshal(result)
+			return string(data), err
+		},
+	}
+}
+
+// campWebsiteTool reports whether the Camp Power-Up website is up, using the
+// monitor's latest check when it is running and a live check otherwise.
+func campWebsiteTool(camp *CampClient, monitor *CampMonitor) Tool {
+	return Tool{
+		Name:        "camp_website_status",
+		Description: "Check whether the Camp Power-Up website is currently up.",
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			up, detail, checked := monitor.WebsiteStatus()
+			if checked.IsZero() {
+				up, detail = probeWebsite(ctx, camp.BaseURL())
+				checked = time.Now()
+			}
+			state := "down"
+			if up {
+				state = "up"
+			}
+			return fmt.Sprintf("%s is %s (%s, checked %s)", camp.BaseURL(), state, detail, checked.Format(time.RFC1123)), nil
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolSetCallEnforcesAuthorization(t *testing.T) {
+	registry := NewToolRegistry()
+	registry.Register(Tool{
+		Name:      "secret",
+		Authorize: func(tc ToolContext) bool { return tc.UserID == "admin" },
+		Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			return "42", nil
+		},
+	})
+
+	tc := ToolContext{Platform: "discord", UserID: "someone"}
+	if tools := registry.forContext(tc); len(tools) != 0 {
+		t.Fatalf("unauthorized user was offered %d tools", len(tools))
+	}
+
+	admin := ToolContext{Platform: "discord", UserID: "admin"}
+	set := &ToolSet{Tools: registry.forContext(admin), MaxSteps: 2, context: admin}
+	if got := set.Call(context.Background(), "secret", nil); got != "42" {
+		t.Fatalf("Call() = %q", got)
+	}
+	if got := set.Call(context.Background(), "missing", nil); !strings.HasPrefix(got, "error:") {
+		t.Fatalf("unknown tool should return an error result, got %q", got)
+	}
+}
+
+func TestCampStatsToolStopsAtTimeout(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	camp := NewCampClient(hung.URL, "admin", "secret", "u1", "", 0)
+	if camp == nil {
+		t.Fatal("camp client not created")
+	}
+	tool := campStatsTool(camp)
+	tool.Timeout = 50 * time.Millisecond
+	tc := ToolContext{Platform: "discord", UserID: "u1"}
+	set := &ToolSet{Tools: []Tool{tool}, MaxSteps: 1, context: tc}
+
+	start := time.Now()
+	if got := set.Call(context.Background(), tool.Name, nil); !strings.HasPrefix(got, "error:") {
+		t.Fatalf("Call() = %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("tool ran for %s past its timeout", elapsed)
+	}
+}
+
+func TestOpenAICompatToolLoop(t *testing.T) {
+	var requests []oaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req oaChatRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		requests = append(requests, req)
+
+		if len(requests) == 1 {
+			io.WriteString(w, `{"choices":[{"message":{"content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"camp_website_status","arguments":""}}]}}]}`)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"content":"Yes, the camp site is up."}}]}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAICompatClient(server.URL, "", "test-model", "test")
+	calls := 0
+	tools := &ToolSet{
+		Tools: []Tool{{
+			Name: "camp_website_status",
+			Run: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+				calls++
+				return "up (200 OK)", nil
+			},
+		}},
+		MaxSteps: 3,
+	}
+
+	reply, err := client.GenerateWithTools(context.Background(), Prompt{Message: "is the camp site up?"}, tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Text != "Yes, the camp site is up." || calls != 1 {
+		t.Fatalf("reply %q after %d tool calls", reply.Text, calls)
+	}
+	if len(requests) != 2 || len(requests[0].Tools) != 1 {
+		t.Fatalf("expected 2 requests offering 1 tool, got %d", len(requests))
+	}
+	last := requests[1].Messages[len(requests[1].Messages)-1]
+	if last.Role != "tool" || last.ToolCallID != "call_1" || last.Content != "up (200 OK)" {
+		t.Fatalf("tool result not sent back: %+v", last)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// usageRetentionDays is how many days of usage are kept for reports.
+	usageRetentionDays = 90
+	// usageSaveInterval is how often a changed usage ledger is written to
+	// USAGE_FILE.
+	usageSaveInterval = time.Minute
+	// defaultUsageReportDays is the window of !usage without an argument.
+	defaultUsageReportDays = 7
+)
+
+// Usage is what a single provider call consumed.
+type Usage struct {
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	// Estimated is set when the provider reported no token counts and they
+	// were approximated from the text instead.
+	Estimated bool
+}
+
+// add accumulates the token counts of another call to the same model, as
+// when a tool loop makes several requests for one reply.
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	if u.Model == "" {
+		u.Model = other.Model
+	}
+}
+
+// Reply is a provider's answer together with what it cost.
+type Reply struct {
+	Text  string
+	Usage Usage
+}
+
+// ModelPrice is the price in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// PriceTable maps model names, and provider names as a fallback, to
+// prices. It is loaded from AI_PRICING_CONFIG; see
+// config/pricing.example.json.
+type PriceTable struct {
+	Models    map[string]ModelPrice `json:"models"`
+	Providers map[string]ModelPrice `json:"providers"`
+}
+
+// LoadPriceTable reads a price table file.
+func LoadPriceTable(path string) (PriceTable, error) {
+	var table PriceTable
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return table, err
+	}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return table, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// cost prices a call; unknown models cost nothing.
+func (p PriceTable) cost(u Usage) float64 {
+	price, ok := p.Models[u.Model]
+	if !ok {
+		price = p.Providers[u.Provider]
+	}
+	return (float64(u.PromptTokens)*price.Input + float64(u.CompletionTokens)*price.Output) / 1e6
+}
+
+// UsageRecord is one row of the usage ledger: everything one user consumed
+// with one provider model in one channel on one UTC day.
+type UsageRecord struct {
+	Day              string  `json:"day"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Platform         string  `json:"platform"`
+	UserID           string  `json:"user_id"`
+	ChannelID        string  `json:"channel_id"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	EstimatedTokens  int     `json:"estimated_tokens"` // included in the token counts
+	CostUSD          float64 `json:"cost_usd"`
+	LatencyMS        int64   `json:"latency_ms"` // total; divide by Requests for the mean
+}
+
+type usageKey struct {
+	day, provider, model, platform, userID, channelID string
+}
+
+// UsageTracker aggregates provider usage per provider, user, channel and
+// day and prices it with a PriceTable.
+type UsageTracker struct {
+	mu      sync.Mutex
+	prices  PriceTable
+	records map[usageKey]*UsageRecord
+	dirty   bool
+	now     func() time.Time
+}
+
+// NewUsageTracker returns an empty tracker that prices calls with prices.
+func NewUsageTracker(prices PriceTable) *UsageTracker {
+	return &UsageTracker{prices: prices, records: make(map[usageKey]*UsageRecord), now: time.Now}
+}
+
+// record adds one provider call made for req.
+func (t *UsageTracker) record(req ChatRequest, u Usage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	day := t.now().UTC().Format("2006-01-02")
+	key := usageKey{day, u.Provider, u.Model, req.Platform, req.UserID, req.ChannelID}
+	rec, ok := t.records[key]
+	if !ok {
+		rec = &UsageRecord{
+			Day:       day,
+			Provider:  u.Provider,
+			Model:     u.Model,
+			Platform:  req.Platform,
+			UserID:    req.UserID,
+			ChannelID: req.ChannelID,
+		}
+		t.records[key] = rec
+		t.pruneLocked()
+	}
+	rec.Requests++
+	rec.PromptTokens += u.PromptTokens
+	rec.CompletionTokens += u.CompletionTokens
+	if u.Estimated {
+		rec.EstimatedTokens += u.PromptTokens + u.CompletionTokens
+	}
+	rec.CostUSD += t.prices.cost(u)
+	rec.LatencyMS += u.Latency.Milliseconds()
+	t.dirty = true
+}
+
+// ForgetUser moves the user's rows to forgottenUserID, merging them with
+// rows already there, and returns how many rows it changed.
+func (t *UsageTracker) ForgetUser(platform, userID string) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := 0
+	for key, rec := range t.records {
+		if key.platform != platform || key.userID != userID {
+			continue
+		}
+		delete(t.records, key)
+		key.userID = forgottenUserID
+		if into, ok := t.records[key]; ok {
+			into.Requests += rec.Requests
+			into.PromptTokens += rec.PromptTokens
+			into.CompletionTokens += rec.CompletionTokens
+			into.EstimatedTokens += rec.EstimatedTokens
+			into.CostUSD += rec.CostUSD
+			into.LatencyMS += rec.LatencyMS
+		} else {
+			rec.UserID = forgottenUserID
+			t.records[key] = rec
+		}
+		changed++
+	}
+	if changed > 0 {
+		t.dirty = true
+	}
+	return changed
+}
+
+// pruneLocked drops days older than usageRetentionDays.
+func (t *UsageTracker) pruneLocked() {
+	cutoff := t.now().UTC().AddDate(0, 0, -usageRetentionDays).Format("2006-01-02")
+	for key := range t.records {
+		if key.day < cutoff {
+			delete(t.records, key)
+		}
+	}
+}
+
+// Records returns the ledger rows for the last days days (0 for all),
+// oldest first.
+func (t *UsageTracker) Records(days int) []UsageRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := ""
+	if days > 0 {
+		cutoff = t.now().UTC().AddDate(0, 0, 1-days).Format("2006-01-02")
+	}
+	records := make([]UsageRecord, 0, len(t.records))
+	for _, rec := range t.records {
+		if rec.Day >= cutoff {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.ChannelID < b.ChannelID
+	})
+	return records
+}
+
+// WriteJSON exports the ledger for the last days days (0 for all).
+func (t *UsageTracker) WriteJSON(w io.Writer, days int) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t.Records(days))
+}
+
+// Persist loads the ledger from path, if it exists, and saves it there
+// every usageSaveInterval while it changes.
+func (t *UsageTracker) Persist(path string) error {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-configured path
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		var records []UsageRecord
+		if err := json.Unmarshal(data, &records); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		t.mu.Lock()
+		for i := range records {
+			rec := records[i]
+			t.records[usageKey{rec.Day, rec.Provider, rec.Model, rec.Platform, rec.UserID, rec.ChannelID}] = &rec
+		}
+		t.pruneLocked()
+		t.mu.Unlock()
+	}
+
+	go func() {
+		for range time.Tick(usageSaveInterval) {
+			if err := t.save(path); err != nil {
+				log.Printf("⚠️  Failed to save usage ledger: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// save writes the ledger atomically when it has changed since the last
+// save. A failed write leaves the ledger marked as changed, so the next save
+// tries again.
+func (t *UsageTracker) save(path string) error {
+	t.mu.Lock()
+	dirty := t.dirty
+	t.dirty = false
+	t.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	if err := t.writeFile(path); err != nil {
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *UsageTracker) writeFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600) // #nosec G304 -- operator-configured path
+	if err != nil {
+		return err
+	}
+	if err := t.WriteJSON(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// usageTotals sums ledger rows under one label for the report.
+type usageTotals struct {
+	label            string
+	requests, tokens int
+	cost             float64
+	latencyMS        int64
+	estimated        bool
+}
+
+func (u *usageTotals) add(rec UsageRecord) {
+	u.requests += rec.Requests
+	u.tokens += rec.PromptTokens + rec.CompletionTokens
+	u.cost += rec.CostUSD
+	u.latencyMS += rec.LatencyMS
+	if rec.EstimatedTokens > 0 {
+		u.estimated = true
+	}
+}
+
+func (u *usageTotals) String() string {
+	line := fmt.Sprintf("%d req, %s tokens", u.requests, formatTokenCount(u.tokens))
+	if u.estimated {
+		line += "*"
+	}
+	line += fmt.Sprintf(", $%.2f", u.cost)
+	if u.requests > 0 {
+		line += fmt.Sprintf(", avg %dms", u.latencyMS/int64(u.requests))
+	}
+	return line
+}
+
+func formatTokenCount(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// groupUsage totals records by label, most expensive (then busiest) first.
+func groupUsage(records []UsageRecord, label func(UsageRecord) string) []*usageTotals {
+	groups := make(map[string]*usageTotals)
+	for _, rec := range records {
+		name := label(rec)
+		group, ok := groups[name]
+		if !ok {
+			group = &usageTotals{label: name}
+			groups[name] = group
+		}
+		group.add(rec)
+	}
+	sorted := make([]*usageTotals, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].cost != sorted[j].cost {
+			return sorted[i].cost > sorted[j].cost
+		}
+		if sorted[i].tokens != sorted[j].tokens {
+			return sorted[i].tokens > sorted[j].tokens
+		}
+		return sorted[i].label < sorted[j].label
+	})
+	return sorted
+}
+
+// formatUsageReport renders the !usage and /kit usage report.
+func formatUsageReport(records []UsageRecord, days int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 **AI Usage (last %d days)**\n", days)
+	if len(records) == 0 {
+		b.WriteString("No provider calls recorded yet.")
+		return b.String()
+	}
+
+	var total usageTotals
+	for _, rec := range records {
+		total.add(rec)
+	}
+	fmt.Fprintf(&b, "• Total: %s\n", total.String())
+
+	sections := []struct {
+		title string
+		label func(UsageRecord) string
+	}{
+		{"By provider", func(r UsageRecord) string { return r.Provider + " (" + r.Model + ")" }},
+		{"Top users", func(r UsageRecord) string { return r.Platform + " user " + r.UserID }},
+		{"Top channels", func(r UsageRecord) string { return r.Platform + " channel " + r.ChannelID }},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&b, "\n**%s**\n", section.title)
+		for i, group := range groupUsage(records, section.label) {
+			if i == 5 {
+				break
+			}
+			fmt.Fprintf(&b, "  ◦ %s: %s\n", group.label, group.String())
+		}
+	}
+	if total.estimated {
+		b.WriteString("\n_* includes estimated token counts where the provider reported none_")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// handleUsageCommand implements !usage and /kit usage for admins. args may
+// hold a number of days; the export subcommand is handled by the adapters,
+// which send usageExport as a file.
+func handleUsageCommand(args, userID string) string {
+	if !isAdmin(userID) {
+		return "🔒 Only Kit admins can view usage."
+	}
+	if globalAIService == nil || globalAIService.usage == nil {
+		return "📊 Usage tracking is not available (no AI providers configured)."
+	}
+	days := defaultUsageReportDays
+	if fields := strings.Fields(args); len(fields) > 0 {
+		if _, err := fmt.Sscan(fields[0], &days); err != nil || days <= 0 {
+			return "❓ **Usage:** `usage [days]` or `usage export [days]`"
+		}
+	}
+	return formatUsageReport(globalAIService.usage.Records(days), days)
+}
+
+// usageExport returns the JSON export for an admin, or a message explaining
+// why there is none.
+func usageExport(args, userID string) ([]byte, string) {
+	if !isAdmin(userID) {
+		return nil, "🔒 Only Kit admins can export usage."
+	}
+	if globalAIService == nil || globalAIService.usage == nil {
+		return nil, "📊 Usage tracking is not available (no AI providers configured)."
+	}
+	days := 0
+	if fields := strings.Fields(args); len(fields) > 0 {
+		fmt.Sscan(fields[0], &days)
+	}
+	var b strings.Builder
+	if err := globalAIService.usage.WriteJSON(&b, days); err != nil {
+		return nil, "❌ Failed to export usage."
+	}
+	return []byte(b.String()), ""
+}
+
+// isUsageExport reports whether a usage command asks for the JSON export and
+// returns the remaining arguments.
+func isUsageExport(args string) (string, bool) {
+	fields := strings.Fields(args)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "export") {
+		return strings.Join(fields[1:], " "), true
+	}
+	return "", false
+}
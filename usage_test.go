@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageTrackerAggregatesAndPrices(t *testing.T) {
+	tracker := NewUsageTracker(PriceTable{
+		Models:    map[string]ModelPrice{"small": {Input: 1, Output: 2}},
+		Providers: map[string]ModelPrice{"groq": {Input: 10, Output: 10}},
+	})
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	req := ChatRequest{Platform: "slack", UserID: "U1", ChannelID: "C1"}
+	tracker.record(req, Usage{Provider: "gemini", Model: "small", PromptTokens: 1_000_000, CompletionTokens: 500_000, Latency: 100 * time.Millisecond})
+	tracker.record(req, Usage{Provider: "gemini", Model: "small", PromptTokens: 1_000_000, Latency: 300 * time.Millisecond})
+	tracker.record(req, Usage{Provider: "groq", Model: "unlisted", PromptTokens: 100_000, Estimated: true})
+	now = now.Add(24 * time.Hour)
+	tracker.record(req, Usage{Provider: "gemini", Model: "small", CompletionTokens: 10})
+
+	records := tracker.Records(0)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 ledger rows, got %+v", records)
+	}
+	first := records[0]
+	if first.Requests != 2 || first.PromptTokens != 2_000_000 || first.CompletionTokens != 500_000 || first.LatencyMS != 400 {
+		t.Fatalf("unexpected aggregate: %+v", first)
+	}
+	if first.CostUSD != 3 {
+		t.Fatalf("cost = %v, want 3", first.CostUSD)
+	}
+	if groq := records[1]; groq.CostUSD != 1 || groq.EstimatedTokens != 100_000 {
+		t.Fatalf("provider price fallback or estimate not applied: %+v", groq)
+	}
+	if got := tracker.Records(1); len(got) != 1 || got[0].Day != "2025-06-02" {
+		t.Fatalf("Records(1) = %+v", got)
+	}
+}
+
+func TestUsageSaveRetriesAfterFailure(t *testing.T) {
+	tracker := NewUsageTracker(PriceTable{})
+	tracker.record(ChatRequest{Platform: "slack", UserID: "U1"}, Usage{Provider: "gemini", PromptTokens: 10})
+
+	dir := filepath.Join(t.TempDir(), "later")
+	path := filepath.Join(dir, "usage.json")
+	if err := tracker.save(path); err == nil {
+		t.Fatal("save into a missing directory succeeded")
+	}
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `"gemini"`) {
+		t.Fatalf("ledger not saved after the failure: %s, %v", data, err)
+	}
+}
+
+func TestUsageReportAndExport(t *testing.T) {
+	tracker := NewUsageTracker(PriceTable{})
+	tracker.record(ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1"}, Usage{Provider: "claude", Model: "sonnet", PromptTokens: 1500, CompletionTokens: 200})
+
+	report := formatUsageReport(tracker.Records(7), 7)
+	for _, want := range []string{"last 7 days", "1 req, 1.7k tokens", "claude (sonnet)", "discord user u1", "discord channel c1"} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("report missing %q:\n%s", want, report)
+		}
+	}
+
+	var b strings.Builder
+	if err := tracker.WriteJSON(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	var exported []UsageRecord
+	if err := json.Unmarshal([]byte(b.String()), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || exported[0].UserID != "u1" || exported[0].PromptTokens != 1500 {
+		t.Fatalf("unexpected export: %+v", exported)
+	}
+}
+
+func TestRespondRecordsUsage(t *testing.T) {
+	echo := providerFunc{
+		name: "echo",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			return Reply{Text: "hi", Usage: Usage{Model: "echo-1", PromptTokens: 7, CompletionTokens: 3}}, nil
+		},
+	}
+	quiet := providerFunc{
+		name: "quiet",
+		fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			return Reply{Text: "hello there"}, nil
+		},
+	}
+	req := ChatRequest{Platform: "slack", UserID: "U1", ChannelID: "C1", Message: "hello"}
+
+	svc := NewAIService(nil, nil, echo)
+	svc.Respond(context.Background(), req)
+	records := svc.Usage().Records(0)
+	if len(records) != 1 || records[0].Provider != "echo" || records[0].Model != "echo-1" || records[0].PromptTokens != 7 || records[0].EstimatedTokens != 0 {
+		t.Fatalf("unexpected usage: %+v", records)
+	}
+
+	// Providers that report nothing are estimated from the text
+	svc = NewAIService(nil, nil, quiet)
+	svc.Respond(context.Background(), req)
+	records = svc.Usage().Records(0)
+	if len(records) != 1 || records[0].CompletionTokens == 0 || records[0].EstimatedTokens == 0 {
+		t.Fatalf("expected estimated usage, got %+v", records)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// UserDataStore is implemented by session stores that can find and erase
+// everything they keep for one user, for the export and forget commands.
+type UserDataStore interface {
+	// UserSessions returns copies of the user's sessions in every channel.
+	UserSessions(platform, userID string) ([]*Session, error)
+	// ForgetUser deletes the user's sessions and returns how many there were.
+	ForgetUser(platform, userID string) (int, error)
+	// ForgetSession deletes the user's session in one channel and reports
+	// whether there was one.
+	ForgetSession(platform, userID, channelID string) (bool, error)
+}
+
+// errNoUserData is returned when the session store can't look up users.
+var errNoUserData = errors.New("the session store does not support exporting or deleting user data")
+
+// forgottenUserID replaces a forgotten user's ID in the usage ledger and
+// the MCP audit log, so costs and tool calls still add up without saying
+// who asked.
+const forgottenUserID = "(forgotten)"
+
+// userOwner identifies a user across the stores that index by user.
+func userOwner(platform, userID string) string {
+	return platform + ":" + userID
+}
+
+// snapshot copies the stored fields of a session. Callers must not hold
+// s.mu.
+func (s *Session) snapshot() *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Session{
+		ID:                s.ID,
+		Platform:          s.Platform,
+		UserID:            s.UserID,
+		ChannelID:         s.ChannelID,
+		Messages:          append([]ChatMessage(nil), s.Messages...),
+		UpdatedAt:         s.UpdatedAt,
+		Summary:           s.Summary,
+		SummarizedThrough: s.SummarizedThrough,
+		Provider:          s.Provider,
+	}
+}
+
+// forget marks a deleted session so later writes to it are dropped.
+func (s *Session) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forgotten = true
+}
+
+// ForgetReport counts what ForgetUser removed.
+type ForgetReport struct {
+	Sessions     int
+	CacheEntries int
+	UsageRecords int // anonymized rather than deleted
+	AuditRecords int // MCP tool calls, anonymized too
+}
+
+// UserSessions returns the user's stored sessions on platform.
+func (a *AIService) UserSessions(platform, userID string) ([]*Session, error) {
+	store, ok := a.store.(UserDataStore)
+	if !ok {
+		return nil, errNoUserData
+	}
+	return store.UserSessions(platform, userID)
+}
+
+// ForgetUser deletes the user's sessions from the store, drops cached
+// replies to their questions and removes their ID from the usage ledger.
+// Rate-limit counters are kept: they expire within a day and forgetting
+// must not reset a quota.
+func (a *AIService) ForgetUser(platform, userID string) (ForgetReport, error) {
+	var report ForgetReport
+	store, ok := a.store.(UserDataStore)
+	if !ok {
+		return report, errNoUserData
+	}
+	sessions, err := store.ForgetUser(platform, userID)
+	if err != nil {
+		return report, err
+	}
+	report.Sessions = sessions
+	report.CacheEntries = a.cache.ForgetOwner(userOwner(platform, userID))
+	report.UsageRecords = a.usage.ForgetUser(platform, userID)
+	if report.AuditRecords, err = a.mcpAudit.forgetUser(platform, userID); err != nil {
+		return report, err
+	}
+	// The ID itself is left out of the log on purpose
+	log.Printf("🗑️  Forgot a %s user: %d sessions, %d cached replies, %d usage rows, %d tool calls",
+		platform, report.Sessions, report.CacheEntries, report.UsageRecords, report.AuditRecords)
+	return report, nil
+}
+
+// ForgetSession deletes one of the user's conversations. Unlike ForgetUser
+// it leaves cached replies and usage alone: the user is still around.
+func (a *AIService) ForgetSession(platform, userID, channelID string) (bool, error) {
+	store, ok := a.store.(UserDataStore)
+	if !ok {
+		return false, errNoUserData
+	}
+	return store.ForgetSession(platform, userID, channelID)
+}
+
+// Export formats for sessionsExport.
+const (
+	exportMarkdown = "markdown"
+	exportJSON     = "json"
+)
+
+// sessionsExport renders the user's sessions as Markdown or JSON and returns
+// the file contents and name, or a message explaining why there is none.
+func sessionsExport(platform, args, userID string) (data []byte, filename, reply string) {
+	format := exportMarkdown
+	if fields := strings.Fields(strings.ToLower(args)); len(fields) > 0 {
+		switch fields[0] {
+		case "json":
+			format = exportJSON
+		case "md", "markdown":
+		default:
+			return nil, "", "❓ **Usage:** `history export [markdown|json]`"
+		}
+	}
+	if globalAIService == nil {
+		return nil, "", "💭 Kit isn't keeping any conversations (no AI providers configured)."
+	}
+	sessions, err := globalAIService.UserSessions(platform, userID)
+	if err != nil {
+		log.Printf("❌ Failed to export sessions: %v", err)
+		return nil, "", "❌ Sorry, I couldn't export your conversations right now."
+	}
+	if len(sessions) == 0 {
+		return nil, "", "💭 I don't have any stored conversations with you."
+	}
+
+	exportedAt := time.Now().UTC()
+	if format == exportJSON {
+		data, err = json.MarshalIndent(map[string]any{
+			"platform":    platform,
+			"user_id":     userID,
+			"exported_at": exportedAt,
+			"sessions":    sessions,
+		}, "", "  ")
+		if err != nil {
+			return nil, "", "❌ Sorry, I couldn't export your conversations right now."
+		}
+		return data, "kit-history.json", ""
+	}
+	return []byte(formatSessionsMarkdown(sessions, platform, exportedAt)), "kit-history.md", ""
+}
+
+// formatSessionsMarkdown renders sessions as a readable transcript, one
+// section per channel.
+func formatSessionsMarkdown(sessions []*Session, platform string, exportedAt time.Time) string {
+	const stamp = "2006-01-02 15:04 UTC"
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Your conversations with Kit\n\nExported from %s on %s. ", platform, exportedAt.Format(stamp))
+	b.WriteString("Personal details that were masked before reaching the AI appear as placeholders like [EMAIL_1a2b].\n")
+	for _, session := range sessions {
+		where := "Direct messages"
+		if session.ChannelID != "" {
+			where = "Channel " + session.ChannelID
+		}
+		fmt.Fprintf(&b, "\n## %s\n\nLast active %s.\n", where, session.UpdatedAt.UTC().Format(stamp))
+		if session.Summary != "" {
+			fmt.Fprintf(&b, "\n_Summary of earlier messages:_ %s\n", session.Summary)
+		}
+		for _, msg := range session.Messages {
+			who := "You"
+			if msg.Role == "assistant" {
+				who = "Kit"
+			}
+			fmt.Fprintf(&b, "\n**%s** (%s):\n%s\n", who, msg.Timestamp.UTC().Format(stamp), msg.Content)
+		}
+	}
+	return b.String()
+}
+
+// handleForgetCommand implements !forget and /kit forget. Users delete their
+// own data with "forget confirm"; admins delete another user's with
+// "forget user <id>", where the ID may be prefixed with its platform
+// ("slack:U123") and defaults to the admin's.
+func handleForgetCommand(platform, args, userID string) string {
+	fields := strings.Fields(args)
+	target, targetPlatform := userID, platform
+	switch {
+	case len(fields) == 0:
+		prefix := commandPrefix(platform)
+		return "🗑️ **Forget me** deletes everything Kit keeps about you on " + platform + ": " +
+			"your conversation history in every channel, cached answers to your questions, " +
+			"and your user ID in the usage statistics. This can't be undone.\n\n" +
+			"Want a copy first? Use `" + prefix + "history export`. To go ahead, send `" + prefix + "forget confirm`."
+	case strings.EqualFold(fields[0], "confirm") && len(fields) == 1:
+	case strings.EqualFold(fields[0], "user") && len(fields) == 2:
+		if !isAdmin(userID) {
+			return "🔒 Only Kit admins can delete another user's data."
+		}
+		targetPlatform, target = parseUserRef(platform, fields[1])
+		if target == "" {
+			return "❓ **Usage:** `forget user <user ID or mention>`"
+		}
+	default:
+		return "❓ **Usage:** `forget`, `forget confirm` or `forget user <id>` (admins)"
+	}
+
+	if globalAIService == nil {
+		return "💭 Kit isn't keeping any conversations (no AI providers configured)."
+	}
+	report, err := globalAIService.ForgetUser(targetPlatform, target)
+	if err != nil {
+		log.Printf("❌ Failed to forget user data: %v", err)
+		return "❌ Sorry, I couldn't delete the data right now. Please try again or contact an admin."
+	}
+	whose := "your"
+	if target != userID || targetPlatform != platform {
+		whose = fmt.Sprintf("%s user `%s`'s", targetPlatform, target)
+	}
+	return fmt.Sprintf("🗑️ Done. I deleted %s %d conversations and %d cached answers, and removed the user ID from %d usage records and %d tool call records.",
+		whose, report.Sessions, report.CacheEntries, report.UsageRecords, report.AuditRecords)
+}
+
+// commandPrefix is how commands start on platform.
+func commandPrefix(platform string) string {
+	if platform == "slack" {
+		return "/kit "
+	}
+	return "!"
+}
+
+// parseUserRef reads a user ID, a Slack or Discord mention, or a
+// "platform:id" reference.
+func parseUserRef(platform, ref string) (string, string) {
+	ref = strings.TrimSuffix(strings.TrimPrefix(ref, "<@"), ">")
+	ref = strings.TrimPrefix(ref, "!")
+	ref, _, _ = strings.Cut(ref, "|") // Slack's <@U123|name>
+	if prefix, id, ok := strings.Cut(ref, ":"); ok && (prefix == "slack" || prefix == "discord") {
+		return prefix, id
+	}
+	return platform, ref
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestService makes svc the global service and userID an admin for the
+// duration of the test.
+func useTestService(t *testing.T, svc *AIService, admins ...string) {
+	t.Helper()
+	oldService, oldAdmins := globalAIService, globalAdminIDs
+	globalAIService, globalAdminIDs = svc, admins
+	t.Cleanup(func() { globalAIService, globalAdminIDs = oldService, oldAdmins })
+}
+
+func TestHistoryExportAndForget(t *testing.T) {
+	echo := providerFunc{name: "echo", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+		return Reply{Text: "re: " + message}, nil
+	}}
+	svc := NewAIService(nil, nil, echo)
+	svc.SetResponseCache(NewResponseCache(time.Hour, 10))
+	useTestService(t, svc, "admin")
+	for _, req := range []ChatRequest{
+		{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "What is Kit?"},
+		{Platform: "discord", UserID: "u1", Message: "my secret plans"},
+		{Platform: "discord", UserID: "u2", ChannelID: "c1", Message: "hi"},
+	} {
+		svc.Respond(context.Background(), req)
+	}
+
+	data, filename, reply := sessionsExport("discord", "", "u1")
+	if filename != "kit-history.md" || !strings.Contains(string(data), "## Channel c1") || !strings.Contains(string(data), "**Kit**") || strings.Contains(string(data), "re: hi") {
+		t.Fatalf("markdown export %q %q:\n%s", filename, reply, data)
+	}
+	data, _, _ = sessionsExport("discord", "json", "u1")
+	var exported struct{ Sessions []Session }
+	if err := json.Unmarshal(data, &exported); err != nil || len(exported.Sessions) != 2 || len(exported.Sessions[0].Messages) != 2 {
+		t.Fatalf("json export %v:\n%s", err, data)
+	}
+
+	if reply := handleForgetCommand("discord", "", "u1"); !strings.Contains(reply, "!forget confirm") {
+		t.Fatalf("forget should ask for confirmation first: %q", reply)
+	}
+	if reply := handleForgetCommand("discord", "confirm", "u1"); !strings.Contains(reply, "your 2 conversations and 2 cached") {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	if _, _, reply := sessionsExport("discord", "", "u1"); !strings.Contains(reply, "don't have any") {
+		t.Fatalf("sessions left after forget: %q", reply)
+	}
+	for _, rec := range svc.Usage().Records(0) {
+		if rec.UserID == "u1" {
+			t.Fatalf("usage still names the user: %+v", rec)
+		}
+	}
+
+	if reply := handleForgetCommand("discord", "user <@u2>", "u1"); !strings.Contains(reply, "Only Kit admins") {
+		t.Fatalf("non-admin deleted another user: %q", reply)
+	}
+	if reply := handleForgetCommand("slack", "user discord:u2", "admin"); !strings.Contains(reply, "discord user `u2`'s 1 conversations") {
+		t.Fatalf("admin forget: %q", reply)
+	}
+}
+
+func TestForgetUserInPersistentStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	fileStore, err := NewFileSessionStore(path, SessionRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+	redisStore, _ := newTestRedisStore(t, 10, time.Hour)
+
+	for _, store := range []interface {
+		SessionStore
+		UserDataStore
+	}{fileStore, redisStore} {
+		for _, channel := range []string{"", "c1", "c2"} {
+			store.Append(store.GetOrCreate("slack", "u1", channel), "user", "private note in "+channel)
+		}
+		store.Append(store.GetOrCreate("slack", "u10", "c1"), "user", "someone else")
+
+		sessions, err := store.UserSessions("slack", "u1")
+		if err != nil || len(sessions) != 3 {
+			t.Fatalf("%T: %d sessions, %v", store, len(sessions), err)
+		}
+		if n, err := store.ForgetUser("slack", "u1"); err != nil || n != 3 {
+			t.Fatalf("%T: forgot %d sessions, %v", store, n, err)
+		}
+		if sessions, _ := store.UserSessions("slack", "u10"); len(sessions) != 1 {
+			t.Fatalf("%T: another user's session was deleted", store)
+		}
+		if len(store.GetOrCreate("slack", "u1", "c1").Messages) != 0 {
+			t.Fatalf("%T: history survived forget", store)
+		}
+
+		store.Append(store.GetOrCreate("slack", "u10", "c2"), "user", "a note to keep")
+		if removed, err := store.ForgetSession("slack", "u10", "c1"); err != nil || !removed {
+			t.Fatalf("%T: ForgetSession = %v, %v", store, removed, err)
+		}
+		if removed, _ := store.ForgetSession("slack", "u10", "c1"); removed {
+			t.Fatalf("%T: a session was forgotten twice", store)
+		}
+		if sessions, _ := store.UserSessions("slack", "u10"); len(sessions) != 1 || sessions[0].ChannelID != "c2" {
+			t.Fatalf("%T: ForgetSession left %+v", store, sessions)
+		}
+	}
+
+	journal, err := os.ReadFile(path)
+	if err != nil || strings.Contains(string(journal), "private note") {
+		t.Fatalf("journal still holds the user's messages: %v\n%s", err, journal)
+	}
+}
+
+func TestForgetUserDuringSlowSummaryAndReply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	fileStore, err := NewFileSessionStore(path, SessionRetention{MaxMessages: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+	redisStore, mr := newTestRedisStore(t, 50, time.Hour)
+
+	for _, store := range []SessionStore{NewInMemorySessionStore(SessionRetention{MaxMessages: 50}), fileStore, redisStore} {
+		started := make(chan string, 2)
+		release := make(chan struct{})
+		slow := providerFunc{name: "slow", fn: func(ctx context.Context, message string, session *Session) (Reply, error) {
+			if strings.HasPrefix(message, "Summarize") || message == "slow question" {
+				started <- message
+				<-release
+			}
+			return Reply{Text: "ok"}, nil
+		}}
+		svc := NewAIService(store, nil, slow)
+		svc.SetContextBudget(60)
+
+		req := ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: strings.Repeat("camp dates ", 10)}
+		svc.Respond(context.Background(), req)
+		svc.Respond(context.Background(), req) // overflows; the summary starts
+		<-started
+		replied := make(chan struct{})
+		go func() {
+			defer close(replied)
+			svc.Respond(context.Background(), ChatRequest{Platform: "discord", UserID: "u1", ChannelID: "c1", Message: "slow question"})
+		}()
+		<-started
+
+		if report, err := svc.ForgetUser("discord", "u1"); err != nil || report.Sessions != 1 {
+			t.Fatalf("%T: forget = %+v, %v", store, report, err)
+		}
+		close(release)
+		<-replied
+		svc.background.Wait()
+
+		if sessions, _ := store.(UserDataStore).UserSessions("discord", "u1"); len(sessions) != 0 {
+			t.Fatalf("%T: forgotten session written back: %+v", store, sessions[0])
+		}
+	}
+
+	reopened, err := NewFileSessionStore(path, SessionRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 0 {
+		t.Fatal("the journal brought a forgotten session back")
+	}
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, "kit:session:") {
+			t.Fatalf("Redis key %s survived forget", key)
+		}
+	}
+}